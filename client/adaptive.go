@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const adaptiveWindow = 20
+
+// limitChange records when the adaptive limiter moved to a new limit.
+type limitChange struct {
+	at    time.Duration // since the limiter was created
+	limit int
+}
+
+// adaptiveLimiter bounds the number of workers allowed to have a request in
+// flight using AIMD: the limit is halved when the recent error rate or
+// average latency crosses its threshold and grows by one after each healthy
+// window, never exceeding max.
+type adaptiveLimiter struct {
+	mu               sync.Mutex
+	cond             *sync.Cond
+	limit            int
+	max              int
+	active           int
+	window           int
+	errorThreshold   float64
+	latencyThreshold time.Duration // zero disables the latency signal
+
+	// Outcomes observed since the last adjustment
+	samples    int
+	failures   int
+	latencySum time.Duration
+
+	start      time.Time
+	trajectory []limitChange
+}
+
+func newAdaptiveLimiter(max, window int, errorThreshold float64, latencyThreshold time.Duration) *adaptiveLimiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &adaptiveLimiter{
+		limit:            max,
+		max:              max,
+		window:           window,
+		errorThreshold:   errorThreshold,
+		latencyThreshold: latencyThreshold,
+		start:            time.Now(),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	l.trajectory = []limitChange{{at: 0, limit: max}}
+	return l
+}
+
+// Acquire blocks until a slot is available under the current limit,
+// returning ctx's error without taking a slot if ctx is done first.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) error {
+	// Wake the waiters so a cancelled one can notice; taking the lock
+	// ensures the broadcast can't slip in before it starts waiting
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.active++
+	return nil
+}
+
+// Release frees a slot and feeds the request outcome into the current window.
+func (l *adaptiveLimiter) Release(success bool, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+
+	l.samples++
+	if !success {
+		l.failures++
+	}
+	l.latencySum += latency
+	if l.samples >= l.window {
+		l.adjust()
+	}
+	l.cond.Broadcast()
+}
+
+// adjust applies AIMD to the completed window. Callers must hold l.mu.
+func (l *adaptiveLimiter) adjust() {
+	errorRate := float64(l.failures) / float64(l.samples)
+	avgLatency := l.latencySum / time.Duration(l.samples)
+	l.samples, l.failures, l.latencySum = 0, 0, 0
+
+	next := l.limit
+	if errorRate > l.errorThreshold || (l.latencyThreshold > 0 && avgLatency > l.latencyThreshold) {
+		next = l.limit / 2
+		if next < 1 {
+			next = 1
+		}
+	} else if l.limit < l.max {
+		next = l.limit + 1
+	}
+	if next == l.limit {
+		return
+	}
+
+	log.Printf("adaptive concurrency %d -> %d (error rate=%.2f, avg latency=%s)", l.limit, next, errorRate, avgLatency)
+	l.limit = next
+	l.trajectory = append(l.trajectory, limitChange{at: time.Since(l.start), limit: next})
+}
+
+// Limit returns the current concurrency limit.
+func (l *adaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Trajectory formats the limit history as "limit@offset" pairs.
+func (l *adaptiveLimiter) Trajectory() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	parts := make([]string, len(l.trajectory))
+	for i, c := range l.trajectory {
+		parts[i] = fmt.Sprintf("%d@%s", c.limit, c.at.Round(time.Millisecond))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	l := newAdaptiveLimiter(8, 4, 0.25, 0)
+
+	// A window with 50% errors halves the limit
+	for i := 0; i < 4; i++ {
+		l.Acquire(context.Background())
+		l.Release(i%2 == 0, time.Millisecond)
+	}
+	if got := l.Limit(); got != 4 {
+		t.Fatalf("expected limit 4 after unhealthy window, got %d", got)
+	}
+
+	// A healthy window grows it by one
+	for i := 0; i < 4; i++ {
+		l.Acquire(context.Background())
+		l.Release(true, time.Millisecond)
+	}
+	if got := l.Limit(); got != 5 {
+		t.Fatalf("expected limit 5 after healthy window, got %d", got)
+	}
+
+	if got := l.Trajectory(); !strings.HasPrefix(got, "8@0s 4@") || strings.Count(got, "@") != 3 {
+		t.Errorf("unexpected trajectory %q", got)
+	}
+}
+
+func TestAdaptiveLimiterLatencySignal(t *testing.T) {
+	l := newAdaptiveLimiter(4, 2, 1, 50*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		l.Acquire(context.Background())
+		l.Release(true, 100*time.Millisecond)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("expected slow window to halve limit to 2, got %d", got)
+	}
+}
+
+func TestAdaptiveLimiterNeverBelowOne(t *testing.T) {
+	l := newAdaptiveLimiter(2, 1, 0, 0)
+	for i := 0; i < 5; i++ {
+		l.Acquire(context.Background())
+		l.Release(false, 0)
+	}
+	if got := l.Limit(); got != 1 {
+		t.Errorf("expected limit floor of 1, got %d", got)
+	}
+}
+
+func TestAdaptiveConcurrencyBacksOffOnServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, maxRetries: 0, concurrency: 4, total: 20, model: "closed"}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	limiter := newAdaptiveLimiter(cfg.concurrency, 5, 0.1, 0)
+	lr.adaptive = limiter
+	lr.execute(context.Background())
+
+	if got := limiter.Limit(); got >= cfg.concurrency {
+		t.Errorf("expected active concurrency to drop below %d, got %d", cfg.concurrency, got)
+	}
+}
+
+func TestAdaptiveLimiterAcquireCancelled(t *testing.T) {
+	l := newAdaptiveLimiter(1, 10, 0.5, 0)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Acquire(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Acquire to return on cancel")
+	}
+
+	// The cancelled caller must not have taken a slot
+	l.Release(true, time.Millisecond)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
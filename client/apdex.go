@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// apdexCounter classifies requests against the Apdex target threshold:
+// satisfied at or under it, tolerating at or under four times it, and
+// frustrated when slower than that or failed. It is safe for concurrent use
+// by workers.
+type apdexCounter struct {
+	threshold  time.Duration
+	satisfied  atomic.Int64
+	tolerating atomic.Int64
+	frustrated atomic.Int64
+}
+
+func newApdexCounter(threshold time.Duration) *apdexCounter {
+	return &apdexCounter{threshold: threshold}
+}
+
+func (a *apdexCounter) Observe(success bool, latency time.Duration) {
+	switch {
+	case !success || latency > 4*a.threshold:
+		a.frustrated.Add(1)
+	case latency > a.threshold:
+		a.tolerating.Add(1)
+	default:
+		a.satisfied.Add(1)
+	}
+}
+
+// Score returns (satisfied + tolerating/2) / total, or 0 with no requests.
+func (a *apdexCounter) Score() float64 {
+	satisfied, tolerating := a.satisfied.Load(), a.tolerating.Load()
+	total := satisfied + tolerating + a.frustrated.Load()
+	if total == 0 {
+		return 0
+	}
+	return (float64(satisfied) + float64(tolerating)/2) / float64(total)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestApdexScore(t *testing.T) {
+	a := newApdexCounter(100 * time.Millisecond)
+	observations := []struct {
+		success bool
+		latency time.Duration
+	}{
+		{true, 20 * time.Millisecond},  // satisfied
+		{true, 100 * time.Millisecond}, // satisfied, at the threshold
+		{true, 150 * time.Millisecond}, // tolerating
+		{true, 400 * time.Millisecond}, // tolerating, at 4T
+		{true, 401 * time.Millisecond}, // frustrated
+		{false, 10 * time.Millisecond}, // frustrated: failed
+	}
+	for _, o := range observations {
+		a.Observe(o.success, o.latency)
+	}
+
+	if a.satisfied.Load() != 2 || a.tolerating.Load() != 2 || a.frustrated.Load() != 2 {
+		t.Fatalf("expected 2/2/2, got satisfied=%d tolerating=%d frustrated=%d",
+			a.satisfied.Load(), a.tolerating.Load(), a.frustrated.Load())
+	}
+	want := (2 + 2.0/2) / 6
+	if got := a.Score(); math.Abs(got-want) > 1e-9 {
+		t.Errorf("expected apdex %.4f, got %.4f", want, got)
+	}
+}
+
+func TestApdexScoreEmpty(t *testing.T) {
+	if got := newApdexCounter(time.Second).Score(); got != 0 {
+		t.Errorf("expected 0 with no requests, got %v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	backoffConstant    = "constant"
+	backoffLinear      = "linear"
+	backoffExponential = "exponential"
+
+	defaultBackoffBase = 100 * time.Millisecond
+	defaultBackoffCap  = 2 * time.Second
+
+	defaultRetryAfterMax = 30 * time.Second
+)
+
+// backoffFor returns how long to wait before retrying after the given
+// zero-based attempt failed, following cfg.backoff:
+//
+//	constant:    base, base, base, ...
+//	linear:      base, 2*base, 3*base, ...
+//	exponential: base, 2*base, 4*base, ...
+//
+// The result never exceeds cfg.backoffCap. An empty strategy is exponential,
+// and a zero base or cap takes the default, so a zero config backs off the
+// way the client always has.
+func backoffFor(attempt int, cfg config) time.Duration {
+	base, limit := cfg.backoffBase, cfg.backoffCap
+	if base == 0 {
+		base = defaultBackoffBase
+	}
+	if limit == 0 {
+		limit = defaultBackoffCap
+	}
+
+	var d time.Duration
+	switch cfg.backoff {
+	case backoffConstant:
+		d = base
+	case backoffLinear:
+		if n := time.Duration(attempt + 1); base > limit/n {
+			d = limit
+		} else {
+			d = base * n
+		}
+	default:
+		// Doubling step by step, stopping at the cap, can't overflow
+		d = base
+		for i := 0; i < attempt && d < limit; i++ {
+			d *= 2
+		}
+	}
+	return min(d, limit)
+}
+
+// retryAfter reads the Retry-After header of a 429 or 503 response, in
+// either its delay-seconds or HTTP-date form, reporting false if there is
+// none or it can't be parsed. A date in the past means retry now.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// retryDelay is the wait before the next attempt: the server's Retry-After,
+// if it sent one, capped at cfg.retryAfterMax (defaultRetryAfterMax if
+// zero), and otherwise backoffFor.
+func retryDelay(attempt int, cfg config, after time.Duration, ok bool) time.Duration {
+	if !ok {
+		return backoffFor(attempt, cfg)
+	}
+	limit := cfg.retryAfterMax
+	if limit == 0 {
+		limit = defaultRetryAfterMax
+	}
+	return min(after, limit)
+}
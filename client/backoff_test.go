@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffFor(t *testing.T) {
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name string
+		cfg  config
+		want []time.Duration
+	}{
+		{
+			name: "constant",
+			cfg:  config{backoff: backoffConstant, backoffBase: 50 * ms, backoffCap: time.Second},
+			want: []time.Duration{50 * ms, 50 * ms, 50 * ms, 50 * ms, 50 * ms},
+		},
+		{
+			name: "linear",
+			cfg:  config{backoff: backoffLinear, backoffBase: 100 * ms, backoffCap: 350 * ms},
+			want: []time.Duration{100 * ms, 200 * ms, 300 * ms, 350 * ms, 350 * ms},
+		},
+		{
+			name: "exponential",
+			cfg:  config{backoff: backoffExponential, backoffBase: 100 * ms, backoffCap: time.Second},
+			want: []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, time.Second},
+		},
+		{
+			name: "constant above cap",
+			cfg:  config{backoff: backoffConstant, backoffBase: 2 * time.Second, backoffCap: time.Second},
+			want: []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+		},
+		{
+			name: "zero config keeps the original defaults",
+			cfg:  config{},
+			want: []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1600 * ms, 2 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []time.Duration
+			for attempt := range tt.want {
+				got = append(got, backoffFor(attempt, tt.cfg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBackoffForLargeAttempts(t *testing.T) {
+	for _, strategy := range []string{backoffLinear, backoffExponential} {
+		cfg := config{backoff: strategy, backoffBase: time.Hour, backoffCap: 24 * time.Hour}
+		if got := backoffFor(1000, cfg); got != cfg.backoffCap {
+			t.Errorf("%s: expected the cap %v for a large attempt, got %v", strategy, cfg.backoffCap, got)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"seconds", http.StatusTooManyRequests, "3", 3 * time.Second, true},
+		{"http date", http.StatusServiceUnavailable, "Wed, 01 May 2024 12:00:05 GMT", 5 * time.Second, true},
+		{"date in the past", http.StatusTooManyRequests, "Wed, 01 May 2024 11:00:00 GMT", 0, true},
+		{"absent", http.StatusTooManyRequests, "", 0, false},
+		{"garbage", http.StatusTooManyRequests, "soon", 0, false},
+		{"negative", http.StatusTooManyRequests, "-1", 0, false},
+		{"other status", http.StatusInternalServerError, "3", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("expected %v, %v; got %v, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cfg := config{backoff: backoffExponential, backoffBase: 100 * time.Millisecond, backoffCap: time.Second, retryAfterMax: 10 * time.Second}
+	if got := retryDelay(2, cfg, 0, false); got != 400*time.Millisecond {
+		t.Errorf("expected the exponential backoff without Retry-After, got %v", got)
+	}
+	if got := retryDelay(2, cfg, 5*time.Second, true); got != 5*time.Second {
+		t.Errorf("expected Retry-After to replace the backoff, got %v", got)
+	}
+	if got := retryDelay(2, cfg, time.Minute, true); got != 10*time.Second {
+		t.Errorf("expected Retry-After capped at -retry-after-max, got %v", got)
+	}
+	if got := retryDelay(0, config{}, time.Hour, true); got != defaultRetryAfterMax {
+		t.Errorf("expected the default cap %v, got %v", defaultRetryAfterMax, got)
+	}
+}
+
+func TestDoRequestHonorsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter func() string
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		{"seconds", func() string { return "1" }, 900 * time.Millisecond, 1500 * time.Millisecond},
+		// HTTP dates have one-second resolution, so this is 1-2s away
+		{"http date", func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) }, 900 * time.Millisecond, 2500 * time.Millisecond},
+		{"absent", func() string { return "" }, 0, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					if v := tt.retryAfter(); v != "" {
+						w.Header().Set("Retry-After", v)
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			defer server.Close()
+
+			cfg := config{target: server.URL, method: http.MethodGet, maxRetries: 1, backoffBase: 10 * time.Millisecond}
+			start := time.Now()
+			rec := doRequest(context.Background(), 0, 1, cfg, &http.Client{Timeout: time.Second}, "test-trace", nil)
+			elapsed := time.Since(start)
+
+			if !rec.Success || rec.Attempts != 2 {
+				t.Fatalf("expected success on the retry, got %+v", rec)
+			}
+			if elapsed < tt.minWait || elapsed > tt.maxWait {
+				t.Errorf("expected to wait between %v and %v, took %v", tt.minWait, tt.maxWait, elapsed)
+			}
+		})
+	}
+}
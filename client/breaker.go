@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops the workers hammering a target that is down. After
+// threshold consecutive failed attempts it opens and every request fails
+// fast for cooldown; then it half-opens and lets a single probe through,
+// closing again if the probe succeeds and reopening if it fails. It is
+// shared by all workers.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int // consecutive, while closed
+	openedAt time.Time
+	// probeAt is when the half-open probe was let through; another is
+	// allowed if it hasn't reported back within cooldown
+	probeAt time.Time
+
+	opened         int
+	shortCircuited int
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether an attempt may go ahead, counting it as
+// short-circuited if not.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) >= b.cooldown {
+			b.state, b.probeAt = breakerHalfOpen, now
+			return true
+		}
+	case breakerHalfOpen:
+		if now.Sub(b.probeAt) >= b.cooldown {
+			b.probeAt = now
+			return true
+		}
+	default:
+		return true
+	}
+	b.shortCircuited++
+	return false
+}
+
+// Record reports the outcome of an allowed attempt.
+func (b *circuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state, b.openedAt = breakerOpen, b.now()
+		b.opened++
+	}
+}
+
+// Stats returns how many times the breaker has opened and how many attempts
+// it has failed fast.
+func (b *circuitBreaker) Stats() (opened, shortCircuited int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opened, b.shortCircuited
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(3, time.Second)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("attempt %d: expected the closed breaker to allow", i)
+		}
+		b.Record(false)
+	}
+	// A success resets the consecutive count
+	b.Record(true)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("attempt %d: expected the closed breaker to allow", i)
+		}
+		b.Record(false)
+	}
+	if b.Allow() {
+		t.Fatal("expected the breaker to open after 3 consecutive failures")
+	}
+
+	// After the cooldown one probe goes through; the rest still fail fast
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("expected only one probe while half-open")
+	}
+	b.Record(false)
+	if b.Allow() {
+		t.Fatal("expected a failed probe to reopen the breaker")
+	}
+
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe after the second cooldown")
+	}
+	b.Record(true)
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("expected a successful probe to close the breaker")
+	}
+
+	if opened, shortCircuited := b.Stats(); opened != 2 || shortCircuited != 3 {
+		t.Errorf("expected opened=2 short-circuited=3, got opened=%d short-circuited=%d", opened, shortCircuited)
+	}
+}
+
+func TestCircuitBreakerStalledProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(1, time.Second)
+	b.now = func() time.Time { return now }
+	b.Allow()
+	b.Record(false)
+
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe after the cooldown")
+	}
+	// A probe that never reports back doesn't keep the breaker shut forever
+	now = now.Add(time.Second)
+	if !b.Allow() {
+		t.Error("expected another probe once the first is a cooldown overdue")
+	}
+}
+
+func TestLoadRunCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var hits atomic.Int64
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:           server.URL,
+		total:            10,
+		concurrency:      1,
+		model:            "closed",
+		timeout:          time.Second,
+		breakerThreshold: 2,
+		breakerCooldown:  time.Hour,
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: cfg.timeout})
+	lr.execute(context.Background())
+
+	// Two 500s open the breaker and the other eight never reach the server
+	if got := hits.Load(); got != 2 {
+		t.Errorf("expected the server to see 2 requests, got %d", got)
+	}
+	summary := lr.results.Summary()
+	if summary.ErrorCounts[errClassCircuitOpen] != 8 || summary.ErrorCounts[errClassHTTP5xx] != 2 {
+		t.Errorf("expected 8 circuit-open and 2 http-5xx failures, got %v", summary.ErrorCounts)
+	}
+	if opened, shortCircuited := lr.failures.breaker.Stats(); opened != 1 || shortCircuited != 8 {
+		t.Errorf("expected opened=1 short-circuited=8, got opened=%d short-circuited=%d", opened, shortCircuited)
+	}
+
+	// Once the target recovers, the probe after the cooldown closes it again
+	down.Store(false)
+	lr.failures.breaker.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if ok, _ := doRequestWithRetry(context.Background(), 0, 0, cfg, lr.client, "trace", &lr.failures); !ok {
+		t.Error("expected the probe request to succeed")
+	}
+	if !lr.failures.allow() {
+		t.Error("expected the breaker to close after a successful probe")
+	}
+}
+
+func TestValidateBreaker(t *testing.T) {
+	if err := (config{model: "closed", breakerThreshold: -1}).validate(); err == nil {
+		t.Error("expected error for negative -breaker-threshold")
+	}
+	if err := (config{model: "closed", breakerCooldown: -time.Second}).validate(); err == nil {
+		t.Error("expected error for negative -breaker-cooldown")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+const bytesPerGB = 1e9
+
+// byteCounter totals the bytes written to and read from the network by every
+// connection the client dials, headers and TLS overhead included.
+type byteCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// dialContext wraps dial so that every connection it returns is counted.
+func (c *byteCounter) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, counter: c}, nil
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+// estimateCost prices received (ingress) and sent (egress) bytes at the given
+// per-GB rates.
+func estimateCost(received, sent int64, perGBIn, perGBOut float64) float64 {
+	return float64(received)/bytesPerGB*perGBIn + float64(sent)/bytesPerGB*perGBOut
+}
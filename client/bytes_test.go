@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		name              string
+		received, sent    int64
+		perGBIn, perGBOut float64
+		want              float64
+	}{
+		{"ingress only", 2e9, 0, 0.05, 0.09, 0.10},
+		{"egress only", 0, 5e8, 0.05, 0.09, 0.045},
+		{"both", 1e9, 1e9, 0.01, 0.09, 0.10},
+		{"free", 123456, 654321, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimateCost(tt.received, tt.sent, tt.perGBIn, tt.perGBOut)
+			if math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("estimateCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteCounterCountsWireBytes(t *testing.T) {
+	body := strings.Repeat("a", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	counter := &byteCounter{}
+	client, err := newHTTPClient(config{timeout: time.Second}, counter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Headers add to both directions, so only lower bounds are exact
+	if got := counter.sent.Load(); got <= 0 {
+		t.Errorf("expected request bytes to be counted, got %d", got)
+	}
+	if got := counter.received.Load(); got < int64(len(body)) {
+		t.Errorf("expected at least %d received bytes, got %d", len(body), got)
+	}
+}
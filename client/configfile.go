@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exclusiveFlags are pairs of flags that can't both be given, whether on
+// the command line or in a -config file.
+var exclusiveFlags = [][2]string{
+	{"count", "duration"},
+	{"body", "body-file"},
+}
+
+// applyConfigFile sets flags on fs from the YAML file at path, whose keys
+// are flag names without the dash, e.g. "concurrency: 10" or "rps: 50".
+// A list sets a repeatable flag such as target or header once per item.
+// Flags given on the command line win over the file, and the file wins
+// over environment variables. An empty path does nothing.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		items, isList := values[name].([]any)
+		if !isList {
+			items = []any{values[name]}
+		}
+		for _, item := range items {
+			switch item.(type) {
+			case map[string]any, []any:
+				return fmt.Errorf("option %q: want a value or a list of values", name)
+			}
+			if err := fs.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("option %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExclusiveFlags reports the first pair of exclusiveFlags that were
+// both set on fs.
+func checkExclusiveFlags(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, pair := range exclusiveFlags {
+		if set[pair[0]] && set[pair[1]] {
+			return errors.New("-" + strings.Join(pair[:], " and -") + " are mutually exclusive")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestFlagSet registers a few of the client's flags on a fresh set.
+func newTestFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.IntVar(&cfg.total, "count", 20, "")
+	fs.DurationVar(&cfg.duration, "duration", 0, "")
+	fs.IntVar(&cfg.concurrency, "concurrency", 2, "")
+	fs.Float64Var(&cfg.netLoss, "net-loss", 0, "")
+	fs.BoolVar(&cfg.insecure, "insecure", false, "")
+	fs.Var(cfg.headers, "header", "")
+	fs.String("body", "", "")
+	fs.String("body-file", "", "")
+	fs.String("config", "", "")
+	return fs
+}
+
+func writeClientConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "client.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestApplyConfigFilePrecedence(t *testing.T) {
+	path := writeClientConfig(t, "count: 50\nconcurrency: 8\nnet-loss: 0.25\ninsecure: true\nheader:\n  - \"X-A: 1\"\n  - \"X-B: 2\"\n")
+
+	cfg := config{headers: headerFlags{}}
+	fs := newTestFlagSet(&cfg)
+	if err := fs.Parse([]string{"-count", "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(fs, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.total != 10 {
+		t.Errorf("expected the command line -count 10 to win, got %d", cfg.total)
+	}
+	if cfg.concurrency != 8 || cfg.netLoss != 0.25 || !cfg.insecure {
+		t.Errorf("expected concurrency=8 net-loss=0.25 insecure from the file, got %d %v %v", cfg.concurrency, cfg.netLoss, cfg.insecure)
+	}
+	if want := (headerFlags{"X-A": {"1"}, "X-B": {"2"}}); !reflect.DeepEqual(cfg.headers, want) {
+		t.Errorf("expected headers %v, got %v", want, cfg.headers)
+	}
+	if cfg.duration != 0 {
+		t.Errorf("expected the default -duration, got %v", cfg.duration)
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"unknown option", "concurency: 3\n", `unknown option "concurency"`},
+		{"bad value", "count: many\n", `option "count"`},
+		{"nested map", "count:\n  value: 3\n", "want a value or a list"},
+		{"config itself", "config: other.yaml\n", `unknown option "config"`},
+		{"malformed", "count: [1\n", "parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{headers: headerFlags{}}
+			err := applyConfigFile(newTestFlagSet(&cfg), writeClientConfig(t, tt.contents))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+	cfg := config{headers: headerFlags{}}
+	if err := applyConfigFile(newTestFlagSet(&cfg), filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestCheckExclusiveFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		file string
+		want string
+	}{
+		{"count alone", []string{"-count", "5"}, "", ""},
+		{"count and duration", []string{"-count", "5", "-duration", "1m"}, "", "-count and -duration are mutually exclusive"},
+		{"duration in the file", []string{"-count", "5"}, "duration: 1m\n", "-count and -duration are mutually exclusive"},
+		{"body and body-file", []string{"-body", "x", "-body-file", "b.json"}, "", "-body and -body-file are mutually exclusive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{headers: headerFlags{}}
+			fs := newTestFlagSet(&cfg)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if tt.file != "" {
+				if err := applyConfigFile(fs, writeClientConfig(t, tt.file)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			err := checkExclusiveFlags(fs)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
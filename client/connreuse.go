@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// connTracker counts whether each request went out on a reused keep-alive
+// connection or a new one. It wraps the client's transport and hooks every
+// request with an httptrace.ClientTrace.
+type connTracker struct {
+	next   http.RoundTripper
+	reused atomic.Int64
+	fresh  atomic.Int64
+}
+
+func newConnTracker(next http.RoundTripper) *connTracker {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &connTracker{next: next}
+}
+
+func (t *connTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.reused.Add(1)
+			} else {
+				t.fresh.Add(1)
+			}
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// ReuseRate is the share (0-1) of connections handed out that were reused.
+func (t *connTracker) ReuseRate() float64 {
+	reused, fresh := t.reused.Load(), t.fresh.Load()
+	if reused+fresh == 0 {
+		return 0
+	}
+	return float64(reused) / float64(reused+fresh)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnTrackerObservesReuse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{
+		target:          server.URL,
+		total:           20,
+		concurrency:     1,
+		model:           "closed",
+		timeout:         time.Second,
+		maxIdleConns:    100,
+		idleConnTimeout: time.Minute,
+	}
+	client, err := newHTTPClient(cfg, &byteCounter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lr := newLoadRun(cfg, client)
+	lr.execute(context.Background())
+
+	// One worker on a keep-alive server dials once and reuses it after
+	if fresh, reused := lr.conns.fresh.Load(), lr.conns.reused.Load(); fresh != 1 || reused != 19 {
+		t.Errorf("expected 1 new and 19 reused connections, got %d new and %d reused", fresh, reused)
+	}
+	if rate := lr.conns.ReuseRate(); rate != 0.95 {
+		t.Errorf("expected a reuse rate of 0.95, got %v", rate)
+	}
+}
+
+func TestConnTrackerNoReuseWithoutKeepAlive(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.SetKeepAlivesEnabled(false)
+	server.Start()
+	defer server.Close()
+
+	tracker := newConnTracker(nil)
+	client := &http.Client{Timeout: time.Second, Transport: tracker}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if tracker.reused.Load() != 0 || tracker.fresh.Load() != 3 {
+		t.Errorf("expected 3 new connections, got %d new and %d reused", tracker.fresh.Load(), tracker.reused.Load())
+	}
+}
+
+func TestNewHTTPClientIdlePool(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config
+		wantPerHost int
+	}{
+		{"one per worker", config{concurrency: 8}, 8},
+		{"at least the default", config{concurrency: 1}, http.DefaultMaxIdleConnsPerHost},
+		{"explicit", config{concurrency: 8, maxIdleConnsPerHost: 3}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.maxIdleConns, tt.cfg.idleConnTimeout = 50, 30*time.Second
+			client, err := newHTTPClient(tt.cfg, &byteCounter{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			transport := client.Transport.(*http.Transport)
+			if transport.MaxIdleConnsPerHost != tt.wantPerHost {
+				t.Errorf("expected MaxIdleConnsPerHost=%d, got %d", tt.wantPerHost, transport.MaxIdleConnsPerHost)
+			}
+			if transport.MaxIdleConns != 50 || transport.IdleConnTimeout != 30*time.Second {
+				t.Errorf("unexpected pool settings: MaxIdleConns=%d IdleConnTimeout=%v", transport.MaxIdleConns, transport.IdleConnTimeout)
+			}
+		})
+	}
+}
+
+func TestDrainBodyEnablesReuse(t *testing.T) {
+	// The body's tail arrives late, so the transport can't finish it off
+	// itself when the body is closed unread (newer Go versions drain
+	// briefly on close)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1024)))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer server.Close()
+
+	closeOnly := func(body io.ReadCloser) error { return body.Close() }
+	for _, tt := range []struct {
+		name       string
+		done       func(io.ReadCloser) error
+		wantReused int64
+	}{
+		{"close unread", closeOnly, 0},
+		{"drain", drainBody, 4},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newConnTracker(&http.Transport{})
+			client := &http.Client{Timeout: 5 * time.Second, Transport: tracker}
+			for i := 0; i < 5; i++ {
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					t.Fatalf("request %d failed: %v", i, err)
+				}
+				tt.done(resp.Body)
+			}
+			if reused := tracker.reused.Load(); reused != tt.wantReused {
+				t.Errorf("expected %d reused connections, got %d (%d new)", tt.wantReused, reused, tracker.fresh.Load())
+			}
+		})
+	}
+}
+
+func TestDrainBodyIsBounded(t *testing.T) {
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", 2*maxDrainBytes))}
+	if err := drainBody(io.NopCloser(body)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if body.n != maxDrainBytes {
+		t.Errorf("expected to read %d bytes, read %d", maxDrainBytes, body.n)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "90s", want: now.Add(90 * time.Second)},
+		{in: "2024-05-01T13:00:00Z", want: now.Add(time.Hour)},
+		{in: "2024-05-01T15:30:00+02:00", want: now.Add(90 * time.Minute)},
+		{in: "0s", wantErr: true},
+		{in: "-5m", wantErr: true},
+		{in: "2024-05-01T11:00:00Z", wantErr: true},
+		{in: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDeadline(tt.in, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDeadline(%q): expected an error, got %v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDeadline(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseDeadline(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
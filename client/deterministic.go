@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+
+	"github.com/google/uuid"
+)
+
+// seededTraceID derives a UUIDv4-formatted trace ID from seed and job number.
+// Keying on the job rather than a shared generator keeps IDs identical across
+// runs regardless of which worker picks up which job.
+func seededTraceID(seed uint64, job int) string {
+	r := rand.New(rand.NewPCG(seed, uint64(job)))
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[:8], r.Uint64())
+	binary.BigEndian.PutUint64(id[8:], r.Uint64())
+	id[6] = (id[6] & 0x0f) | 0x40 // version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return id.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSeededTraceIDIsValidUUID(t *testing.T) {
+	id, err := uuid.Parse(seededTraceID(42, 1))
+	if err != nil {
+		t.Fatalf("expected a valid UUID: %v", err)
+	}
+	if id.Version() != 4 || id.Variant() != uuid.RFC4122 {
+		t.Errorf("expected a v4 RFC 4122 UUID, got version %d variant %v", id.Version(), id.Variant())
+	}
+}
+
+func TestDeterministicRunsIssueIdenticalRequests(t *testing.T) {
+	record := func(seed uint64) []string {
+		var mu sync.Mutex
+		var seen []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen = append(seen, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Trace-Id"))
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		cfg := config{target: server.URL + "/hello", total: 5, concurrency: 1, model: "closed", deterministic: true, seed: seed}
+		newLoadRun(cfg, &http.Client{Timeout: time.Second}).execute(context.Background())
+		return seen
+	}
+
+	first, second := record(7), record(7)
+	if len(first) != 5 {
+		t.Fatalf("expected 5 requests, got %d", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected identical request sequences for the same seed:\n%v\n%v", first, second)
+	}
+	if other := record(8); reflect.DeepEqual(first, other) {
+		t.Error("expected a different seed to produce different trace IDs")
+	}
+}
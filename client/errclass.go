@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Buckets for failed requests, by what went wrong on the final attempt.
+const (
+	errClassDNS         = "dns"
+	errClassConnection  = "connection"
+	errClassTLS         = "tls"
+	errClassTimeout     = "timeout"
+	errClassHTTP4xx     = "http-4xx"
+	errClassHTTP5xx     = "http-5xx"
+	errClassCancelled   = "cancelled"
+	errClassBody        = "unexpected-body"
+	errClassCircuitOpen = "circuit-open"
+	errClassOther       = "other"
+)
+
+// classifyFailure buckets a failed request by the error or, when a response
+// arrived, the status of its final attempt.
+func classifyFailure(err error, status int) string {
+	switch {
+	case status >= 500:
+		return errClassHTTP5xx
+	case status >= 400:
+		return errClassHTTP4xx
+	case err == nil:
+		return errClassOther
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return errClassDNS
+	}
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &alertErr) {
+		return errClassTLS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errClassTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errSyntheticLoss) {
+		return errClassConnection
+	}
+	return errClassOther
+}
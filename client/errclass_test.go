@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   string
+	}{
+		{"5xx", nil, http.StatusServiceUnavailable, errClassHTTP5xx},
+		{"4xx", nil, http.StatusNotFound, errClassHTTP4xx},
+		{"429", nil, http.StatusTooManyRequests, errClassHTTP4xx},
+		{"dns", &url.Error{Op: "Get", URL: "http://nope.invalid", Err: &net.DNSError{Err: "no such host", Name: "nope.invalid"}}, 0, errClassDNS},
+		{"refused", refused, 0, errClassConnection},
+		{"reset", fmt.Errorf("read: %w", syscall.ECONNRESET), 0, errClassConnection},
+		{"eof", &url.Error{Op: "Get", URL: "http://a", Err: io.EOF}, 0, errClassConnection},
+		{"synthetic loss", &url.Error{Op: "Get", URL: "http://a", Err: errSyntheticLoss}, 0, errClassConnection},
+		{"deadline", &url.Error{Op: "Get", URL: "http://a", Err: context.DeadlineExceeded}, 0, errClassTimeout},
+		{"unknown", errors.New("something else"), 0, errClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(tt.err, tt.status); got != tt.want {
+				t.Errorf("classifyFailure(%v, %d) = %q, want %q", tt.err, tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoRequestClassifiesFailures(t *testing.T) {
+	statusServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+	}
+	notFound := statusServer(http.StatusNotFound)
+	defer notFound.Close()
+	unavailable := statusServer(http.StatusServiceUnavailable)
+	defer unavailable.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	// A self-signed certificate the default client won't trust
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+
+	// Nothing listens on a port once its listener is closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := "http://" + ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		target  string
+		timeout time.Duration
+		want    string
+	}{
+		{notFound.URL, time.Second, errClassHTTP4xx},
+		{unavailable.URL, time.Second, errClassHTTP5xx},
+		{slow.URL, 50 * time.Millisecond, errClassTimeout},
+		{untrusted.URL, time.Second, errClassTLS},
+		{closedPort, time.Second, errClassConnection},
+	}
+	results := newResultsCollector()
+	for _, tt := range tests {
+		cfg := config{target: tt.target, method: http.MethodGet, maxRetries: 1, backoffBase: time.Millisecond}
+		rec := doRequest(context.Background(), 0, 1, cfg, &http.Client{Timeout: tt.timeout}, "test-trace", nil)
+		if rec.ErrorClass != tt.want {
+			t.Errorf("%s: expected class %q, got %q", tt.target, tt.want, rec.ErrorClass)
+		}
+		results.Record(rec)
+	}
+
+	s := results.Summary()
+	for _, tt := range tests {
+		if s.ErrorCounts[tt.want] != 1 {
+			t.Errorf("expected 1 %s failure in the summary, got %v", tt.want, s.ErrorCounts)
+		}
+	}
+
+	var out strings.Builder
+	s.Print(&out)
+	if want := "errors connection=1 http-4xx=1 http-5xx=1 timeout=1 tls=1\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected %q in the summary, got:\n%s", want, out.String())
+	}
+}
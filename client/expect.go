@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// checkExpectedField decodes body as a JSON object and checks that its
+// top-level field has the value want. Non-string values are compared in
+// their fmt form, so -expect-value 3 matches the number 3.
+func checkExpectedField(body io.Reader, field, want string) error {
+	var obj map[string]any
+	if err := json.NewDecoder(body).Decode(&obj); err != nil {
+		return fmt.Errorf("body is not a JSON object: %w", err)
+	}
+	v, ok := obj[field]
+	if !ok {
+		return fmt.Errorf("field %q is missing", field)
+	}
+	if got := fmt.Sprint(v); got != want {
+		return fmt.Errorf("field %q is %q, want %q", field, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckExpectedField(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"match", `{"message":"hello","traceId":"abc"}`, ""},
+		{"number", `{"count":3}`, ""},
+		{"mismatch", `{"message":"goodbye"}`, `field "message" is "goodbye", want "hello"`},
+		{"missing", `{"traceId":"abc"}`, `field "message" is missing`},
+		{"not JSON", `hello`, "body is not a JSON object"},
+		{"not an object", `["hello"]`, "body is not a JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, want := "message", "hello"
+			if tt.name == "number" {
+				field, want = "count", "3"
+			}
+			err := checkExpectedField(strings.NewReader(tt.body), field, want)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDoRequestExpectField(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantSuccess bool
+	}{
+		// The shape of the server's /hello response
+		{"matching", "application/json", `{"message":"hello","traceId":"t"}` + "\n", true},
+		{"mismatching", "application/json", `{"message":"hi","traceId":"t"}` + "\n", false},
+		{"non-JSON", "text/plain", "hello\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg := config{target: server.URL, method: http.MethodGet, maxRetries: 3, expectField: "message", expectValue: "hello"}
+			rec := doRequest(context.Background(), 0, 1, cfg, &http.Client{Timeout: time.Second}, "test-trace", nil)
+
+			if rec.Success != tt.wantSuccess {
+				t.Errorf("expected success=%v, got %+v", tt.wantSuccess, rec)
+			}
+			if !tt.wantSuccess {
+				if rec.Attempts != 1 || rec.ErrorClass != errClassBody {
+					t.Errorf("expected a single non-retried %s failure, got %+v", errClassBody, rec)
+				}
+			}
+		})
+	}
+}
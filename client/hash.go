@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// hashVerifier checks that successful response bodies all hash to the same
+// SHA-256: the one given by -expect-hash, or else the first one seen. It is
+// safe for concurrent use by workers.
+type hashVerifier struct {
+	mu         sync.Mutex
+	want       string
+	checked    int
+	mismatched int
+}
+
+// newHashVerifier returns a verifier expecting want, a hex SHA-256, or
+// whatever the first body hashes to if want is empty.
+func newHashVerifier(want string) *hashVerifier {
+	return &hashVerifier{want: strings.ToLower(want)}
+}
+
+// validHash reports whether s is a hex-encoded SHA-256.
+func validHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// Check hashes body, returning an error if it differs from the expected
+// hash.
+func (v *hashVerifier) Check(body []byte) error {
+	sum := sha256.Sum256(body)
+	got := hex.EncodeToString(sum[:])
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.checked++
+	if v.want == "" {
+		v.want = got
+		return nil
+	}
+	if got != v.want {
+		v.mismatched++
+		return fmt.Errorf("body hash %s, want %s", got, v.want)
+	}
+	return nil
+}
+
+// Stats returns how many bodies were checked and how many didn't match.
+func (v *hashVerifier) Stats() (checked, mismatched int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.checked, v.mismatched
+}
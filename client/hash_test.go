@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashVerifierFirstBodyIsReference(t *testing.T) {
+	v := newHashVerifier("")
+	for i := 0; i < 3; i++ {
+		if err := v.Check([]byte(`{"message":"hello"}`)); err != nil {
+			t.Fatalf("check %d: expected identical bodies to match, got %v", i, err)
+		}
+	}
+	if err := v.Check([]byte(`{"message":"hellp"}`)); err == nil {
+		t.Error("expected a differing body to be flagged")
+	}
+	if checked, mismatched := v.Stats(); checked != 4 || mismatched != 1 {
+		t.Errorf("expected 4 checked and 1 mismatched, got %d and %d", checked, mismatched)
+	}
+}
+
+func TestHashVerifierExpectedHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("expected"))
+	want := hex.EncodeToString(sum[:])
+
+	v := newHashVerifier(strings.ToUpper(want))
+	if err := v.Check([]byte("expected")); err != nil {
+		t.Errorf("expected the matching body to pass, got %v", err)
+	}
+	// The first body must not become the reference when a hash is given
+	v = newHashVerifier(want)
+	if err := v.Check([]byte("other")); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected a mismatch naming the wanted hash, got %v", err)
+	}
+}
+
+func TestValidHash(t *testing.T) {
+	sum := sha256.Sum256(nil)
+	for s, want := range map[string]bool{
+		hex.EncodeToString(sum[:]): true,
+		strings.Repeat("AB", 32):   true,
+		"":                         false,
+		"abc":                      false,
+		strings.Repeat("zz", 32):   false,
+		strings.Repeat("ab", 20):   false,
+	} {
+		if got := validHash(s); got != want {
+			t.Errorf("validHash(%q): expected %v, got %v", s, want, got)
+		}
+	}
+}
+
+func TestDoRequest_VerifyHash(t *testing.T) {
+	// The third response is corrupted
+	var n atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1) == 3 {
+			w.Write([]byte(`{"message":"hellO"}`))
+			return
+		}
+		w.Write([]byte(`{"message":"hello"}`))
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, verifyHash: true}
+	failures := failureCounts{hashes: newHashVerifier("")}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for job := 1; job <= 4; job++ {
+		rec := doRequest(context.Background(), 1, job, cfg, client, "test-trace", &failures)
+		wantSuccess := job != 3
+		if rec.Success != wantSuccess {
+			t.Errorf("job %d: expected success=%v, got %+v", job, wantSuccess, rec)
+		}
+		if !wantSuccess && rec.ErrorClass != errClassBody {
+			t.Errorf("job %d: expected error class %s, got %s", job, errClassBody, rec.ErrorClass)
+		}
+	}
+	if checked, mismatched := failures.hashes.Stats(); checked != 4 || mismatched != 1 {
+		t.Errorf("expected 4 checked and 1 mismatched, got %d and %d", checked, mismatched)
+	}
+}
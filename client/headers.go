@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerFlags collects repeated -header "Key: Value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, v := range h[key] {
+			pairs = append(pairs, key+": "+v)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// redacted is like String but masks the values of headers that commonly
+// carry credentials, for output that may be shared such as the run manifest.
+func (h headerFlags) redacted() string {
+	masked := make(headerFlags, len(h))
+	for key, values := range h {
+		if !sensitiveHeader(key) {
+			masked[key] = values
+			continue
+		}
+		for range values {
+			masked[key] = append(masked[key], "REDACTED")
+		}
+	}
+	return masked.String()
+}
+
+// sensitiveHeader reports whether values of the header key should be kept
+// out of logs and reports.
+func sensitiveHeader(key string) bool {
+	switch key = http.CanonicalHeaderKey(key); key {
+	case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+		return true
+	}
+	return strings.HasSuffix(key, "-Token") || strings.HasSuffix(key, "-Key") || strings.HasSuffix(key, "-Secret")
+}
+
+// Set parses one "Key: Value" header, rejecting values without a colon or
+// with an empty key.
+func (h headerFlags) Set(s string) error {
+	key, value, ok := strings.Cut(s, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("invalid header %q: want \"Key: Value\"", s)
+	}
+	http.Header(h).Add(key, strings.TrimSpace(value))
+	return nil
+}
+
+// apply sets the headers on req, replacing any set automatically such as
+// X-Trace-Id.
+func (h headerFlags) apply(req *http.Request) {
+	for key, values := range h {
+		req.Header[key] = append([]string(nil), values...)
+	}
+}
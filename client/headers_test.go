@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderFlagsArriveAtServer(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	headers := headerFlags{}
+	for _, h := range []string{"Authorization: Bearer abc:def", "accept: application/json", "X-Trace-Id: fixed-trace", "X-Tag: a", "X-Tag: b"} {
+		if err := headers.Set(h); err != nil {
+			t.Fatalf("unexpected error for %q: %v", h, err)
+		}
+	}
+	cfg := config{target: server.URL, headers: headers}
+	if success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "auto-trace", nil); !success {
+		t.Fatal("expected request to succeed")
+	}
+
+	if v := got.Get("Authorization"); v != "Bearer abc:def" {
+		t.Errorf("expected Authorization to keep everything after the first colon, got %q", v)
+	}
+	if v := got.Get("Accept"); v != "application/json" {
+		t.Errorf("expected Accept application/json, got %q", v)
+	}
+	if v := got.Values("X-Trace-Id"); len(v) != 1 || v[0] != "fixed-trace" {
+		t.Errorf("expected the explicit X-Trace-Id to win, got %q", v)
+	}
+	if v := got.Values("X-Tag"); len(v) != 2 {
+		t.Errorf("expected both X-Tag values, got %q", v)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	cfg := config{target: server.URL, userAgent: "pre-playground-client/1.2.3"}
+	if success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "trace", nil); !success {
+		t.Fatal("expected request to succeed")
+	}
+	if got != cfg.userAgent {
+		t.Errorf("expected User-Agent %q, got %q", cfg.userAgent, got)
+	}
+
+	// An explicit -header still wins
+	cfg.headers = headerFlags{}
+	if err := cfg.headers.Set("User-Agent: custom"); err != nil {
+		t.Fatal(err)
+	}
+	if success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "trace", nil); !success {
+		t.Fatal("expected request to succeed")
+	}
+	if got != "custom" {
+		t.Errorf("expected -header User-Agent to override, got %q", got)
+	}
+}
+
+func TestHeaderFlagsRejectMalformed(t *testing.T) {
+	for _, h := range []string{"NoColon", ": value", "  : value"} {
+		fs := flag.NewFlagSet("client", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Var(headerFlags{}, "header", "")
+		if err := fs.Parse([]string{"-header", h}); err == nil {
+			t.Errorf("expected parse error for -header %q", h)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// writeHeatmap writes a CSV with one row per second from the first to the
+// last bucket (quiet seconds included as zeros) and one column of request
+// counts per latency bucket in heatmapBounds, plus a final overflow column.
+func writeHeatmap(w io.Writer, buckets []secondStats) error {
+	cw := csv.NewWriter(w)
+	header := []string{"second"}
+	lower := time.Duration(0)
+	for _, bound := range heatmapBounds {
+		header = append(header, fmt.Sprintf("%d-%dms", lower.Milliseconds(), bound.Milliseconds()))
+		lower = bound
+	}
+	header = append(header, fmt.Sprintf("%dms+", lower.Milliseconds()))
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	if len(buckets) > 0 {
+		bySecond := make(map[int64]secondStats, len(buckets))
+		for _, b := range buckets {
+			bySecond[b.second] = b
+		}
+		first, last := buckets[0].second, buckets[len(buckets)-1].second
+		for second := first; second <= last; second++ {
+			row := []string{strconv.FormatInt(second, 10)}
+			counts := bySecond[second].latencyCounts
+			for i := 0; i <= len(heatmapBounds); i++ {
+				var n int64
+				if i < len(counts) {
+					n = counts[i]
+				}
+				row = append(row, strconv.FormatInt(n, 10))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeatmapCSV(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// 8 requests, 2 workers, 400ms apart: completions span over a second
+	cfg := config{target: server.URL, total: 8, concurrency: 2, interval: 400 * time.Millisecond, model: "closed"}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	path := filepath.Join(t.TempDir(), "heatmap.csv")
+	if err := lr.writeHeatmapFile(path); err != nil {
+		t.Fatalf("failed to write heatmap: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open heatmap: %v", err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+
+	buckets := lr.series.Range(0, math.MaxInt64)
+	wantRows := int(buckets[len(buckets)-1].second-buckets[0].second) + 1
+	if wantRows < 2 {
+		t.Fatalf("expected the run to span multiple seconds, got %d", wantRows)
+	}
+	if len(records) != wantRows+1 {
+		t.Fatalf("expected header plus %d rows, got %d records", wantRows, len(records))
+	}
+	wantCols := len(heatmapBounds) + 2
+	var total int
+	for i, record := range records {
+		if len(record) != wantCols {
+			t.Fatalf("row %d: expected %d columns, got %d", i, wantCols, len(record))
+		}
+		if i == 0 {
+			continue
+		}
+		for _, cell := range record[1:] {
+			n, err := strconv.Atoi(cell)
+			if err != nil {
+				t.Fatalf("row %d: non-numeric count %q", i, cell)
+			}
+			total += n
+		}
+	}
+	if records[0][0] != "second" || records[0][1] != "0-5ms" || records[0][wantCols-1] != "1000ms+" {
+		t.Errorf("unexpected header %v", records[0])
+	}
+	if total != cfg.total {
+		t.Errorf("expected heatmap counts to total %d, got %d", cfg.total, total)
+	}
+}
+
+func TestHeatmapFillsQuietSeconds(t *testing.T) {
+	ts := newTimeSeries()
+	ts.Observe(time.Unix(100, 0), true, 3*time.Millisecond)
+	ts.Observe(time.Unix(102, 0), true, 2*time.Second)
+
+	path := filepath.Join(t.TempDir(), "heatmap.csv")
+	f, _ := os.Create(path)
+	if err := writeHeatmap(f, ts.Range(0, 1000)); err != nil {
+		t.Fatalf("failed to write heatmap: %v", err)
+	}
+	f.Close()
+	data, _ := os.ReadFile(path)
+
+	want := "second,0-5ms,5-10ms,10-25ms,25-50ms,50-100ms,100-250ms,250-500ms,500-1000ms,1000ms+\n" +
+		"100,1,0,0,0,0,0,0,0,0\n" +
+		"101,0,0,0,0,0,0,0,0,0\n" +
+		"102,0,0,0,0,0,0,0,0,1\n"
+	if string(data) != want {
+		t.Errorf("unexpected heatmap:\n%s\nwant:\n%s", data, want)
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,22 +28,362 @@ type config struct {
 	total       int
 	concurrency int
 	interval    time.Duration
+	// ramp spreads the workers' start over this long (0 starts them all
+	// at once)
+	ramp        time.Duration
 	timeout     time.Duration
 	maxRetries  int
+	remoteWrite string
+	tee         string
+	heatmapFile string
+	// progress prints running totals to stderr this often (0 disables)
+	progress time.Duration
+	// perWorker adds a line per worker to the summary
+	perWorker bool
+
+	// targets holds every -target with its weight; target is the first,
+	// or the one picked for a request
+	targets []weightedTarget
+
+	// totalTimeout, if set, bounds a request's attempts and backoffs
+	// together; timeout still limits each attempt
+	totalTimeout time.Duration
+
+	// backoff is the retry delay strategy (constant, linear or
+	// exponential), starting at backoffBase and capped at backoffCap
+	backoff     string
+	backoffBase time.Duration
+	backoffCap  time.Duration
+	// retryOn is the set of response statuses that are retried; network
+	// errors always are
+	retryOn retryPolicy
+	// failFastOnRefused gives up on a request at its first refused
+	// connection instead of retrying
+	failFastOnRefused bool
+	// retryAfterMax caps the wait a 429 or 503 Retry-After header asks for
+	retryAfterMax time.Duration
+
+	// breakerThreshold consecutive failed attempts open the circuit
+	// breaker, failing requests fast for breakerCooldown (0 disables it)
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	// duration, when non-zero, runs for that long instead of sending total
+	// requests
+	duration time.Duration
+	// deadline, if set, aborts the run at that time whatever work remains
+	deadline time.Time
+
+	// warmup and warmupDuration leave the first requests, by count and by
+	// time since the start, out of the results; they are still sent and
+	// logged
+	warmup         int
+	warmupDuration time.Duration
+
+	// method, body and contentType shape every request; body is resent as
+	// is on each retry
+	method      string
+	body        []byte
+	contentType string
+	// userAgent identifies the client in server logs; empty keeps Go's
+	// default
+	userAgent string
+
+	// headers are added to every request, overriding automatic ones
+	headers headerFlags
+
+	// output is the results format (text, json or csv), written to
+	// outputFile, or stdout if empty
+	output     string
+	outputFile string
+
+	// manifestFile, if set, receives a JSON description of the finished run
+	manifestFile string
+
+	// model is "closed" (workers pace themselves with interval, or take
+	// jobs handed out at rps if set) or "hybrid" (workers run back-to-back,
+	// capped at rps by a shared limiter)
+	model string
+	rps   float64
+	// patternFile, for -model hybrid, varies rps over the run following a
+	// curve of (time-fraction, rps-multiplier) points
+	patternFile string
+
+	// USD per GB (1e9 bytes) received and sent, for cost estimates
+	costPerGBIn  float64
+	costPerGBOut float64
+
+	adaptive                 bool
+	adaptiveErrorThreshold   float64
+	adaptiveLatencyThreshold time.Duration
+
+	// maxIdleConns, maxIdleConnsPerHost (0 meaning one per worker) and
+	// idleConnTimeout tune the transport's keep-alive pool
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+
+	// insecure skips TLS certificate verification, for self-signed servers
+	insecure bool
+	// clientCert and clientKey are PEM files presented for mutual TLS;
+	// caCert is a PEM file of extra roots to trust
+	clientCert string
+	clientKey  string
+	caCert     string
+
+	// traceTiming logs a DNS/connect/TLS/TTFB breakdown of every attempt
+	// and adds their percentiles to the summary
+	traceTiming bool
+
+	// expectField and expectValue, if set, fail responses whose JSON body
+	// doesn't have expectField equal to expectValue
+	expectField string
+	expectValue string
+	// verifyHash checks that successful response bodies all hash the same:
+	// to expectHash if set, else to the first body
+	verifyHash bool
+	expectHash string
+
+	// verifyGzip requests gzip explicitly and fails responses whose gzip
+	// body doesn't decompress
+	verifyGzip bool
+
+	// respectRateLimit pauses workers when the server's RateLimit headers
+	// report the quota is nearly spent
+	respectRateLimit bool
+
+	// netDelay, netJitter and netLoss emulate a degraded network by
+	// delaying and randomly failing requests before they are sent
+	netDelay  time.Duration
+	netJitter time.Duration
+	netLoss   float64
+
+	// apdexThreshold is the Apdex target latency T (0 disables the score)
+	apdexThreshold time.Duration
+	// slo makes the client exit 1 if the run misses it
+	slo sloThresholds
+
+	// deterministic derives every random choice, including trace IDs, from
+	// seed so that runs can be compared request for request
+	deterministic bool
+	seed          uint64
 }
 
 func parseConfig() config {
-	var cfg config
-	flag.StringVar(&cfg.target, "target", envOrDefault("TARGET_URL", "http://localhost:8080/hello"), "target URL")
+	cfg := config{headers: headerFlags{}}
+	targets := &targetFlags{}
+	if err := targets.Set(envOrDefault("TARGET_URL", "http://localhost:8080/hello")); err != nil {
+		log.Fatalf("invalid TARGET_URL: %v", err)
+	}
+	targets.set = false
+	flag.Var(targets, "target", "target URL, optionally suffixed with :weight=N (repeatable or comma-separated; requests are spread by weighted round-robin)")
 	flag.IntVar(&cfg.total, "count", parseIntEnv("CLIENT_COUNT", 20), "total requests to send")
+	flag.DurationVar(&cfg.duration, "duration", parseDurationEnv("CLIENT_DURATION", 0), "run for this long instead of sending -count requests (0 uses -count)")
+	deadline := flag.String("deadline", envOrDefault("CLIENT_DEADLINE", ""), "abort the run at this time, given as a duration from now or an RFC 3339 timestamp, and report partial results (disabled if empty)")
+	flag.IntVar(&cfg.warmup, "warmup", parseIntEnv("CLIENT_WARMUP", 0), "number of initial requests to send but leave out of the results (counted in -count)")
+	flag.DurationVar(&cfg.warmupDuration, "warmup-duration", parseDurationEnv("CLIENT_WARMUP_DURATION", 0), "leave requests completed this soon after the start out of the results (0 disables)")
 	flag.IntVar(&cfg.concurrency, "concurrency", parseIntEnv("CLIENT_CONCURRENCY", 2), "number of concurrent workers")
+	flag.DurationVar(&cfg.ramp, "ramp", parseDurationEnv("CLIENT_RAMP", 0), "start workers gradually, one every ramp/concurrency, instead of all at once")
+	flag.DurationVar(&cfg.progress, "progress", parseDurationEnv("CLIENT_PROGRESS", 0), "print completed/failed counts and success rate to stderr this often (0 disables)")
+	flag.BoolVar(&cfg.perWorker, "per-worker", false, "break the summary down by worker, to spot a stuck or starved one")
 	flag.DurationVar(&cfg.interval, "interval", parseDurationEnv("CLIENT_INTERVAL", 500*time.Millisecond), "delay between requests per worker")
-	flag.DurationVar(&cfg.timeout, "timeout", parseDurationEnv("CLIENT_TIMEOUT", 3*time.Second), "HTTP client timeout")
+	flag.DurationVar(&cfg.timeout, "timeout", parseDurationEnv("CLIENT_TIMEOUT", 3*time.Second), "timeout for each request attempt")
+	flag.DurationVar(&cfg.totalTimeout, "total-timeout", parseDurationEnv("CLIENT_TOTAL_TIMEOUT", 0), "limit on the time a request spends across all its attempts and backoffs (0 disables)")
 	flag.IntVar(&cfg.maxRetries, "retries", parseIntEnv("CLIENT_MAX_RETRIES", 3), "maximum retry attempts for failed requests")
+	flag.BoolVar(&cfg.failFastOnRefused, "fail-fast-on-refused", false, "give up on a request as soon as its connection is refused instead of retrying with backoff")
+	retryOn := flag.String("retry-on", envOrDefault("CLIENT_RETRY_ON", defaultRetryOn), "comma-separated status codes and lo-hi ranges to retry (network errors are always retried)")
+	flag.StringVar(&cfg.backoff, "backoff", envOrDefault("CLIENT_BACKOFF", backoffExponential), "retry backoff strategy: constant, linear or exponential")
+	flag.DurationVar(&cfg.backoffBase, "backoff-base", parseDurationEnv("CLIENT_BACKOFF_BASE", defaultBackoffBase), "delay before the first retry")
+	flag.DurationVar(&cfg.backoffCap, "backoff-cap", parseDurationEnv("CLIENT_BACKOFF_CAP", defaultBackoffCap), "maximum delay between retries")
+	flag.DurationVar(&cfg.retryAfterMax, "retry-after-max", parseDurationEnv("CLIENT_RETRY_AFTER_MAX", defaultRetryAfterMax), "longest Retry-After from a 429 or 503 response to honor before retrying")
+	flag.IntVar(&cfg.breakerThreshold, "breaker-threshold", 0, "consecutive failed attempts that open the circuit breaker and fail requests fast (0 disables)")
+	flag.DurationVar(&cfg.breakerCooldown, "breaker-cooldown", 5*time.Second, "how long the open circuit breaker fails requests before letting a probe through")
+	flag.StringVar(&cfg.method, "method", http.MethodGet, "HTTP method for each request")
+	body := flag.String("body", "", "request body to send")
+	bodyFile := flag.String("body-file", "", "file holding the request body to send")
+	flag.StringVar(&cfg.contentType, "content-type", "", "Content-Type header for requests with a body")
+	flag.StringVar(&cfg.userAgent, "user-agent", "pre-playground-client/"+version, "User-Agent header for every request (empty keeps Go's default)")
+	flag.Var(cfg.headers, "header", `extra "Key: Value" header for every request (repeatable)`)
+	flag.StringVar(&cfg.model, "model", envOrDefault("CLIENT_MODEL", "closed"), "load model: closed or hybrid")
+	flag.Float64Var(&cfg.rps, "rps", 0, "target aggregate requests per second; with -model closed, jobs are dispatched at this rate whatever the number of workers, ignoring -interval (0 disables)")
+	flag.StringVar(&cfg.patternFile, "pattern-file", "", "file of fraction,multiplier lines that scale -rps over the run for -model hybrid (disabled if empty)")
+	flag.Float64Var(&cfg.costPerGBIn, "cost-per-gb-in", 0, "estimated cost per GB received (ingress) for the run summary")
+	flag.Float64Var(&cfg.costPerGBOut, "cost-per-gb-out", 0, "estimated cost per GB sent (egress) for the run summary")
+	flag.BoolVar(&cfg.adaptive, "adaptive-concurrency", false, "adjust active workers (up to -concurrency) based on recent error rate and latency")
+	flag.Float64Var(&cfg.adaptiveErrorThreshold, "adaptive-error-threshold", 0.1, "error rate above which adaptive concurrency backs off")
+	flag.DurationVar(&cfg.adaptiveLatencyThreshold, "adaptive-latency-threshold", 0, "average latency above which adaptive concurrency backs off (0 disables)")
+	flag.IntVar(&cfg.maxIdleConns, "max-idle-conns", 100, "maximum idle keep-alive connections across all hosts (0 means no limit)")
+	flag.IntVar(&cfg.maxIdleConnsPerHost, "max-idle-conns-per-host", 0, "maximum idle keep-alive connections per host (0 keeps one per worker)")
+	flag.DurationVar(&cfg.idleConnTimeout, "idle-conn-timeout", 90*time.Second, "how long an idle keep-alive connection is kept open (0 means forever)")
+	flag.BoolVar(&cfg.insecure, "insecure", false, "skip TLS certificate verification (for servers with self-signed certificates)")
+	flag.StringVar(&cfg.clientCert, "client-cert", "", "PEM client certificate for mutual TLS (requires -client-key)")
+	flag.StringVar(&cfg.clientKey, "client-key", "", "PEM private key for -client-cert")
+	flag.StringVar(&cfg.caCert, "ca-cert", "", "PEM file of CA certificates to trust in addition to the system roots")
+	flag.BoolVar(&cfg.traceTiming, "trace-timing", false, "log DNS, connect, TLS handshake, time-to-first-byte and total time per request and summarize them")
+	flag.StringVar(&cfg.expectField, "expect-field", "", "top-level JSON field each successful response body must contain (disabled if empty)")
+	flag.BoolVar(&cfg.verifyHash, "verify-hash", false, "read each successful response body in full and fail it if its SHA-256 differs from the first one's")
+	flag.StringVar(&cfg.expectHash, "expect-hash", "", "hex SHA-256 every successful response body must have (implies -verify-hash)")
+	flag.StringVar(&cfg.expectValue, "expect-value", "", "value -expect-field must have, e.g. -expect-field message -expect-value hello")
+	flag.BoolVar(&cfg.verifyGzip, "verify-gzip", false, "decompress gzip-encoded responses and fail the request if they are corrupt")
+	flag.BoolVar(&cfg.respectRateLimit, "respect-ratelimit", false, "pause until RateLimit-Reset when the server's RateLimit-Remaining runs low")
+	flag.DurationVar(&cfg.netDelay, "net-delay", 0, "artificial delay added before each request")
+	flag.DurationVar(&cfg.netJitter, "net-jitter", 0, "random extra delay, up to this much, added before each request")
+	flag.Float64Var(&cfg.netLoss, "net-loss", 0, "probability (0-1) that a request fails with a synthetic network error")
+	flag.DurationVar(&cfg.apdexThreshold, "apdex-threshold", 0, "report an Apdex score with this target latency T (0 disables)")
+	flag.DurationVar(&cfg.slo.p99, "slo-p99", 0, "exit 1 if the p99 latency of successful requests exceeds this (0 disables)")
+	sloErrorRate := flag.String("slo-error-rate", "", "exit 1 if the share of failed requests exceeds this, e.g. 1% or 0.01 (disabled if empty)")
+	flag.BoolVar(&cfg.deterministic, "deterministic", false, "derive trace IDs and other random choices from -seed for reproducible runs")
+	flag.Uint64Var(&cfg.seed, "seed", 1, "seed for -deterministic")
+	flag.StringVar(&cfg.tee, "tee", envOrDefault("CLIENT_TEE_URL", ""), "recording endpoint to mirror a copy of each request to, best-effort (disabled if empty)")
+	flag.StringVar(&cfg.output, "output", outputText, "results format: text, json (summary) or csv (one row per request)")
+	flag.StringVar(&cfg.outputFile, "output-file", "", "write results to this file instead of stdout")
+	flag.StringVar(&cfg.manifestFile, "manifest-file", "", "write a JSON run manifest (config, timestamps, version, summary, environment) to this file (disabled if empty)")
+	flag.StringVar(&cfg.heatmapFile, "heatmap-file", "", "write a per-second latency heatmap CSV to this file (disabled if empty)")
+	flag.StringVar(&cfg.remoteWrite, "remote-write", envOrDefault("CLIENT_REMOTE_WRITE_URL", ""), "Prometheus remote-write URL to push per-second stats to (disabled if empty)")
+	configFile := flag.String("config", envOrDefault("CLIENT_CONFIG", ""), "YAML file of flag values keyed by flag name; flags on the command line override it (disabled if empty)")
 	flag.Parse()
+	if err := applyConfigFile(flag.CommandLine, *configFile); err != nil {
+		log.Fatalf("invalid -config: %v", err)
+	}
+	if err := checkExclusiveFlags(flag.CommandLine); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	cfg.targets = targets.targets
+	cfg.target = cfg.targets[0].URL
+
+	at, err := parseDeadline(*deadline, time.Now())
+	if err != nil {
+		log.Fatalf("invalid -deadline: %v", err)
+	}
+	cfg.deadline = at
+	policy, err := parseRetryPolicy(*retryOn)
+	if err != nil {
+		log.Fatalf("invalid -retry-on: %v", err)
+	}
+	cfg.retryOn = policy
+	if cfg.slo.errorRate, err = parseErrorRate(*sloErrorRate); err != nil {
+		log.Fatalf("invalid -slo-error-rate: %v", err)
+	}
+	cfg.slo.hasErrorRate = *sloErrorRate != ""
+
+	switch {
+	case *body != "":
+		cfg.body = []byte(*body)
+	case *bodyFile != "":
+		data, err := os.ReadFile(*bodyFile)
+		if err != nil {
+			log.Fatalf("failed to read -body-file: %v", err)
+		}
+		cfg.body = data
+	}
 	return cfg
 }
 
+func (c config) validate() error {
+	switch c.model {
+	case "closed":
+	case "hybrid":
+		if c.rps <= 0 {
+			return errors.New("-model hybrid requires a positive -rps")
+		}
+	default:
+		return fmt.Errorf("unknown -model %q (want closed or hybrid)", c.model)
+	}
+	switch c.output {
+	case "", outputText, outputJSON, outputCSV:
+	default:
+		return fmt.Errorf("unknown -output %q (want text, json or csv)", c.output)
+	}
+	switch c.backoff {
+	case "", backoffConstant, backoffLinear, backoffExponential:
+	default:
+		return fmt.Errorf("unknown -backoff %q (want constant, linear or exponential)", c.backoff)
+	}
+	if c.backoffBase < 0 || c.backoffCap < 0 || c.retryAfterMax < 0 {
+		return errors.New("-backoff-base, -backoff-cap and -retry-after-max must not be negative")
+	}
+	if c.totalTimeout < 0 {
+		return errors.New("-total-timeout must not be negative")
+	}
+	if c.breakerThreshold < 0 || c.breakerCooldown < 0 {
+		return errors.New("-breaker-threshold and -breaker-cooldown must not be negative")
+	}
+	if c.rps < 0 {
+		return errors.New("-rps must not be negative")
+	}
+	if c.warmup < 0 || c.warmupDuration < 0 {
+		return errors.New("-warmup and -warmup-duration must not be negative")
+	}
+	if c.ramp < 0 {
+		return errors.New("-ramp must not be negative")
+	}
+	if c.slo.p99 < 0 {
+		return errors.New("-slo-p99 must not be negative")
+	}
+	if c.progress < 0 {
+		return errors.New("-progress must not be negative")
+	}
+	if c.duration < 0 {
+		return errors.New("-duration must not be negative")
+	}
+	if c.maxIdleConns < 0 || c.maxIdleConnsPerHost < 0 || c.idleConnTimeout < 0 {
+		return errors.New("-max-idle-conns, -max-idle-conns-per-host and -idle-conn-timeout must not be negative")
+	}
+	if c.expectValue != "" && c.expectField == "" {
+		return errors.New("-expect-value requires -expect-field")
+	}
+	if c.expectHash != "" && !validHash(c.expectHash) {
+		return fmt.Errorf("-expect-hash %q is not a hex SHA-256", c.expectHash)
+	}
+	if (c.clientCert == "") != (c.clientKey == "") {
+		return errors.New("-client-cert and -client-key must be given together")
+	}
+	if c.netLoss < 0 || c.netLoss > 1 {
+		return fmt.Errorf("-net-loss %v must be between 0 and 1", c.netLoss)
+	}
+	if c.netDelay < 0 || c.netJitter < 0 {
+		return errors.New("-net-delay and -net-jitter must not be negative")
+	}
+	if c.patternFile != "" && c.model != "hybrid" {
+		return errors.New("-pattern-file requires -model hybrid")
+	}
+	return nil
+}
+
+// newHTTPClient builds the client used for load requests. Every connection it
+// dials is counted by bytes. It fails if the TLS certificates can't be
+// loaded.
+func newHTTPClient(cfg config, bytes *byteCounter) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = bytes.dialContext(dialer.DialContext)
+	transport.MaxIdleConns = cfg.maxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.maxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		// The default of 2 would make every extra worker redial
+		transport.MaxIdleConnsPerHost = max(cfg.concurrency, http.DefaultMaxIdleConnsPerHost)
+	}
+	transport.IdleConnTimeout = cfg.idleConnTimeout
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	// Decompression is checked by hand with -verify-gzip
+	transport.DisableCompression = cfg.verifyGzip
+	if cfg.netDelay > 0 || cfg.netJitter > 0 || cfg.netLoss > 0 {
+		seed := cfg.seed
+		if !cfg.deterministic {
+			seed = rand.Uint64()
+		}
+		return &http.Client{Timeout: cfg.timeout, Transport: newNetConditions(transport, cfg.netDelay, cfg.netJitter, cfg.netLoss, seed)}, nil
+	}
+	return &http.Client{Timeout: cfg.timeout, Transport: transport}, nil
+}
+
 func parseIntEnv(key string, defaultValue int) int {
 	if v := os.Getenv(key); v != "" {
 		if parsed, err := fmt.Sscanf(v, "%d", &defaultValue); err == nil && parsed == 1 {
@@ -58,36 +409,246 @@ func envOrDefault(key, def string) string {
 	return def
 }
 
-func isRetryableError(err error, statusCode int) bool {
+func isRetryableError(err error, statusCode int, policy retryPolicy) bool {
 	if err != nil {
 		return true // Network errors are retryable
 	}
-	// 5xx errors are retryable, 4xx (except 429) are not
-	return statusCode >= 500 || statusCode == 429
+	// By default 5xx and 429 are retryable, other 4xx are not
+	return policy.retries(statusCode)
+}
+
+// isConnReset reports whether err is the peer resetting the connection, which
+// usually means the server hit a connection limit.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// isConnRefused reports whether err is the target refusing the connection,
+// which usually means nothing is listening on the port.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// failureCounts tallies failed attempts by cause for the run summary, and
+// feeds them to the circuit breaker if there is one. A nil *failureCounts
+// counts nothing.
+type failureCounts struct {
+	resets        atomic.Int64
+	gzipDecode    atomic.Int64
+	totalTimeouts atomic.Int64
+	refused       atomic.Int64
+	breaker       *circuitBreaker // nil unless -breaker-threshold
+	hashes        *hashVerifier   // nil unless -verify-hash or -expect-hash
 }
 
-func doRequestWithRetry(id int, job int, cfg config, client *http.Client, traceID string) (bool, time.Duration) {
+func (f *failureCounts) observe(err error) {
+	if f != nil && isConnReset(err) {
+		f.resets.Add(1)
+	}
+}
+
+// allow reports whether the circuit breaker, if any, lets an attempt go
+// ahead.
+func (f *failureCounts) allow() bool {
+	return f == nil || f.breaker == nil || f.breaker.Allow()
+}
+
+// record tells the circuit breaker, if any, how an attempt went.
+func (f *failureCounts) record(success bool) {
+	if f != nil && f.breaker != nil {
+		f.breaker.Record(success)
+	}
+}
+
+// maxDrainBytes bounds how much of an unread response body drainBody reads
+// to free its connection; past that, dropping the connection is cheaper.
+const maxDrainBytes = 256 << 10
+
+// drainBody reads what is left of body, up to maxDrainBytes, and closes it.
+// The transport only returns a keep-alive connection to the pool once its
+// body has been read to EOF (newer Go versions try only briefly on Close),
+// so closing one unread forces the next request to dial, inflating latency
+// and connection counts in the results.
+func drainBody(body io.ReadCloser) error {
+	_, _ = io.CopyN(io.Discard, body, maxDrainBytes)
+	return body.Close()
+}
+
+// readGzipBody reads body through a gzip reader, returning the decompressed
+// data or any error from a malformed header, corrupt data or a bad checksum.
+func readGzipBody(body io.Reader) ([]byte, error) {
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return data, zr.Close()
+}
+
+func doRequestWithRetry(ctx context.Context, id int, job int, cfg config, client *http.Client, traceID string, failures *failureCounts) (bool, time.Duration) {
+	rec := doRequest(ctx, id, job, cfg, client, traceID, failures)
+	return rec.Success, rec.Latency
+}
+
+// doRequest sends one job's request, retrying failures, and records how it
+// went: the last status seen (0 if there was no response), the latency of
+// the final attempt and how many attempts were made. With cfg.totalTimeout
+// set, running out of time mid-request or mid-backoff ends it at once as
+// deadline exceeded.
+func doRequest(ctx context.Context, id int, job int, cfg config, client *http.Client, traceID string, failures *failureCounts) requestRecord {
 	var lastErr error
 	var lastStatusCode int
+	// lastRetryAfter is the last response's Retry-After, if it had one
+	var lastRetryAfter time.Duration
+	var hasRetryAfter bool
+	rec := requestRecord{Worker: id, Job: job, TraceID: traceID, Target: cfg.target}
+	finish := func(success bool, latency time.Duration) requestRecord {
+		rec.Success, rec.Latency, rec.Status = success, latency, lastStatusCode
+		if !success && rec.ErrorClass == "" {
+			rec.ErrorClass = classifyFailure(lastErr, lastStatusCode)
+		}
+		return rec
+	}
+
+	parent := ctx
+	if cfg.totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.totalTimeout)
+		defer cancel()
+	}
+	// abort ends the request once ctx is done, telling the total timeout
+	// running out apart from the whole run being stopped
+	abort := func(during string, latency time.Duration) requestRecord {
+		if parent.Err() == nil {
+			rec.DeadlineExceeded = true
+			rec.ErrorClass = errClassTimeout
+			if failures != nil {
+				failures.totalTimeouts.Add(1)
+			}
+			log.Printf("[worker %d] request %d deadline exceeded %s after %d attempts (trace %s): total timeout %s",
+				id, job, during, rec.Attempts, traceID, cfg.totalTimeout)
+		} else {
+			rec.ErrorClass = errClassCancelled
+			log.Printf("[worker %d] request %d interrupted %s (trace %s): %v", id, job, during, traceID, parent.Err())
+		}
+		return finish(false, latency)
+	}
 
 	for attempt := 0; attempt <= cfg.maxRetries; attempt++ {
-		req, err := http.NewRequest(http.MethodGet, cfg.target, nil)
+		if !failures.allow() {
+			rec.ErrorClass = errClassCircuitOpen
+			log.Printf("[worker %d] request %d short-circuited, circuit breaker open (trace %s)", id, job, traceID)
+			return finish(false, 0)
+		}
+		rec.Attempts = attempt + 1
+		// A fresh reader per attempt so retries resend the whole body
+		var body io.Reader
+		if cfg.body != nil {
+			body = bytes.NewReader(cfg.body)
+		}
+		req, err := http.NewRequestWithContext(withNetAttempt(ctx, job, attempt), cfg.method, cfg.target, body)
 		if err != nil {
+			lastErr = err
 			log.Printf("[worker %d] request %d build error (trace %s): %v", id, job, traceID, err)
-			return false, 0
+			return finish(false, 0)
 		}
 		req.Header.Set("X-Trace-Id", traceID)
+		if cfg.contentType != "" {
+			req.Header.Set("Content-Type", cfg.contentType)
+		}
+		if cfg.userAgent != "" {
+			req.Header.Set("User-Agent", cfg.userAgent)
+		}
+		cfg.headers.apply(req)
+		if cfg.verifyGzip {
+			// Setting this ourselves stops the transport decompressing
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
 
+		var timing *timingTrace
+		if cfg.traceTiming {
+			var traced context.Context
+			traced, timing = withTimingTrace(req.Context())
+			req = req.WithContext(traced)
+		}
 		start := time.Now()
 		resp, err := client.Do(req)
 		latency := time.Since(start)
+		if timing != nil {
+			t := timing.finish()
+			rec.Timings = &t
+			log.Printf("[worker %d] request %d timing (trace %s) dns=%s connect=%s tls=%s ttfb=%s total=%s",
+				id, job, traceID, t.DNS, t.Connect, t.TLS, t.TTFB, t.Total)
+		}
 
 		if err != nil {
+			if ctx.Err() != nil {
+				lastStatusCode = 0
+				return abort("in flight", latency)
+			}
 			lastErr = err
 			lastStatusCode = 0
+			hasRetryAfter = false
+			failures.observe(err)
+			failures.record(false)
+			if cfg.failFastOnRefused && isConnRefused(err) {
+				// Nothing is listening, so backing off and retrying would
+				// only burn time
+				if failures != nil {
+					failures.refused.Add(1)
+				}
+				log.Printf("[worker %d] request %d connection refused, not retrying (trace %s): %v", id, job, traceID, err)
+				return finish(false, latency)
+			}
 		} else {
+			lastErr = nil
 			lastStatusCode = resp.StatusCode
-			_ = resp.Body.Close()
+			lastRetryAfter, hasRetryAfter = retryAfter(resp, time.Now())
+			// Any answer short of a 5xx shows the target is up
+			failures.record(lastStatusCode < 500)
+			var body io.Reader = resp.Body
+			var gzipErr, hashErr, expectErr error
+			if cfg.verifyGzip && resp.Header.Get("Content-Encoding") == "gzip" {
+				var data []byte
+				data, gzipErr = readGzipBody(resp.Body)
+				body = bytes.NewReader(data)
+			}
+			if failures != nil && failures.hashes != nil && gzipErr == nil && lastStatusCode < 400 {
+				var data []byte
+				if data, hashErr = io.ReadAll(body); hashErr == nil {
+					hashErr = failures.hashes.Check(data)
+				}
+				body = bytes.NewReader(data)
+			}
+			if cfg.expectField != "" && gzipErr == nil && hashErr == nil && lastStatusCode < 400 {
+				expectErr = checkExpectedField(body, cfg.expectField, cfg.expectValue)
+			}
+			_ = drainBody(resp.Body)
+			if gzipErr != nil {
+				if failures != nil {
+					failures.gzipDecode.Add(1)
+				}
+				log.Printf("[worker %d] request %d gzip decode failure (trace %s) status=%d: %v",
+					id, job, traceID, lastStatusCode, gzipErr)
+				return finish(false, latency)
+			}
+			if hashErr != nil {
+				rec.ErrorClass = errClassBody
+				log.Printf("[worker %d] request %d response hash mismatch (trace %s) status=%d: %v",
+					id, job, traceID, lastStatusCode, hashErr)
+				return finish(false, latency)
+			}
+			if expectErr != nil {
+				// The server answered, just not as expected, so retrying
+				// won't help
+				rec.ErrorClass = errClassBody
+				log.Printf("[worker %d] request %d unexpected body (trace %s) status=%d: %v",
+					id, job, traceID, lastStatusCode, expectErr)
+				return finish(false, latency)
+			}
 		}
 
 		// Success case
@@ -96,66 +657,472 @@ func doRequestWithRetry(id int, job int, cfg config, client *http.Client, traceI
 				log.Printf("[worker %d] request %d succeeded on retry %d (trace %s) status=%d latency=%s",
 					id, job, attempt, traceID, lastStatusCode, latency)
 			}
-			return true, latency
+			return finish(true, latency)
 		}
 
 		// Check if retryable
-		if !isRetryableError(err, lastStatusCode) {
+		if !isRetryableError(err, lastStatusCode, cfg.retryOn) {
 			log.Printf("[worker %d] request %d failed non-retryable (trace %s) status=%d: %v",
 				id, job, traceID, lastStatusCode, err)
-			return false, latency
+			return finish(false, latency)
 		}
 
-		// If not last attempt, wait with exponential backoff
+		// If not last attempt, wait before retrying
 		if attempt < cfg.maxRetries {
-			backoff := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
-			if backoff > 2*time.Second {
-				backoff = 2 * time.Second
-			}
+			backoff := retryDelay(attempt, cfg, lastRetryAfter, hasRetryAfter)
 			log.Printf("[worker %d] request %d failed (trace %s) attempt %d/%d, retrying in %v: %v",
 				id, job, traceID, attempt+1, cfg.maxRetries+1, backoff, err)
-			time.Sleep(backoff)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return abort("during backoff", latency)
+			}
 		}
 	}
 
 	// All retries exhausted
+	rec.RetriesExhausted = true
 	log.Printf("[worker %d] request %d failed after %d retries (trace %s) status=%d: %v",
 		id, job, cfg.maxRetries, traceID, lastStatusCode, lastErr)
-	return false, 0
+	return finish(false, 0)
+}
+
+// userStats tracks the results of a single worker (virtual user).
+type userStats struct {
+	started    time.Time
+	requests   int
+	failures   int
+	latencySum time.Duration
+}
+
+// loadRun holds the state shared by the workers of a single run.
+type loadRun struct {
+	cfg      config
+	client   *http.Client
+	series   *timeSeries
+	results  *resultsCollector
+	adaptive *adaptiveLimiter // nil unless -adaptive-concurrency
+	rate     *rateLimiter     // nil unless -model hybrid
+	dispatch *rateLimiter     // nil unless -rps with -model closed
+	tee      *teeForwarder    // nil unless -tee
+	pacer    *serverPacer     // nil unless -respect-ratelimit
+	targets  *targetPicker    // nil unless several -target
+	apdex    *apdexCounter    // nil unless -apdex-threshold
+	failures failureCounts
+	conns    *connTracker
+	traceID  func(job int) string
+	// completed counts finished requests, warmup included, and
+	// warmupUntil ends the -warmup-duration window
+	completed   atomic.Int64
+	warmupUntil time.Time
+	// Indexed by worker id; each worker only writes its own entry
+	users []userStats
+}
+
+func newLoadRun(cfg config, client *http.Client) *loadRun {
+	lr := &loadRun{
+		cfg:     cfg,
+		client:  client,
+		series:  newTimeSeries(),
+		results: newResultsCollector(),
+		users:   make([]userStats, cfg.concurrency),
+		traceID: func(int) string {
+			return uuid.NewString()
+		},
+	}
+	lr.results.keepRecords = cfg.output == "csv"
+	if cfg.deterministic {
+		lr.traceID = func(job int) string {
+			return seededTraceID(cfg.seed, job)
+		}
+	}
+	if cfg.adaptive {
+		lr.adaptive = newAdaptiveLimiter(cfg.concurrency, adaptiveWindow, cfg.adaptiveErrorThreshold, cfg.adaptiveLatencyThreshold)
+	}
+	switch {
+	case cfg.model == "hybrid":
+		lr.rate = newRateLimiter(cfg.rps)
+	case cfg.rps > 0:
+		lr.dispatch = newRateLimiter(cfg.rps)
+	}
+	if len(cfg.targets) > 1 {
+		lr.targets = newTargetPicker(cfg.targets)
+	}
+	if cfg.breakerThreshold > 0 {
+		lr.failures.breaker = newCircuitBreaker(cfg.breakerThreshold, cfg.breakerCooldown)
+	}
+	if cfg.verifyHash || cfg.expectHash != "" {
+		lr.failures.hashes = newHashVerifier(cfg.expectHash)
+	}
+	if cfg.apdexThreshold > 0 {
+		lr.apdex = newApdexCounter(cfg.apdexThreshold)
+	}
+	lr.conns = newConnTracker(client.Transport)
+	tracked := *client
+	tracked.Transport = lr.conns
+	lr.client = &tracked
+	if cfg.respectRateLimit {
+		// Each worker may already have a request in flight when the quota
+		// runs low, so pause while fewer tokens than workers remain
+		lr.pacer = newServerPacer(lr.client.Transport, cfg.concurrency-1)
+		paced := *lr.client
+		paced.Transport = lr.pacer
+		lr.client = &paced
+	}
+	return lr
+}
+
+// execute starts the workers, feeds them cfg.total jobs, or as many as they
+// take until cfg.duration has passed, and waits for them to finish,
+// returning the elapsed time. With a dispatch limiter, jobs are handed out at
+// its rate rather than as fast as workers take them. Cancelling ctx, or
+// reaching cfg.deadline, stops the workers early, aborting their in-flight
+// requests.
+func (lr *loadRun) execute(ctx context.Context) time.Duration {
+	start := time.Now()
+	lr.warmupUntil = start.Add(lr.cfg.warmupDuration)
+	if !lr.cfg.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, lr.cfg.deadline)
+		defer cancel()
+	}
+	var jobs chan int
+	if lr.cfg.duration > 0 {
+		// Unbuffered so jobs are only handed out while time remains
+		jobs = make(chan int)
+	} else {
+		jobs = make(chan int, lr.cfg.total)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(lr.cfg.concurrency)
+	go lr.startWorkers(ctx, jobs, &wg)
+
+	if lr.cfg.duration > 0 {
+		deadline := time.NewTimer(lr.cfg.duration)
+	feed:
+		for job := 1; ; job++ {
+			if lr.dispatch != nil {
+				if err := lr.dispatch.WaitContext(ctx); err != nil {
+					break feed
+				}
+			}
+			// Checked first so an idle worker can't win the select below
+			// once time is up
+			select {
+			case <-deadline.C:
+				break feed
+			default:
+			}
+			select {
+			case jobs <- job:
+			case <-deadline.C:
+				break feed
+			case <-ctx.Done():
+				deadline.Stop()
+				break feed
+			}
+		}
+	} else {
+		for i := 0; i < lr.cfg.total; i++ {
+			if lr.dispatch != nil {
+				if err := lr.dispatch.WaitContext(ctx); err != nil {
+					break
+				}
+			}
+			jobs <- i + 1
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	return time.Since(start)
+}
+
+// startWorkers launches the workers, spacing them ramp/concurrency apart
+// when cfg.ramp is set. If ctx is cancelled mid-ramp, the rest start at once
+// and exit straight away.
+func (lr *loadRun) startWorkers(ctx context.Context, jobs <-chan int, wg *sync.WaitGroup) {
+	step := lr.cfg.ramp / time.Duration(max(lr.cfg.concurrency, 1))
+	for i := 0; i < lr.cfg.concurrency; i++ {
+		if i > 0 && step > 0 {
+			timer := time.NewTimer(step)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+		go worker(ctx, i, lr, jobs, wg)
+	}
+}
+
+// warmingUp counts a finished request and reports whether it falls in the
+// warmup and should be left out of the results.
+func (lr *loadRun) warmingUp() bool {
+	n := lr.completed.Add(1)
+	return n <= int64(lr.cfg.warmup) || time.Now().Before(lr.warmupUntil)
+}
+
+// record adds a finished request to the run's results.
+func (lr *loadRun) record(id int, rec requestRecord) {
+	lr.results.Record(rec)
+	if lr.apdex != nil {
+		lr.apdex.Observe(rec.Success, rec.Latency)
+	}
+
+	user := &lr.users[id]
+	user.requests++
+	user.latencySum += rec.Latency
+	if !rec.Success {
+		user.failures++
+	}
+
+	if rec.Success {
+		log.Printf("[worker %d] request %d ok (trace %s) latency=%s", id, rec.Job, rec.TraceID, rec.Latency)
+	}
+}
+
+// printUserSummary reports aggregate throughput and per-user results.
+func (lr *loadRun) printUserSummary(w io.Writer, elapsed time.Duration) {
+	var total int
+	for _, u := range lr.users {
+		total += u.requests
+	}
+	fmt.Fprintf(w, "%s model: %d requests in %s (%.1f rps, target %.1f)\n",
+		lr.cfg.model, total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds(), lr.cfg.rps)
+	for id, u := range lr.users {
+		var avg time.Duration
+		if u.requests > 0 {
+			avg = u.latencySum / time.Duration(u.requests)
+		}
+		fmt.Fprintf(w, "  user %d: requests=%d failures=%d avg latency=%s\n", id, u.requests, u.failures, avg)
+	}
+}
+
+// writeOutput writes the run's results in the -output format to
+// -output-file, or stdout.
+func (lr *loadRun) writeOutput() error {
+	if lr.cfg.outputFile == "" {
+		return writeResults(os.Stdout, lr.cfg.output, lr.results)
+	}
+	f, err := os.Create(lr.cfg.outputFile)
+	if err != nil {
+		return err
+	}
+	if err := writeResults(f, lr.cfg.output, lr.results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func worker(id int, cfg config, jobs <-chan int, client *http.Client, wg *sync.WaitGroup) {
+// writeHeatmapFile writes the run's latency heatmap CSV to path.
+func (lr *loadRun) writeHeatmapFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHeatmap(f, lr.series.Range(0, math.MaxInt64)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func worker(ctx context.Context, id int, lr *loadRun, jobs <-chan int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	lr.users[id].started = time.Now()
 	for job := range jobs {
-		traceID := uuid.NewString()
-		success, latency := doRequestWithRetry(id, job, cfg, client, traceID)
-
-		if success {
-			log.Printf("[worker %d] request %d ok (trace %s) latency=%s", id, job, traceID, latency)
+		if ctx.Err() != nil {
+			return
+		}
+		traceID := lr.traceID(job)
+		cfg := lr.cfg
+		if lr.targets != nil {
+			cfg.target = lr.targets.Next()
+		}
+		if lr.rate != nil && lr.rate.WaitContext(ctx) != nil {
+			return
+		}
+		if lr.pacer != nil && lr.pacer.Wait(ctx) != nil {
+			return
+		}
+		if lr.adaptive != nil && lr.adaptive.Acquire(ctx) != nil {
+			return
+		}
+		// Mirrored only once the request is going out, not while it may
+		// still be cancelled in a wait above
+		if lr.tee != nil {
+			lr.tee.Send(cfg.method, cfg.target, traceID)
+		}
+		rec := doRequest(ctx, id, job, cfg, lr.client, traceID, &lr.failures)
+		success, latency := rec.Success, rec.Latency
+		if lr.adaptive != nil {
+			lr.adaptive.Release(success, latency)
+		}
+		// Warmup requests stay out of the stats, the per-second series
+		// behind the heatmap and remote-write included
+		if lr.warmingUp() {
+			log.Printf("[worker %d] request %d warmup (trace %s) success=%t latency=%s", id, job, traceID, success, latency)
+		} else {
+			lr.series.Observe(time.Now(), success, latency)
+			lr.record(id, rec)
 		}
 
-		time.Sleep(cfg.interval)
+		// Hybrid users run back-to-back, and dispatched jobs arrive already
+		// paced; otherwise the worker paces itself
+		if lr.rate == nil && lr.dispatch == nil {
+			select {
+			case <-time.After(lr.cfg.interval):
+			case <-ctx.Done():
+				return
+			}
+		}
 	}
 }
 
 func main() {
 	cfg := parseConfig()
-	log.Printf("starting client target=%s total=%d concurrency=%d interval=%s", cfg.target, cfg.total, cfg.concurrency, cfg.interval)
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if cfg.insecure {
+		log.Printf("warning: -insecure set, TLS certificates are not verified")
+	}
+	log.Printf("starting client target=%s total=%d duration=%s concurrency=%d interval=%s model=%s", cfg.target, cfg.total, cfg.duration, cfg.concurrency, cfg.interval, cfg.model)
 
-	client := &http.Client{Timeout: cfg.timeout}
-	jobs := make(chan int, cfg.total)
+	bytes := &byteCounter{}
+	client, err := newHTTPClient(cfg, bytes)
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+	lr := newLoadRun(cfg, client)
+	if cfg.tee != "" {
+		tee, err := newTeeForwarder(cfg.tee, cfg.timeout)
+		if err != nil {
+			log.Fatalf("invalid -tee URL: %v", err)
+		}
+		lr.tee = tee
+	}
+	if cfg.patternFile != "" {
+		pattern, err := readPatternFile(cfg.patternFile)
+		if err != nil {
+			log.Fatalf("invalid -pattern-file: %v", err)
+		}
+		runTime := cfg.duration
+		if runTime == 0 {
+			runTime = pattern.duration(cfg.total, cfg.rps)
+		}
+		lr.rate = newPatternRateLimiter(cfg.rps, pattern, runTime)
+	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < cfg.concurrency; i++ {
-		wg.Add(1)
-		go worker(i, cfg, jobs, client, &wg)
+	var stopPush chan struct{}
+	var pushDone chan struct{}
+	if cfg.remoteWrite != "" {
+		stopPush, pushDone = make(chan struct{}), make(chan struct{})
+		go newRemoteWriter(cfg.remoteWrite, cfg.target, lr.series, cfg.timeout).run(stopPush, pushDone)
 	}
 
-	for i := 0; i < cfg.total; i++ {
-		jobs <- i + 1
+	// Ctrl-C or SIGTERM stops the run early; the summary below still covers
+	// whatever completed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var progressDone chan struct{}
+	stopProgress := func() {}
+	if cfg.progress > 0 {
+		var progressCtx context.Context
+		progressCtx, stopProgress = context.WithCancel(ctx)
+		progressDone = make(chan struct{})
+		go reportProgress(progressCtx, os.Stderr, cfg.progress, lr.results, progressDone)
+	}
+	started := time.Now()
+	elapsed := lr.execute(ctx)
+	finished := time.Now()
+	stopProgress()
+	if progressDone != nil {
+		<-progressDone
+	}
+	switch {
+	case ctx.Err() != nil:
+		log.Printf("interrupted after %s, printing partial summary", elapsed.Round(time.Millisecond))
+	case !cfg.deadline.IsZero() && !finished.Before(cfg.deadline):
+		log.Printf("deadline reached after %s, printing partial summary", elapsed.Round(time.Millisecond))
 	}
-	close(jobs)
 
-	wg.Wait()
-	fmt.Println("client finished")
+	// Keep stdout machine-readable when it carries JSON or CSV results
+	var info io.Writer = os.Stdout
+	if cfg.output != outputText && cfg.outputFile == "" {
+		info = os.Stderr
+	}
+
+	if stopPush != nil {
+		close(stopPush)
+		<-pushDone
+	}
+	if lr.tee != nil {
+		lr.tee.Close()
+		fmt.Fprintf(info, "tee forwarded=%d failed=%d dropped=%d\n", lr.tee.forwarded.Load(), lr.tee.failed.Load(), lr.tee.dropped.Load())
+	}
+	if cfg.heatmapFile != "" {
+		if err := lr.writeHeatmapFile(cfg.heatmapFile); err != nil {
+			log.Printf("failed to write heatmap: %v", err)
+		}
+	}
+	if cfg.perWorker {
+		lr.results.Summary().PrintWorkers(info)
+	}
+	if lr.adaptive != nil {
+		fmt.Fprintf(info, "concurrency trajectory: %s\n", lr.adaptive.Trajectory())
+	}
+	if cfg.model == "hybrid" || lr.dispatch != nil {
+		lr.printUserSummary(info, elapsed)
+	}
+	if lr.apdex != nil {
+		fmt.Fprintf(info, "apdex(T=%s)=%.3f satisfied=%d tolerating=%d frustrated=%d\n", cfg.apdexThreshold, lr.apdex.Score(),
+			lr.apdex.satisfied.Load(), lr.apdex.tolerating.Load(), lr.apdex.frustrated.Load())
+	}
+	fmt.Fprintf(info, "connection resets=%d\n", lr.failures.resets.Load())
+	fmt.Fprintf(info, "connections reused=%d new=%d reuse rate=%.1f%%\n", lr.conns.reused.Load(), lr.conns.fresh.Load(), lr.conns.ReuseRate()*100)
+	if b := lr.failures.breaker; b != nil {
+		opened, shortCircuited := b.Stats()
+		fmt.Fprintf(info, "circuit breaker opened=%d short-circuited=%d\n", opened, shortCircuited)
+	}
+	if h := lr.failures.hashes; h != nil {
+		checked, mismatched := h.Stats()
+		fmt.Fprintf(info, "response hashes checked=%d mismatched=%d\n", checked, mismatched)
+	}
+	if cfg.failFastOnRefused {
+		fmt.Fprintf(info, "connection refused fast-fails=%d\n", lr.failures.refused.Load())
+	}
+	if cfg.totalTimeout > 0 {
+		fmt.Fprintf(info, "total timeouts exceeded=%d\n", lr.failures.totalTimeouts.Load())
+	}
+	if cfg.verifyGzip {
+		fmt.Fprintf(info, "gzip decode failures=%d\n", lr.failures.gzipDecode.Load())
+	}
+	sent, received := bytes.sent.Load(), bytes.received.Load()
+	fmt.Fprintf(info, "bytes sent=%d received=%d\n", sent, received)
+	if cfg.costPerGBIn > 0 || cfg.costPerGBOut > 0 {
+		fmt.Fprintf(info, "estimated transfer cost: $%.6f\n", estimateCost(received, sent, cfg.costPerGBIn, cfg.costPerGBOut))
+	}
+	if err := lr.writeOutput(); err != nil {
+		log.Printf("failed to write results: %v", err)
+	}
+	if cfg.manifestFile != "" {
+		manifest := newRunManifest(flag.CommandLine, started, finished, lr.results.Summary())
+		if err := writeManifestFile(cfg.manifestFile, manifest); err != nil {
+			log.Printf("failed to write manifest: %v", err)
+		}
+	}
+	violations := cfg.slo.violations(lr.results.Summary())
+	for _, v := range violations {
+		fmt.Fprintf(info, "SLO violated: %s\n", v)
+	}
+	fmt.Fprintln(info, "client finished")
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
 }
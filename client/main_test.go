@@ -1,9 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -13,21 +25,35 @@ func TestIsRetryableError(t *testing.T) {
 		name       string
 		err        error
 		statusCode int
+		retryOn    string // empty for the default policy
 		want       bool
 	}{
-		{"network error", &timeoutError{}, 0, true},
-		{"500 error", nil, 500, true},
-		{"502 error", nil, 502, true},
-		{"429 error", nil, 429, true},
-		{"400 error", nil, 400, false},
-		{"404 error", nil, 404, false},
-		{"200 success", nil, 200, false},
-		{"nil error 200", nil, 200, false},
+		{"network error", &timeoutError{}, 0, "", true},
+		{"500 error", nil, 500, "", true},
+		{"502 error", nil, 502, "", true},
+		{"429 error", nil, 429, "", true},
+		{"400 error", nil, 400, "", false},
+		{"404 error", nil, 404, "", false},
+		{"200 success", nil, 200, "", false},
+		{"nil error 200", nil, 200, "", false},
+		{"408 opted in", nil, 408, "408,429,500-599", true},
+		{"501 opted out", nil, 501, "429,500,502-599", false},
+		{"503 with 501 opted out", nil, 503, "429,500,502-599", true},
+		{"429 left out", nil, 429, "500-599", false},
+		{"network error with no statuses", &timeoutError{}, 0, " ", true},
+		{"500 with no statuses", nil, 500, " ", false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := isRetryableError(tt.err, tt.statusCode)
+			var policy retryPolicy
+			if tt.retryOn != "" {
+				var err error
+				if policy, err = parseRetryPolicy(tt.retryOn); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got := isRetryableError(tt.err, tt.statusCode, policy)
 			if got != tt.want {
 				t.Errorf("isRetryableError() = %v, want %v", got, tt.want)
 			}
@@ -103,7 +129,7 @@ func TestDoRequestWithRetry_Success(t *testing.T) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 
-	success, latency := doRequestWithRetry(1, 1, cfg, client, "test-trace")
+	success, latency := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "test-trace", nil)
 	if !success {
 		t.Error("expected request to succeed")
 	}
@@ -131,7 +157,7 @@ func TestDoRequestWithRetry_RetryableFailure(t *testing.T) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 
-	success, _ := doRequestWithRetry(1, 1, cfg, client, "test-trace")
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "test-trace", nil)
 	if !success {
 		t.Error("expected request to succeed after retries")
 	}
@@ -153,12 +179,491 @@ func TestDoRequestWithRetry_NonRetryableFailure(t *testing.T) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 
-	success, _ := doRequestWithRetry(1, 1, cfg, client, "test-trace")
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "test-trace", nil)
 	if success {
 		t.Error("expected request to fail (non-retryable)")
 	}
 }
 
+func TestDoRequestWithRetry_CancelDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Backoffs of 100ms, 200ms, 400ms, ... would take seconds in total
+	cfg := config{
+		target:     server.URL,
+		maxRetries: 5,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(150*time.Millisecond, cancel)
+
+	start := time.Now()
+	success, _ := doRequestWithRetry(ctx, 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", nil)
+	elapsed := time.Since(start)
+
+	if success {
+		t.Error("expected an interrupted request to fail")
+	}
+	if elapsed > 300*time.Millisecond {
+		t.Errorf("expected to return soon after cancel, took %v", elapsed)
+	}
+}
+
+func TestDoRequestWithRetry_CancelInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	var failures failureCounts
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	success, _ := doRequestWithRetry(ctx, 1, 1, config{target: server.URL, maxRetries: 3}, &http.Client{Timeout: 5 * time.Second}, "test-trace", &failures)
+	elapsed := time.Since(start)
+
+	if success {
+		t.Error("expected an interrupted request to fail")
+	}
+	if elapsed > time.Second {
+		t.Errorf("expected the HTTP call to be aborted, took %v", elapsed)
+	}
+	if failures.resets.Load() != 0 {
+		t.Errorf("expected cancellation not to count as a reset, got %d", failures.resets.Load())
+	}
+}
+
+func TestDoRequest_PerAttemptTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:       server.URL,
+		maxRetries:   2,
+		backoff:      backoffConstant,
+		backoffBase:  10 * time.Millisecond,
+		totalTimeout: 5 * time.Second,
+	}
+	var failures failureCounts
+	rec := doRequest(context.Background(), 1, 1, cfg, &http.Client{Timeout: 50 * time.Millisecond}, "test-trace", &failures)
+
+	if rec.Success || rec.DeadlineExceeded {
+		t.Errorf("expected a plain failure after per-attempt timeouts, got %+v", rec)
+	}
+	if rec.Attempts != 3 {
+		t.Errorf("expected every attempt to time out and be retried, got %d attempts", rec.Attempts)
+	}
+	if n := failures.totalTimeouts.Load(); n != 0 {
+		t.Errorf("expected no total timeouts, got %d", n)
+	}
+}
+
+func TestDoRequest_TotalTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "mid-request",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			},
+		},
+		{
+			name: "mid-backoff",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			cfg := config{
+				target:       server.URL,
+				maxRetries:   5,
+				backoff:      backoffConstant,
+				backoffBase:  time.Second,
+				totalTimeout: 100 * time.Millisecond,
+			}
+			var failures failureCounts
+			start := time.Now()
+			rec := doRequest(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", &failures)
+			elapsed := time.Since(start)
+
+			if rec.Success || !rec.DeadlineExceeded {
+				t.Errorf("expected deadline exceeded, got %+v", rec)
+			}
+			if rec.Attempts != 1 {
+				t.Errorf("expected to give up during the first attempt, got %d attempts", rec.Attempts)
+			}
+			if elapsed > 300*time.Millisecond {
+				t.Errorf("expected to abort at the total timeout, took %v", elapsed)
+			}
+			if n := failures.totalTimeouts.Load(); n != 1 {
+				t.Errorf("expected 1 total timeout, got %d", n)
+			}
+		})
+	}
+}
+
+func TestDoRequest_CancelIsNotTotalTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, maxRetries: 5, totalTimeout: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	rec := doRequest(ctx, 1, 1, cfg, &http.Client{Timeout: time.Second}, "test-trace", nil)
+	if rec.Success || rec.DeadlineExceeded {
+		t.Errorf("expected a cancelled run not to count as deadline exceeded, got %+v", rec)
+	}
+}
+
+func TestLoadRunDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, model := range []string{"closed", "hybrid"} {
+		t.Run(model, func(t *testing.T) {
+			cfg := config{
+				target:      server.URL,
+				duration:    300 * time.Millisecond,
+				concurrency: 3,
+				interval:    20 * time.Millisecond,
+				model:       model,
+				rps:         100,
+			}
+			lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+			elapsed := lr.execute(context.Background())
+
+			if elapsed < cfg.duration || elapsed > cfg.duration+200*time.Millisecond {
+				t.Errorf("expected the run to take ~%v, took %v", cfg.duration, elapsed)
+			}
+			s := lr.results.Summary()
+			if s.Total < 10 || s.Failures != 0 {
+				t.Errorf("expected a steady stream of successful requests, got %+v", s)
+			}
+		})
+	}
+}
+
+func TestLoadRunRetryStats(t *testing.T) {
+	// Each request fails twice before succeeding, except that job 4 never
+	// succeeds
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID := r.Header.Get("X-Trace-Id")
+		mu.Lock()
+		attempts[traceID]++
+		n := attempts[traceID]
+		mu.Unlock()
+		if n <= 2 || traceID == seededTraceID(1, 4) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:        server.URL,
+		total:         5,
+		concurrency:   2,
+		model:         "closed",
+		maxRetries:    3,
+		backoff:       backoffConstant,
+		backoffBase:   time.Millisecond,
+		deterministic: true,
+		seed:          1,
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	s := lr.results.Summary()
+	// 4 requests retried twice, and one that used all 3 retries
+	if s.Retries != 4*2+3 || s.RetriedSuccesses != 4 || s.RetriesExhausted != 1 {
+		t.Errorf("expected retries=11 succeeded after retry=4 exhausted=1, got %d/%d/%d",
+			s.Retries, s.RetriedSuccesses, s.RetriesExhausted)
+	}
+}
+
+func TestLoadRunStatusCounts(t *testing.T) {
+	statuses := []int{http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusOK, http.StatusTooManyRequests, http.StatusInternalServerError}
+	var next atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[int(next.Add(1)-1)%len(statuses)])
+	}))
+	defer server.Close()
+
+	// No retries, so each cycle of statuses maps to one cycle of requests
+	cfg := config{target: server.URL, total: 4 * len(statuses), concurrency: 3, model: "closed"}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	s := lr.results.Summary()
+	want := map[int]int{200: 8, 201: 4, 404: 4, 429: 4, 500: 4}
+	if !reflect.DeepEqual(s.StatusCounts, want) {
+		t.Errorf("expected status counts %v, got %v", want, s.StatusCounts)
+	}
+
+	var out strings.Builder
+	s.Print(&out)
+	if line := "status codes 200=8 201=4 404=4 429=4 500=4\n"; !strings.Contains(out.String(), line) {
+		t.Errorf("expected %q in the summary, got:\n%s", line, out.String())
+	}
+}
+
+func TestLoadRunWarmup(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	t.Run("count", func(t *testing.T) {
+		hits.Store(0)
+		cfg := config{target: server.URL, total: 10, warmup: 3, concurrency: 2, model: "closed"}
+		lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+		lr.execute(context.Background())
+
+		if n := hits.Load(); n != 10 {
+			t.Errorf("expected warmup requests to be sent too, server saw %d", n)
+		}
+		if s := lr.results.Summary(); s.Total != 7 {
+			t.Errorf("expected 7 recorded requests after 3 warmup, got %d", s.Total)
+		}
+
+		buckets := lr.series.Range(0, math.MaxInt64)
+		var inSeries int64
+		for _, b := range buckets {
+			inSeries += b.requests
+		}
+		if inSeries != 7 {
+			t.Errorf("expected 7 requests in the per-second series, got %d", inSeries)
+		}
+		var heatmap strings.Builder
+		if err := writeHeatmap(&heatmap, buckets); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rows, err := csv.NewReader(strings.NewReader(heatmap.String())).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid heatmap CSV: %v", err)
+		}
+		var inHeatmap int
+		for _, row := range rows[1:] {
+			for _, cell := range row[1:] {
+				n, _ := strconv.Atoi(cell)
+				inHeatmap += n
+			}
+		}
+		if inHeatmap != 7 {
+			t.Errorf("expected 7 requests in the heatmap, got %d", inHeatmap)
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		hits.Store(0)
+		cfg := config{
+			target:         server.URL,
+			total:          10,
+			warmupDuration: 200 * time.Millisecond,
+			concurrency:    1,
+			interval:       50 * time.Millisecond,
+			model:          "closed",
+		}
+		lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+		lr.execute(context.Background())
+
+		// Requests finish at ~0, 50, 100 and 150ms inside the window
+		s := lr.results.Summary()
+		if hits.Load() != 10 || s.Total < 4 || s.Total > 7 {
+			t.Errorf("expected all 10 sent and the first few left out, sent %d and recorded %d", hits.Load(), s.Total)
+		}
+	})
+}
+
+func TestLoadRunRamp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		total:       40,
+		concurrency: 4,
+		interval:    20 * time.Millisecond,
+		ramp:        300 * time.Millisecond,
+		model:       "closed",
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	// One worker every 75ms
+	for id := 1; id < len(lr.users); id++ {
+		if gap := lr.users[id].started.Sub(lr.users[id-1].started); gap < 50*time.Millisecond {
+			t.Errorf("expected worker %d to start ~75ms after worker %d, got %v", id, id-1, gap)
+		}
+	}
+	if spread := lr.users[3].started.Sub(lr.users[0].started); spread < 200*time.Millisecond || spread > 400*time.Millisecond {
+		t.Errorf("expected worker starts spread over ~225ms, got %v", spread)
+	}
+
+	cfg.ramp = 0
+	lr = newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+	if spread := lr.users[3].started.Sub(lr.users[0].started).Abs(); spread > 50*time.Millisecond {
+		t.Errorf("expected workers to start together without a ramp, got a spread of %v", spread)
+	}
+}
+
+func TestLoadRunStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		total:       1000,
+		concurrency: 2,
+		interval:    50 * time.Millisecond,
+		model:       "closed",
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	elapsed := lr.execute(ctx)
+
+	if elapsed > time.Second {
+		t.Errorf("expected the run to stop soon after cancel, took %v", elapsed)
+	}
+	var total int
+	for _, u := range lr.users {
+		total += u.requests
+	}
+	if total == 0 || total >= cfg.total {
+		t.Errorf("expected a partial run, got %d of %d requests", total, cfg.total)
+	}
+}
+
+func TestLoadRunStopsAtDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		total:       1000,
+		concurrency: 4,
+		model:       "closed",
+		deadline:    time.Now().Add(250 * time.Millisecond),
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	if overrun := time.Since(cfg.deadline); overrun > 200*time.Millisecond {
+		t.Errorf("expected the run to stop at the deadline, overran by %v", overrun)
+	}
+	s := lr.results.Summary()
+	if s.Successes == 0 || s.Total >= cfg.total {
+		t.Errorf("expected partial results, got %d successes of %d recorded (%d planned)", s.Successes, s.Total, cfg.total)
+	}
+}
+
+func TestDoRequestRecordsAttempts(t *testing.T) {
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, maxRetries: 3}
+	rec := doRequest(context.Background(), 2, 7, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", nil)
+	if !rec.Success || rec.Status != http.StatusOK || rec.Attempts != 2 {
+		t.Errorf("expected success with status 200 on attempt 2, got %+v", rec)
+	}
+	if rec.Worker != 2 || rec.Job != 7 || rec.TraceID != "test-trace" {
+		t.Errorf("expected worker 2, job 7, trace test-trace, got %+v", rec)
+	}
+}
+
+func TestDoRequestWithRetry_PostBody(t *testing.T) {
+	const payload = `{"name":"load"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != payload || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected POST %s as application/json, got %s %q as %q", payload, r.Method, body, r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		method:      http.MethodPost,
+		body:        []byte(payload),
+		contentType: "application/json",
+	}
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", nil)
+	if !success {
+		t.Error("expected POST to succeed")
+	}
+}
+
+func TestDoRequestWithRetry_PostBodyResentOnRetry(t *testing.T) {
+	const payload = "resend me in full"
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:     server.URL,
+		maxRetries: 3,
+		method:     http.MethodPut,
+		body:       []byte(payload),
+	}
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", nil)
+	if !success {
+		t.Fatal("expected the request to succeed on retry")
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(bodies))
+	}
+	for i, body := range bodies {
+		if body != payload {
+			t.Errorf("attempt %d: expected body %q, got %q", i+1, payload, body)
+		}
+	}
+}
+
 func TestDoRequestWithRetry_ExhaustRetries(t *testing.T) {
 	// Create a test server that always fails
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -172,8 +677,299 @@ func TestDoRequestWithRetry_ExhaustRetries(t *testing.T) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 
-	success, _ := doRequestWithRetry(1, 1, cfg, client, "test-trace")
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "test-trace", nil)
 	if success {
 		t.Error("expected request to fail after exhausting retries")
 	}
 }
+
+func TestDoRequestWithRetry_ConnectionReset(t *testing.T) {
+	// The server aborts every connection with a TCP RST instead of answering
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:     server.URL,
+		maxRetries: 1,
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	var failures failureCounts
+
+	success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, client, "test-trace", &failures)
+	if success {
+		t.Error("expected request to fail on connection reset")
+	}
+	// The reset is retryable, so both attempts hit it
+	if got := failures.resets.Load(); got != 2 {
+		t.Errorf("expected 2 connection resets, got %d", got)
+	}
+}
+
+func TestDoRequest_FailFastOnRefused(t *testing.T) {
+	// Grab a free port, then close it so connections to it are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "http://" + ln.Addr().String()
+	ln.Close()
+
+	for _, failFast := range []bool{false, true} {
+		name := "retry"
+		if failFast {
+			name = "fail-fast"
+		}
+		t.Run(name, func(t *testing.T) {
+			// Retrying would back off 100ms then 200ms
+			cfg := config{
+				target:            target,
+				maxRetries:        2,
+				backoff:           backoffExponential,
+				failFastOnRefused: failFast,
+			}
+			var failures failureCounts
+
+			start := time.Now()
+			rec := doRequest(context.Background(), 1, 1, cfg, &http.Client{Timeout: time.Second}, "test-trace", &failures)
+			elapsed := time.Since(start)
+
+			if rec.Success || rec.ErrorClass != errClassConnection {
+				t.Errorf("expected a connection failure, got %+v", rec)
+			}
+			if failFast {
+				if rec.Attempts != 1 || rec.RetriesExhausted || failures.refused.Load() != 1 {
+					t.Errorf("expected to give up after 1 attempt, got %d attempts (refused=%d)", rec.Attempts, failures.refused.Load())
+				}
+				if elapsed > 100*time.Millisecond {
+					t.Errorf("expected to fail without backing off, took %v", elapsed)
+				}
+				return
+			}
+			if rec.Attempts != 3 || !rec.RetriesExhausted || failures.refused.Load() != 0 {
+				t.Errorf("expected all 3 attempts, got %d (refused=%d)", rec.Attempts, failures.refused.Load())
+			}
+			if elapsed < 300*time.Millisecond {
+				t.Errorf("expected retries to back off, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestDoRequest_FailFastKeepsRetryingOtherErrors(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, maxRetries: 2, failFastOnRefused: true}
+	rec := doRequest(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", nil)
+	if !rec.Success || rec.Attempts != 2 {
+		t.Errorf("expected success on the retry, got %+v", rec)
+	}
+}
+
+func TestDoRequestWithRetry_VerifyGzip(t *testing.T) {
+	var valid bytes.Buffer
+	zw := gzip.NewWriter(&valid)
+	zw.Write([]byte(`{"message":"hello"}`))
+	zw.Close()
+	corrupt := append([]byte(nil), valid.Bytes()...)
+	corrupt[len(corrupt)-5] ^= 0xff // break the CRC
+
+	tests := []struct {
+		name        string
+		body        []byte
+		wantSuccess bool
+		wantCount   int64
+	}{
+		{"valid gzip", valid.Bytes(), true, 0},
+		{"corrupt gzip", corrupt, false, 1},
+		{"not gzip at all", []byte("plain text"), false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept-Encoding") != "gzip" {
+					t.Errorf("expected Accept-Encoding gzip, got %q", r.Header.Get("Accept-Encoding"))
+				}
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			cfg := config{target: server.URL, maxRetries: 2, verifyGzip: true}
+			var failures failureCounts
+			success, _ := doRequestWithRetry(context.Background(), 1, 1, cfg, &http.Client{Timeout: 5 * time.Second}, "test-trace", &failures)
+
+			if success != tt.wantSuccess {
+				t.Errorf("expected success=%v, got %v", tt.wantSuccess, success)
+			}
+			if got := failures.gzipDecode.Load(); got != tt.wantCount {
+				t.Errorf("expected %d gzip decode failures, got %d", tt.wantCount, got)
+			}
+		})
+	}
+}
+
+func TestHybridModeCapsAggregateRPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		total:       26,
+		concurrency: 4,
+		interval:    time.Second, // ignored by hybrid users
+		model:       "hybrid",
+		rps:         50,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	elapsed := lr.execute(context.Background())
+
+	// 26 requests at 50 rps take ~500ms; with a 1s think time they'd take 6s+
+	rps := float64(cfg.total) / elapsed.Seconds()
+	if rps < 40 || rps > 60 {
+		t.Errorf("expected aggregate rps near 50, got %.1f over %v", rps, elapsed)
+	}
+
+	var total int
+	for id, u := range lr.users {
+		if u.requests == 0 {
+			t.Errorf("user %d sent no requests", id)
+		}
+		total += u.requests
+	}
+	if total != cfg.total {
+		t.Errorf("expected per-user requests to sum to %d, got %d", cfg.total, total)
+	}
+
+	var out strings.Builder
+	lr.printUserSummary(&out, elapsed)
+	if !strings.Contains(out.String(), "hybrid model: 26 requests") || !strings.Contains(out.String(), "user 3:") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestClosedModelDispatchesAtRPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		total:       26,
+		concurrency: 8,
+		interval:    time.Second, // ignored when dispatching at -rps
+		model:       "closed",
+		rps:         50,
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	elapsed := lr.execute(context.Background())
+
+	// 8 free-running workers would finish in a few ms; 26 jobs dispatched
+	// at 50 rps take ~500ms
+	rps := float64(cfg.total) / elapsed.Seconds()
+	if rps < 40 || rps > 60 {
+		t.Errorf("expected dispatch rate near 50 rps, got %.1f over %v", rps, elapsed)
+	}
+
+	var out strings.Builder
+	lr.printUserSummary(&out, elapsed)
+	if !strings.Contains(out.String(), "closed model: 26 requests") || !strings.Contains(out.String(), "target 50.0") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestClosedModelDispatchDuringDuration(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{
+		target:      server.URL,
+		duration:    500 * time.Millisecond,
+		concurrency: 2,
+		model:       "closed",
+		rps:         40,
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	elapsed := lr.execute(context.Background())
+
+	// ~20 jobs fit in 500ms at 40 rps; a loaded machine can fire the
+	// deadline late, so cap by the time the run actually took
+	got := lr.results.Summary().Total
+	if limit := int(elapsed.Seconds()*cfg.rps) + 2; got < 16 || got > limit {
+		t.Errorf("expected ~20 requests in %v at %v rps (at most %d in %v), got %d", cfg.duration, cfg.rps, limit, elapsed, got)
+	}
+}
+
+func TestConfigValidateModel(t *testing.T) {
+	if err := (config{model: "hybrid"}).validate(); err == nil {
+		t.Error("expected error for hybrid model without rps")
+	}
+	if err := (config{model: "open"}).validate(); err == nil {
+		t.Error("expected error for unknown model")
+	}
+	if err := (config{model: "closed"}).validate(); err != nil {
+		t.Errorf("unexpected error for closed model: %v", err)
+	}
+	if err := (config{model: "closed", patternFile: "day.csv"}).validate(); err == nil {
+		t.Error("expected error for -pattern-file without hybrid model")
+	}
+	if err := (config{model: "closed", rps: -1}).validate(); err == nil {
+		t.Error("expected error for negative rps")
+	}
+	if err := (config{model: "closed", backoff: "fibonacci"}).validate(); err == nil {
+		t.Error("expected error for unknown backoff strategy")
+	}
+	if err := (config{model: "closed", totalTimeout: -time.Second}).validate(); err == nil {
+		t.Error("expected error for negative total timeout")
+	}
+	if err := (config{model: "closed", ramp: -time.Second}).validate(); err == nil {
+		t.Error("expected error for negative ramp")
+	}
+	if err := (config{model: "closed", expectValue: "hello"}).validate(); err == nil {
+		t.Error("expected error for -expect-value without -expect-field")
+	}
+	if err := (config{model: "closed", expectHash: "d41d8cd98f00b204e9800998ecf8427e"}).validate(); err == nil {
+		t.Error("expected error for an -expect-hash that isn't a SHA-256")
+	}
+}
+
+func TestNewHTTPClientInsecure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, insecure := range []bool{false, true} {
+		client, err := newHTTPClient(config{timeout: time.Second, insecure: insecure}, &byteCounter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if insecure && err != nil {
+			t.Errorf("expected -insecure to accept the self-signed certificate, got %v", err)
+		}
+		if !insecure && err == nil {
+			t.Error("expected the self-signed certificate to be rejected by default")
+		}
+	}
+}
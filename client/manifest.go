@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "none"
+)
+
+// runManifest describes a finished run well enough to reproduce and
+// interpret it: what was asked for, when it ran, what ran it, and what
+// happened.
+type runManifest struct {
+	Client struct {
+		Version string `json:"version"`
+		Commit  string `json:"commit"`
+	} `json:"client"`
+	// Config holds every flag's resolved value, defaults and environment
+	// overrides included, with credential headers redacted
+	Config      map[string]string `json:"config"`
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Summary     summaryJSON       `json:"summary"`
+	Environment struct {
+		GoVersion string `json:"goVersion"`
+		OS        string `json:"os"`
+		Arch      string `json:"arch"`
+		NumCPU    int    `json:"numCPU"`
+		Hostname  string `json:"hostname"`
+	} `json:"environment"`
+}
+
+// newRunManifest assembles the manifest for a run configured by flags.
+func newRunManifest(flags *flag.FlagSet, start, end time.Time, summary resultsSummary) runManifest {
+	var m runManifest
+	m.Client.Version, m.Client.Commit = version, commit
+	m.Config = map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if h, ok := f.Value.(headerFlags); ok {
+			m.Config[f.Name] = h.redacted()
+			return
+		}
+		m.Config[f.Name] = f.Value.String()
+	})
+	m.Start, m.End = start, end
+	m.Summary = newSummaryJSON(summary)
+	m.Environment.GoVersion = runtime.Version()
+	m.Environment.OS, m.Environment.Arch = runtime.GOOS, runtime.GOARCH
+	m.Environment.NumCPU = runtime.NumCPU()
+	m.Environment.Hostname, _ = os.Hostname()
+	return m
+}
+
+func (m runManifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// writeManifestFile writes m to path.
+func writeManifestFile(path string, m runManifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunManifest(t *testing.T) {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.String("target", "http://localhost:8080/hello", "")
+	flags.Int("count", 20, "")
+	if err := flags.Parse([]string{"-count", "50"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Second)
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := writeManifestFile(path, newRunManifest(flags, start, end, sampleResults().Summary())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("invalid manifest JSON: %v", err)
+	}
+	for _, section := range []string{"client", "config", "start", "end", "summary", "environment"} {
+		if _, ok := raw[section]; !ok {
+			t.Errorf("expected a %q section in %s", section, data)
+		}
+	}
+
+	var m runManifest
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	if m.Config["count"] != "50" || m.Config["target"] != "http://localhost:8080/hello" {
+		t.Errorf("expected resolved flag values, got %v", m.Config)
+	}
+	if !m.Start.Equal(start) || !m.End.Equal(end) {
+		t.Errorf("expected start %v and end %v, got %v and %v", start, end, m.Start, m.End)
+	}
+	if m.Summary.Total != 4 || m.Summary.StatusCounts["200"] != 2 {
+		t.Errorf("unexpected summary: %+v", m.Summary)
+	}
+	if m.Client.Version != version || m.Environment.GoVersion == "" {
+		t.Errorf("expected client version and environment, got %+v %+v", m.Client, m.Environment)
+	}
+}
+
+func TestRunManifestRedactsCredentialHeaders(t *testing.T) {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.Var(headerFlags{}, "header", "")
+	err := flags.Parse([]string{
+		"-header", "Authorization: Bearer s3cret",
+		"-header", "X-Api-Token: abc123",
+		"-header", "X-Tenant: acme",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := newRunManifest(flags, time.Now(), time.Now(), sampleResults().Summary()).Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{"s3cret", "abc123"} {
+		if bytes.Contains(buf.Bytes(), []byte(secret)) {
+			t.Errorf("expected %q to be redacted, got %s", secret, buf.String())
+		}
+	}
+	var m runManifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("invalid manifest JSON: %v", err)
+	}
+	if want := "Authorization: REDACTED, X-Api-Token: REDACTED, X-Tenant: acme"; m.Config["header"] != want {
+		t.Errorf("expected header %q, got %q", want, m.Config["header"])
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errSyntheticLoss is returned for requests dropped by -net-loss. Like a
+// real network error it is retried.
+var errSyntheticLoss = errors.New("synthetic packet loss")
+
+// netAttemptKey is the context key for the netAttempt a request belongs to.
+type netAttemptKey struct{}
+
+// netAttempt identifies one attempt at a job.
+type netAttempt struct {
+	job, attempt int
+}
+
+// withNetAttempt marks ctx as carrying attempt (counting from 0) of job, so
+// netConditions can key that request's jitter and loss on them.
+func withNetAttempt(ctx context.Context, job, attempt int) context.Context {
+	return context.WithValue(ctx, netAttemptKey{}, netAttempt{job, attempt})
+}
+
+// netConditions emulates a degraded network in front of the client's
+// transport: every request is held back by delay plus a uniformly random
+// share of jitter, then dropped with probability loss.
+type netConditions struct {
+	next   http.RoundTripper
+	delay  time.Duration
+	jitter time.Duration
+	loss   float64
+	seed   uint64
+
+	// rng serves requests without a netAttempt
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newNetConditions(next http.RoundTripper, delay, jitter time.Duration, loss float64, seed uint64) *netConditions {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &netConditions{
+		next:   next,
+		delay:  delay,
+		jitter: jitter,
+		loss:   loss,
+		seed:   seed,
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// draw picks the jitter and loss for req. Like seededTraceID it keys on the
+// job, and the attempt, rather than drawing from a shared generator, so
+// under -deterministic each request is delayed or dropped the same way
+// whichever worker sends it.
+func (n *netConditions) draw(req *http.Request) (wait time.Duration, dropped bool) {
+	rng := n.rng
+	if a, ok := req.Context().Value(netAttemptKey{}).(netAttempt); ok {
+		// The job alone keys the trace ID stream; the attempt goes in the
+		// first word to keep the two apart
+		rng = rand.New(rand.NewPCG(n.seed^uint64(a.attempt+1)<<32, uint64(a.job)))
+	} else {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+	}
+	wait = n.delay
+	if n.jitter > 0 {
+		wait += time.Duration(rng.Int64N(int64(n.jitter) + 1))
+	}
+	return wait, n.loss > 0 && rng.Float64() < n.loss
+}
+
+func (n *netConditions) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait, dropped := n.draw(req)
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if dropped {
+		return nil, errSyntheticLoss
+	}
+	return n.next.RoundTrip(req)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNetLossFailsEveryRequest(t *testing.T) {
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, timeout: time.Second, maxRetries: 1, netLoss: 1.0}
+	client, err := newHTTPClient(cfg, &byteCounter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if success, _ := doRequestWithRetry(context.Background(), 1, i, cfg, client, "test-trace", nil); success {
+			t.Errorf("request %d: expected failure with -net-loss 1.0", i)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("expected no requests to reach the server, got %d", n)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := client.Do(req); !errors.Is(err, errSyntheticLoss) {
+		t.Errorf("expected errSyntheticLoss, got %v", err)
+	}
+}
+
+func TestNetDelayAddsLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, timeout: time.Second, netDelay: 80 * time.Millisecond, netJitter: 40 * time.Millisecond}
+	client, err := newHTTPClient(cfg, &byteCounter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		success, latency := doRequestWithRetry(context.Background(), 1, i, cfg, client, "test-trace", nil)
+		if !success {
+			t.Fatalf("request %d: expected success", i)
+		}
+		if latency < 80*time.Millisecond || latency > 300*time.Millisecond {
+			t.Errorf("request %d: expected 80-120ms of added delay, got latency %v", i, latency)
+		}
+	}
+}
+
+func TestNetDelayHonorsCancel(t *testing.T) {
+	n := newNetConditions(http.DefaultTransport, time.Minute, 0, 0, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.invalid", nil)
+
+	start := time.Now()
+	if _, err := n.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the delay to end on cancel, took %v", elapsed)
+	}
+}
+
+func TestConfigValidateNetConditions(t *testing.T) {
+	for _, cfg := range []config{
+		{model: "closed", netLoss: 1.5},
+		{model: "closed", netLoss: -0.1},
+		{model: "closed", netDelay: -time.Second},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestNetConditionsDeterministicPerJob(t *testing.T) {
+	type outcome struct {
+		wait    time.Duration
+		dropped bool
+	}
+	draw := func(n *netConditions, job, attempt int) outcome {
+		req, _ := http.NewRequestWithContext(withNetAttempt(context.Background(), job, attempt), http.MethodGet, "http://example.invalid", nil)
+		wait, dropped := n.draw(req)
+		return outcome{wait, dropped}
+	}
+
+	// Jobs reaching the transport in a different order, as workers would,
+	// still get the same draws
+	forward := newNetConditions(nil, 0, time.Second, 0.5, 42)
+	want := map[int]outcome{}
+	for job := 1; job <= 20; job++ {
+		want[job] = draw(forward, job, 0)
+	}
+	backward := newNetConditions(nil, 0, time.Second, 0.5, 42)
+	for job := 20; job >= 1; job-- {
+		if got := draw(backward, job, 0); got != want[job] {
+			t.Errorf("job %d: expected %+v, got %+v", job, want[job], got)
+		}
+	}
+
+	// Retries draw afresh rather than repeating the first attempt's fate
+	same := 0
+	for job := 1; job <= 20; job++ {
+		if draw(forward, job, 1) == want[job] {
+			same++
+		}
+	}
+	if same == 20 {
+		t.Error("expected retries to get their own draws")
+	}
+}
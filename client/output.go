@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats accepted by -output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputCSV  = "csv"
+)
+
+// writeResults writes the run's results to w in format: the text summary
+// (also used when format is empty), a JSON summary, or a CSV row per request.
+func writeResults(w io.Writer, format string, results *resultsCollector) error {
+	switch format {
+	case "", outputText:
+		results.Summary().Print(w)
+		return nil
+	case outputJSON:
+		return writeResultsJSON(w, results.Summary())
+	case outputCSV:
+		return writeResultsCSV(w, results.Records())
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type latencyJSON struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+type summaryJSON struct {
+	Total       int     `json:"total"`
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"successRate"`
+	// Keyed by status code as a string; "0" counts requests with no response
+	StatusCounts map[string]int `json:"statusCounts"`
+	ErrorCounts  map[string]int `json:"errorCounts"`
+	LatencyMs    latencyJSON    `json:"latencyMs"`
+
+	Retries             int `json:"retries"`
+	SucceededAfterRetry int `json:"succeededAfterRetry"`
+	RetriesExhausted    int `json:"retriesExhausted"`
+}
+
+func newSummaryJSON(s resultsSummary) summaryJSON {
+	out := summaryJSON{
+		Total:        s.Total,
+		Successes:    s.Successes,
+		Failures:     s.Failures,
+		SuccessRate:  s.SuccessRate,
+		StatusCounts: make(map[string]int, len(s.StatusCounts)),
+		ErrorCounts:  s.ErrorCounts,
+		LatencyMs: latencyJSON{
+			Min: ms(s.Min),
+			Avg: ms(s.Avg),
+			Max: ms(s.Max),
+			P50: ms(s.P50),
+			P95: ms(s.P95),
+			P99: ms(s.P99),
+		},
+		Retries:             s.Retries,
+		SucceededAfterRetry: s.RetriedSuccesses,
+		RetriesExhausted:    s.RetriesExhausted,
+	}
+	for status, n := range s.StatusCounts {
+		out.StatusCounts[strconv.Itoa(status)] = n
+	}
+	return out
+}
+
+func writeResultsJSON(w io.Writer, s resultsSummary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newSummaryJSON(s))
+}
+
+// writeResultsCSV writes a header and then one row per request.
+func writeResultsCSV(w io.Writer, records []requestRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"worker", "job", "trace_id", "status", "latency_ms", "attempts"})
+	for _, r := range records {
+		cw.Write([]string{
+			strconv.Itoa(r.Worker),
+			strconv.Itoa(r.Job),
+			r.TraceID,
+			strconv.Itoa(r.Status),
+			strconv.FormatFloat(ms(r.Latency), 'f', 3, 64),
+			strconv.Itoa(r.Attempts),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleResults() *resultsCollector {
+	c := newResultsCollector()
+	c.keepRecords = true
+	c.Record(requestRecord{Worker: 0, Job: 1, TraceID: "t1", Success: true, Status: 200, Latency: 10 * time.Millisecond, Attempts: 1})
+	c.Record(requestRecord{Worker: 1, Job: 2, TraceID: "t2", Success: true, Status: 200, Latency: 30 * time.Millisecond, Attempts: 2})
+	c.Record(requestRecord{Worker: 0, Job: 3, TraceID: "t3", Success: false, Status: 503, Latency: 5 * time.Millisecond, Attempts: 4, RetriesExhausted: true})
+	c.Record(requestRecord{Worker: 1, Job: 4, TraceID: "t4", Success: false, Status: 0, Attempts: 4, RetriesExhausted: true})
+	return c
+}
+
+func TestWriteResultsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeResults(&buf, outputJSON, sampleResults()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got summaryJSON
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if got.Total != 4 || got.Successes != 2 || got.Failures != 2 || got.SuccessRate != 0.5 {
+		t.Errorf("unexpected totals: %+v", got)
+	}
+	if got.StatusCounts["200"] != 2 || got.StatusCounts["503"] != 1 || got.StatusCounts["0"] != 1 {
+		t.Errorf("unexpected status counts: %v", got.StatusCounts)
+	}
+	if got.Retries != 7 || got.SucceededAfterRetry != 1 || got.RetriesExhausted != 2 {
+		t.Errorf("unexpected retry stats: %+v", got)
+	}
+	if got.LatencyMs.Min != 10 || got.LatencyMs.Max != 30 || got.LatencyMs.P50 != 10 || got.LatencyMs.P99 != 30 {
+		t.Errorf("unexpected latencies: %+v", got.LatencyMs)
+	}
+}
+
+func TestWriteResultsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeResults(&buf, outputCSV, sampleResults()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("expected a header and 4 rows, got %d rows", len(rows))
+	}
+	if want := "worker,job,trace_id,status,latency_ms,attempts"; strings.Join(rows[0], ",") != want {
+		t.Errorf("expected header %q, got %q", want, strings.Join(rows[0], ","))
+	}
+	if want := "1,2,t2,200,30.000,2"; strings.Join(rows[2], ",") != want {
+		t.Errorf("expected row %q, got %q", want, strings.Join(rows[2], ","))
+	}
+	if want := "1,4,t4,0,0.000,4"; strings.Join(rows[4], ",") != want {
+		t.Errorf("expected row %q, got %q", want, strings.Join(rows[4], ","))
+	}
+}
+
+func TestWriteResultsText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeResults(&buf, outputText, sampleResults()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "requests total=4 success=2 failed=2") {
+		t.Errorf("unexpected text summary:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "status codes 200=2 503=1 no-response=1\n") {
+		t.Errorf("expected status codes in the text summary:\n%s", buf.String())
+	}
+}
+
+func TestWriteOutputToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	lr := &loadRun{cfg: config{output: outputJSON, outputFile: path}, results: sampleResults()}
+	if err := lr.writeOutput(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if !json.Valid(data) {
+		t.Errorf("expected JSON in %s, got %q", path, data)
+	}
+}
+
+func TestConfigValidateOutput(t *testing.T) {
+	if err := (config{model: "closed", output: "xml"}).validate(); err == nil {
+		t.Error("expected error for unknown -output")
+	}
+}
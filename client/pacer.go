@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// serverPacer reads the draft IETF RateLimit-Remaining and RateLimit-Reset
+// response headers and, once the server reports its quota is nearly spent,
+// holds new requests back until the quota resets instead of running into
+// 429s. It wraps the client's transport to see every response.
+type serverPacer struct {
+	next http.RoundTripper
+	// low is the remaining quota at or below which requests pause
+	low int
+
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+func newServerPacer(next http.RoundTripper, low int) *serverPacer {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &serverPacer{next: next, low: low}
+}
+
+func (p *serverPacer) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.next.RoundTrip(req)
+	if err == nil {
+		p.observe(resp.Header, time.Now())
+	}
+	return resp, err
+}
+
+func (p *serverPacer) observe(h http.Header, now time.Time) {
+	remaining, err := strconv.Atoi(h.Get("RateLimit-Remaining"))
+	if err != nil || remaining > p.low {
+		return
+	}
+	reset, err := strconv.Atoi(h.Get("RateLimit-Reset"))
+	if err != nil || reset <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if at := now.Add(time.Duration(reset) * time.Second); at.After(p.resumeAt) {
+		p.resumeAt = at
+	}
+}
+
+// Wait blocks until the server's quota has reset, if it was running low,
+// returning ctx's error if ctx is done first.
+func (p *serverPacer) Wait(ctx context.Context) error {
+	p.mu.Lock()
+	wait := time.Until(p.resumeAt)
+	p.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRespectRateLimitAvoids429s(t *testing.T) {
+	// A fixed one-second window allowing 3 requests
+	const limit = 3
+	var mu sync.Mutex
+	windowStart := time.Now()
+	used, rejected := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		if now.Sub(windowStart) >= time.Second {
+			windowStart, used = now, 0
+		}
+		used++
+		reset := int(math.Ceil(time.Second.Seconds() - now.Sub(windowStart).Seconds()))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(limit-used, 0)))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+		if used > limit {
+			rejected++
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config{target: server.URL, total: 7, concurrency: 1, maxRetries: 0, model: "closed", respectRateLimit: true}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	start := time.Now()
+	lr.execute(context.Background())
+	elapsed := time.Since(start)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rejected != 0 {
+		t.Errorf("expected the client to pace itself and avoid 429s, got %d", rejected)
+	}
+	if lr.users[0].failures != 0 || lr.users[0].requests != 7 {
+		t.Errorf("expected 7 successful requests, got %+v", lr.users[0])
+	}
+	// 7 requests at 3 per second need at least two pauses
+	if elapsed < 2*time.Second {
+		t.Errorf("expected the run to pause for the quota to reset, took %v", elapsed)
+	}
+}
+
+func TestServerPacerIgnoresHealthyQuota(t *testing.T) {
+	p := newServerPacer(nil, 0)
+	h := http.Header{}
+	h.Set("RateLimit-Remaining", "5")
+	h.Set("RateLimit-Reset", "10")
+	p.observe(h, time.Now())
+
+	start := time.Now()
+	p.Wait(context.Background())
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("expected no pause with quota remaining, waited %v", elapsed)
+	}
+}
+
+func TestServerPacerWaitCancelled(t *testing.T) {
+	p := newServerPacer(nil, 0)
+	h := http.Header{}
+	h.Set("RateLimit-Remaining", "0")
+	h.Set("RateLimit-Reset", "60")
+	p.observe(h, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Wait to return on cancel, took %v", elapsed)
+	}
+}
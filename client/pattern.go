@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// patternPoint scales the base request rate by multiplier at fraction (0-1)
+// of the way through the run.
+type patternPoint struct {
+	fraction   float64
+	multiplier float64
+}
+
+// loadPattern is a rate curve, such as a recorded day of traffic, compressed
+// into a single run. Points are sorted by fraction; the multiplier is
+// linearly interpolated between them and held flat before the first and
+// after the last.
+type loadPattern []patternPoint
+
+// readPatternFile loads a pattern from path.
+func readPatternFile(path string) (loadPattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePattern(f)
+}
+
+// parsePattern reads one "fraction,multiplier" point per line. Blank lines
+// and lines starting with # are ignored. Fractions must be increasing and
+// within [0, 1]; multipliers must be positive.
+func parsePattern(r io.Reader) (loadPattern, error) {
+	var pattern loadPattern
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected fraction,multiplier, got %q", line, text)
+		}
+		fraction, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil || fraction < 0 || fraction > 1 {
+			return nil, fmt.Errorf("line %d: invalid fraction %q: must be between 0 and 1", line, fields[0])
+		}
+		multiplier, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || multiplier <= 0 {
+			return nil, fmt.Errorf("line %d: invalid multiplier %q: must be positive", line, fields[1])
+		}
+		if n := len(pattern); n > 0 && fraction <= pattern[n-1].fraction {
+			return nil, fmt.Errorf("line %d: fraction %v is not after %v", line, fraction, pattern[n-1].fraction)
+		}
+		pattern = append(pattern, patternPoint{fraction: fraction, multiplier: multiplier})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("pattern has no points")
+	}
+	return pattern, nil
+}
+
+// multiplier returns the rate multiplier at fraction of the run.
+func (p loadPattern) multiplier(fraction float64) float64 {
+	if fraction <= p[0].fraction {
+		return p[0].multiplier
+	}
+	for i := 1; i < len(p); i++ {
+		if fraction <= p[i].fraction {
+			a, b := p[i-1], p[i]
+			return a.multiplier + (b.multiplier-a.multiplier)*(fraction-a.fraction)/(b.fraction-a.fraction)
+		}
+	}
+	return p[len(p)-1].multiplier
+}
+
+// mean is the average multiplier over the whole run.
+func (p loadPattern) mean() float64 {
+	first, last := p[0], p[len(p)-1]
+	area := first.fraction*first.multiplier + (1-last.fraction)*last.multiplier
+	for i := 1; i < len(p); i++ {
+		a, b := p[i-1], p[i]
+		area += (b.fraction - a.fraction) * (a.multiplier + b.multiplier) / 2
+	}
+	return area
+}
+
+// duration is how long total requests take when the base rate rps follows
+// the pattern, which is the span the pattern is compressed into.
+func (p loadPattern) duration(total int, rps float64) time.Duration {
+	return time.Duration(float64(total) / (rps * p.mean()) * float64(time.Second))
+}
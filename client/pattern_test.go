@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePattern(t *testing.T) {
+	pattern, err := parsePattern(strings.NewReader("# night to noon\n0, 0.5\n\n0.5,2\n1,1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		fraction, want float64
+	}{
+		{0, 0.5},
+		{0.25, 1.25},
+		{0.5, 2},
+		{0.75, 1.5},
+		{1, 1},
+		{1.5, 1},
+	}
+	for _, tt := range tests {
+		if got := pattern.multiplier(tt.fraction); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("multiplier(%v): expected %v, got %v", tt.fraction, tt.want, got)
+		}
+	}
+	// (0.5+2)/2*0.5 + (2+1)/2*0.5
+	if got := pattern.mean(); math.Abs(got-1.375) > 1e-9 {
+		t.Errorf("expected mean 1.375, got %v", got)
+	}
+}
+
+func TestParsePatternRejectsBadInput(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"0.5",
+		"2,1",
+		"0,0",
+		"0,-1",
+		"0.5,1\n0.2,1",
+		"a,1",
+	} {
+		if _, err := parsePattern(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestPatternRateLimiterFollowsPattern(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// expected share of requests sent in the first half of the run
+		minFirst, maxFirst float64
+	}{
+		// The rate ramps 1x -> 3x, so 3/8 of requests land in the first half
+		{"rising", "0,1\n1,3", 0.30, 0.45},
+		{"falling", "0,3\n1,1", 0.55, 0.70},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := parsePattern(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			const total, rps = 120, 100 // 600ms at a mean of 2x
+			l := newPatternRateLimiter(rps, pattern, pattern.duration(total, rps))
+
+			start := time.Now()
+			sent := make([]time.Duration, total)
+			for i := range sent {
+				l.Wait()
+				sent[i] = time.Since(start)
+			}
+			elapsed := sent[total-1]
+			if elapsed < 500*time.Millisecond || elapsed > 800*time.Millisecond {
+				t.Errorf("expected the run to take ~600ms, got %v", elapsed)
+			}
+
+			var first int
+			for _, at := range sent {
+				if at < elapsed/2 {
+					first++
+				}
+			}
+			share := float64(first) / total
+			if share < tt.minFirst || share > tt.maxFirst {
+				t.Errorf("expected %.2f-%.2f of requests in the first half, got %.2f", tt.minFirst, tt.maxFirst, share)
+			}
+		})
+	}
+}
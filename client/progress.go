@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// reportProgress writes the cumulative completed and failed counts and the
+// success rate so far to w every interval, until ctx is done. It closes
+// done on return so the caller can wait for the last line before printing
+// the summary.
+func reportProgress(ctx context.Context, w io.Writer, interval time.Duration, results *resultsCollector, done chan<- struct{}) {
+	defer close(done)
+	started := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			successes, failures := results.Counts()
+			completed := successes + failures
+			rate := 0.0
+			if completed > 0 {
+				rate = float64(successes) / float64(completed) * 100
+			}
+			fmt.Fprintf(w, "progress elapsed=%s completed=%d failed=%d success rate=%.1f%%\n",
+				now.Sub(started).Round(time.Second), completed, failures, rate)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportProgress(t *testing.T) {
+	results := newResultsCollector()
+	results.Add(true, time.Millisecond)
+	results.Add(true, time.Millisecond)
+	results.Add(true, time.Millisecond)
+	results.Add(false, 0)
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go reportProgress(ctx, &out, 10*time.Millisecond, results, done)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reportProgress didn't return after cancel")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		t.Fatal("expected at least one progress line")
+	}
+	if want := "completed=4 failed=1 success rate=75.0%"; !strings.Contains(lines[0], want) {
+		t.Errorf("expected %q in %q", want, lines[0])
+	}
+}
+
+func TestValidateProgress(t *testing.T) {
+	if err := (config{model: "closed", progress: -time.Second}).validate(); err == nil {
+		t.Error("expected error for negative -progress")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces requests evenly at a fixed rate across all callers. It
+// is a token bucket with a burst of one: each Wait reserves the next slot.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	// scale, when set, multiplies the rate by its result for the time
+	// since the first slot
+	scale func(elapsed time.Duration) float64
+	start time.Time
+}
+
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// newPatternRateLimiter returns a limiter whose rate follows pattern around
+// the base rps, compressed into runTime.
+func newPatternRateLimiter(rps float64, pattern loadPattern, runTime time.Duration) *rateLimiter {
+	l := newRateLimiter(rps)
+	l.scale = func(elapsed time.Duration) float64 {
+		return pattern.multiplier(float64(elapsed) / float64(runTime))
+	}
+	return l
+}
+
+// Wait blocks until the caller's reserved slot arrives.
+func (l *rateLimiter) Wait() {
+	time.Sleep(l.reserve())
+}
+
+// WaitContext is Wait that gives up, returning ctx's error, if ctx is done
+// before the slot arrives. The slot stays spent either way.
+func (l *rateLimiter) WaitContext(ctx context.Context) error {
+	timer := time.NewTimer(l.reserve())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes the next slot, returning how long until it arrives.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	now := time.Now()
+	if l.start.IsZero() {
+		l.start = now
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	interval := l.interval
+	if l.scale != nil {
+		interval = time.Duration(float64(interval) / l.scale(l.next.Sub(l.start)))
+	}
+	l.next = l.next.Add(interval)
+	l.mu.Unlock()
+	return wait
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSpacing(t *testing.T) {
+	l := newRateLimiter(100) // one slot every 10ms
+
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		l.Wait()
+	}
+	elapsed := time.Since(start)
+
+	// The first slot is immediate, so 11 waits span 10 intervals
+	if elapsed < 90*time.Millisecond || elapsed > 200*time.Millisecond {
+		t.Errorf("expected ~100ms for 11 waits at 100 rps, got %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitContext(t *testing.T) {
+	l := newRateLimiter(1) // one slot a second
+	if err := l.WaitContext(context.Background()); err != nil {
+		t.Fatalf("expected the first slot immediately, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the wait to end with ctx, took %v", elapsed)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+const remoteWriteJob = "prr-playground-client"
+
+// remoteWriteGrace is how many seconds a bucket is held back after it ends
+// before being pushed, so requests that finish just after a tick still land
+// in their second instead of arriving after it was sent.
+const remoteWriteGrace = 1
+
+// promLabel and promSeries mirror the prompb Label and TimeSeries messages.
+type promLabel struct {
+	name, value string
+}
+
+type promSample struct {
+	value       float64
+	timestampMs int64
+}
+
+type promSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// remoteWriter periodically pushes the per-second aggregates of a timeSeries
+// to a Prometheus remote-write endpoint.
+type remoteWriter struct {
+	url    string
+	target string
+	client *http.Client
+	series *timeSeries
+	next   int64 // first second not yet pushed
+}
+
+func newRemoteWriter(url, target string, series *timeSeries, timeout time.Duration) *remoteWriter {
+	return &remoteWriter{
+		url:    url,
+		target: target,
+		client: &http.Client{Timeout: timeout},
+		series: series,
+	}
+}
+
+// run pushes every second that ended at least remoteWriteGrace seconds ago
+// until stop is closed, then flushes the remaining buckets (including the
+// current, partial second) and closes done.
+func (rw *remoteWriter) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := rw.tick(now); err != nil {
+				log.Printf("remote-write push failed: %v", err)
+			}
+		case <-stop:
+			if err := rw.flush(time.Now().Unix() + 1); err != nil {
+				log.Printf("remote-write final push failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// tick flushes the seconds that are complete at now, allowing for the grace
+// window.
+func (rw *remoteWriter) tick(now time.Time) error {
+	return rw.flush(now.Unix() - remoteWriteGrace)
+}
+
+// flush sends all buckets for seconds before upTo that haven't been pushed yet.
+// Buckets are only marked as sent once the receiver accepts them.
+func (rw *remoteWriter) flush(upTo int64) error {
+	buckets := rw.series.Range(rw.next, upTo)
+	if len(buckets) == 0 {
+		return nil
+	}
+	if err := rw.push(buildSeries(buckets, rw.target)); err != nil {
+		return err
+	}
+	rw.next = buckets[len(buckets)-1].second + 1
+	return nil
+}
+
+func (rw *remoteWriter) push(series []promSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	req, err := http.NewRequest(http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("receiver returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// buildSeries converts per-second buckets into one series per metric, each
+// with a sample per second stamped at the start of that second.
+func buildSeries(buckets []secondStats, target string) []promSeries {
+	metrics := []struct {
+		name  string
+		value func(secondStats) float64
+	}{
+		{"client_requests_per_second", func(s secondStats) float64 { return float64(s.requests) }},
+		{"client_failures_per_second", func(s secondStats) float64 { return float64(s.failures) }},
+		{"client_latency_avg_ms", func(s secondStats) float64 {
+			return float64(s.avgLatency()) / float64(time.Millisecond)
+		}},
+	}
+
+	series := make([]promSeries, 0, len(metrics))
+	for _, m := range metrics {
+		s := promSeries{labels: []promLabel{
+			{"__name__", m.name},
+			{"job", remoteWriteJob},
+			{"target", target},
+		}}
+		// Remote-write requires labels sorted by name
+		sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+		for _, b := range buckets {
+			s.samples = append(s.samples, promSample{value: m.value(b), timestampMs: b.second * 1000})
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// encodeWriteRequest hand-encodes a prompb.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []promSeries) []byte {
+	var out []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = appendBytesField(label, 1, []byte(l.name))
+			label = appendBytesField(label, 2, []byte(l.value))
+			ts = appendBytesField(ts, 1, label)
+		}
+		for _, smp := range s.samples {
+			var sample []byte
+			sample = binary.AppendUvarint(sample, 1<<3|1) // fixed64
+			sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(smp.value))
+			sample = binary.AppendUvarint(sample, 2<<3|0) // varint
+			sample = binary.AppendUvarint(sample, uint64(smp.timestampMs))
+			ts = appendBytesField(ts, 2, sample)
+		}
+		out = appendBytesField(out, 1, ts)
+	}
+	return out
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2) // length-delimited
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// decodeWriteRequest is a minimal prompb.WriteRequest decoder for tests.
+func decodeWriteRequest(t *testing.T, b []byte) []promSeries {
+	t.Helper()
+	var series []promSeries
+	for _, ts := range decodeFields(t, b)[1] {
+		fields := decodeFields(t, ts)
+		var s promSeries
+		for _, l := range fields[1] {
+			lf := decodeFields(t, l)
+			s.labels = append(s.labels, promLabel{string(lf[1][0]), string(lf[2][0])})
+		}
+		for _, smp := range fields[2] {
+			sf := decodeFields(t, smp)
+			s.samples = append(s.samples, promSample{
+				value:       math.Float64frombits(binary.LittleEndian.Uint64(sf[1][0])),
+				timestampMs: int64(binary.LittleEndian.Uint64(sf[2][0])),
+			})
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// decodeFields splits a protobuf message into raw field values by number.
+// Varints are widened to 8 little-endian bytes so callers can treat all
+// scalar fields alike.
+func decodeFields(t *testing.T, b []byte) map[int][][]byte {
+	t.Helper()
+	fields := map[int][][]byte{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad tag")
+		}
+		b = b[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("bad varint")
+			}
+			fields[field] = append(fields[field], binary.LittleEndian.AppendUint64(nil, v))
+			b = b[n:]
+		case 1:
+			fields[field] = append(fields[field], b[:8])
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("bad length")
+			}
+			b = b[n:]
+			fields[field] = append(fields[field], b[:l])
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return fields
+}
+
+func TestRemoteWriterPushesSeries(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]promSeries
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("expected snappy encoding, got %q", r.Header.Get("Content-Encoding"))
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("failed to decode snappy body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, decodeWriteRequest(t, raw))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	series := newTimeSeries()
+	base := time.Unix(1700000000, 0)
+	series.Observe(base, true, 10*time.Millisecond)
+	series.Observe(base.Add(100*time.Millisecond), false, 30*time.Millisecond)
+	series.Observe(base.Add(time.Second), true, 5*time.Millisecond)
+
+	rw := newRemoteWriter(receiver.URL, "http://server:8080/hello", series, time.Second)
+	if err := rw.flush(base.Unix() + 2); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	// Already pushed seconds must not be resent
+	if err := rw.flush(base.Unix() + 2); err != nil {
+		t.Fatalf("second flush failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch, got %d", len(batches))
+	}
+
+	got := map[string][]promSample{}
+	for _, s := range batches[0] {
+		var name string
+		for i, l := range s.labels {
+			if i > 0 && s.labels[i-1].name >= l.name {
+				t.Errorf("labels not sorted: %v", s.labels)
+			}
+			if l.name == "__name__" {
+				name = l.value
+			}
+		}
+		got[name] = s.samples
+	}
+
+	want := map[string][]float64{
+		"client_requests_per_second": {2, 1},
+		"client_failures_per_second": {1, 0},
+		"client_latency_avg_ms":      {20, 5},
+	}
+	for name, values := range want {
+		samples := got[name]
+		if len(samples) != len(values) {
+			t.Fatalf("%s: expected %d samples, got %d", name, len(values), len(samples))
+		}
+		for i, v := range values {
+			if samples[i].value != v {
+				t.Errorf("%s[%d]: expected %v, got %v", name, i, v, samples[i].value)
+			}
+			if wantTs := (base.Unix() + int64(i)) * 1000; samples[i].timestampMs != wantTs {
+				t.Errorf("%s[%d]: expected timestamp %d, got %d", name, i, wantTs, samples[i].timestampMs)
+			}
+		}
+	}
+}
+
+func TestRemoteWriterKeepsBucketsOnFailure(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	series := newTimeSeries()
+	series.Observe(time.Unix(100, 0), true, time.Millisecond)
+
+	rw := newRemoteWriter(receiver.URL, "t", series, time.Second)
+	if err := rw.flush(101); err == nil {
+		t.Fatal("expected error from failing receiver")
+	}
+	if rw.next != 0 {
+		t.Errorf("expected cursor to stay at 0 after failure, got %d", rw.next)
+	}
+
+	fail.Store(false)
+	if err := rw.flush(101); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if rw.next != 101 {
+		t.Errorf("expected cursor to advance to 101, got %d", rw.next)
+	}
+}
+
+func TestRemoteWriterKeepsLateObservations(t *testing.T) {
+	var mu sync.Mutex
+	var requests []float64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("failed to decode snappy body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range decodeWriteRequest(t, raw) {
+			for _, l := range s.labels {
+				if l.name == "__name__" && l.value == "client_requests_per_second" {
+					for _, sample := range s.samples {
+						requests = append(requests, sample.value)
+					}
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	series := newTimeSeries()
+	base := time.Unix(1700000000, 0)
+	rw := newRemoteWriter(receiver.URL, "t", series, time.Second)
+
+	series.Observe(base.Add(900*time.Millisecond), true, time.Millisecond)
+	// The tick just after the second ends must leave it open
+	if err := rw.tick(base.Add(time.Second)); err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+	// A request finishing right after the tick is stamped with that second
+	series.Observe(base.Add(950*time.Millisecond), true, time.Millisecond)
+	if err := rw.tick(base.Add(2 * time.Second)); err != nil {
+		t.Fatalf("tick failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || requests[0] != 2 {
+		t.Errorf("expected one sample counting both requests, got %v", requests)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// requestRecord is the outcome of one job, after any retries.
+type requestRecord struct {
+	Worker  int
+	Job     int
+	TraceID string
+	Target  string
+	Success bool
+	// Status is the last HTTP status received, or 0 if there was no response
+	Status   int
+	Latency  time.Duration
+	Attempts int
+	// DeadlineExceeded is set when -total-timeout ran out before the
+	// request could succeed
+	DeadlineExceeded bool
+	// RetriesExhausted is set when every allowed attempt failed
+	RetriesExhausted bool
+	// ErrorClass buckets a failed request (see classifyFailure); empty on
+	// success
+	ErrorClass string
+	// Timings breaks down the final attempt, with -trace-timing only
+	Timings *phaseTimings
+}
+
+// resultsCollector records the outcome of every request in a run so a
+// summary can be printed at the end. It is safe for concurrent use by
+// workers.
+type resultsCollector struct {
+	mu        sync.Mutex
+	successes int
+	failures  int
+	// latencies of successful requests; failures are excluded because
+	// requests that exhaust their retries report no latency
+	latencies []time.Duration
+
+	// Filled in by Record only
+	statusCounts map[int]int
+	errorCounts  map[string]int
+	targets      map[string]*targetSummary
+	workers      map[int]*workerStats
+	timings      []phaseTimings
+	records      []requestRecord
+	retries      int
+	// keepRecords enables records, which grows with every request and is
+	// only needed for CSV output
+	keepRecords bool
+	// requests that succeeded only after a retry, and that ran out of them
+	retriedSuccesses int
+	retriesExhausted int
+}
+
+func newResultsCollector() *resultsCollector {
+	return &resultsCollector{statusCounts: map[int]int{}, errorCounts: map[string]int{}, targets: map[string]*targetSummary{}, workers: map[int]*workerStats{}}
+}
+
+func (c *resultsCollector) Add(success bool, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(success, latency)
+}
+
+// Record adds a request like Add, also keeping its status and, if
+// keepRecords is set, the record itself for per-request output.
+func (c *resultsCollector) Record(rec requestRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(rec.Success, rec.Latency)
+	c.statusCounts[rec.Status]++
+	if rec.ErrorClass != "" {
+		c.errorCounts[rec.ErrorClass]++
+	}
+	if rec.Timings != nil {
+		c.timings = append(c.timings, *rec.Timings)
+	}
+	if rec.Target != "" {
+		t := c.targets[rec.Target]
+		if t == nil {
+			t = &targetSummary{}
+			c.targets[rec.Target] = t
+		}
+		t.add(rec)
+	}
+	w := c.workers[rec.Worker]
+	if w == nil {
+		w = &workerStats{}
+		c.workers[rec.Worker] = w
+	}
+	w.add(rec)
+	if c.keepRecords {
+		c.records = append(c.records, rec)
+	}
+	if rec.Attempts > 1 {
+		c.retries += rec.Attempts - 1
+		if rec.Success {
+			c.retriedSuccesses++
+		}
+	}
+	if rec.RetriesExhausted {
+		c.retriesExhausted++
+	}
+}
+
+// Counts returns how many requests have succeeded and failed so far.
+func (c *resultsCollector) Counts() (successes, failures int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.successes, c.failures
+}
+
+// Records returns the recorded requests in completion order.
+func (c *resultsCollector) Records() []requestRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]requestRecord(nil), c.records...)
+}
+
+func (c *resultsCollector) add(success bool, latency time.Duration) {
+	if !success {
+		c.failures++
+		return
+	}
+	c.successes++
+	c.latencies = append(c.latencies, latency)
+}
+
+// resultsSummary is the rollup of a run. Latency fields are zero when no
+// request succeeded.
+type resultsSummary struct {
+	Total       int
+	Successes   int
+	Failures    int
+	SuccessRate float64 // 0-1
+
+	Min, Avg, Max time.Duration
+	P50, P95, P99 time.Duration
+
+	// StatusCounts counts recorded requests by final status, 0 meaning no
+	// response
+	StatusCounts map[int]int
+	// ErrorCounts counts recorded failures by class
+	ErrorCounts map[string]int
+	// Targets breaks recorded requests down by target URL
+	Targets map[string]targetSummary
+	// Workers breaks recorded requests down by worker ID
+	Workers map[int]workerSummary
+	// Phases summarizes -trace-timing breakdowns, in phaseNames order;
+	// empty without them
+	Phases []phaseSummary
+
+	// Retries is the number of attempts beyond each request's first;
+	// RetriedSuccesses and RetriesExhausted count the requests those
+	// retries saved and the ones that failed anyway after using them all
+	Retries          int
+	RetriedSuccesses int
+	RetriesExhausted int
+}
+
+func (c *resultsCollector) Summary() resultsSummary {
+	c.mu.Lock()
+	latencies := append([]time.Duration(nil), c.latencies...)
+	s := resultsSummary{
+		Total:     c.successes + c.failures,
+		Successes: c.successes,
+		Failures:  c.failures,
+
+		Retries:          c.retries,
+		RetriedSuccesses: c.retriedSuccesses,
+		RetriesExhausted: c.retriesExhausted,
+	}
+	s.StatusCounts = make(map[int]int, len(c.statusCounts))
+	for status, n := range c.statusCounts {
+		s.StatusCounts[status] = n
+	}
+	s.ErrorCounts = make(map[string]int, len(c.errorCounts))
+	for class, n := range c.errorCounts {
+		s.ErrorCounts[class] = n
+	}
+	s.Targets = make(map[string]targetSummary, len(c.targets))
+	for url, t := range c.targets {
+		s.Targets[url] = *t
+	}
+	s.Workers = make(map[int]workerSummary, len(c.workers))
+	for id, w := range c.workers {
+		s.Workers[id] = w.summary()
+	}
+	s.Phases = summarizePhases(c.timings)
+	c.mu.Unlock()
+
+	if s.Total > 0 {
+		s.SuccessRate = float64(s.Successes) / float64(s.Total)
+	}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	s.Min, s.Max = latencies[0], latencies[len(latencies)-1]
+	s.Avg = sum / time.Duration(len(latencies))
+	s.P50 = percentile(latencies, 50)
+	s.P95 = percentile(latencies, 95)
+	s.P99 = percentile(latencies, 99)
+	return s
+}
+
+// targetSummary is the rollup of the requests sent to one target.
+type targetSummary struct {
+	Total     int
+	Successes int
+	Failures  int
+	// latencySum is over successful requests, for Avg
+	latencySum time.Duration
+}
+
+func (t *targetSummary) add(rec requestRecord) {
+	t.Total++
+	if !rec.Success {
+		t.Failures++
+		return
+	}
+	t.Successes++
+	t.latencySum += rec.Latency
+}
+
+// Avg is the mean latency of the target's successful requests.
+func (t targetSummary) Avg() time.Duration {
+	if t.Successes == 0 {
+		return 0
+	}
+	return t.latencySum / time.Duration(t.Successes)
+}
+
+// workerStats accumulates the requests completed by one worker.
+type workerStats struct {
+	successes int
+	failures  int
+	// latencies of successful requests, as for resultsCollector
+	latencies []time.Duration
+}
+
+func (w *workerStats) add(rec requestRecord) {
+	if !rec.Success {
+		w.failures++
+		return
+	}
+	w.successes++
+	w.latencies = append(w.latencies, rec.Latency)
+}
+
+// workerSummary is the rollup of the requests one worker completed, for
+// spotting a stuck or starved worker. Latency fields are zero when none of
+// its requests succeeded.
+type workerSummary struct {
+	Total     int
+	Successes int
+	Failures  int
+
+	Avg, P50, P95, Max time.Duration
+}
+
+func (w *workerStats) summary() workerSummary {
+	s := workerSummary{Total: w.successes + w.failures, Successes: w.successes, Failures: w.failures}
+	if len(w.latencies) == 0 {
+		return s
+	}
+	latencies := append([]time.Duration(nil), w.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	s.Avg = sum / time.Duration(len(latencies))
+	s.P50 = percentile(latencies, 50)
+	s.P95 = percentile(latencies, 95)
+	s.Max = latencies[len(latencies)-1]
+	return s
+}
+
+// phaseSummary holds the percentiles of one -trace-timing phase.
+type phaseSummary struct {
+	Name          string
+	P50, P95, P99 time.Duration
+}
+
+func summarizePhases(timings []phaseTimings) []phaseSummary {
+	if len(timings) == 0 {
+		return nil
+	}
+	phases := make([]phaseSummary, len(phaseNames))
+	values := make([]time.Duration, len(timings))
+	for i, name := range phaseNames {
+		for j, t := range timings {
+			values[j] = t.durations()[i]
+		}
+		sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+		phases[i] = phaseSummary{
+			Name: name,
+			P50:  percentile(values, 50),
+			P95:  percentile(values, 95),
+			P99:  percentile(values, 99),
+		}
+	}
+	return phases
+}
+
+// percentile returns the nearest-rank pth percentile of sorted, which must
+// not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
+
+func (s resultsSummary) Print(w io.Writer) {
+	fmt.Fprintf(w, "requests total=%d success=%d failed=%d success rate=%.1f%%\n",
+		s.Total, s.Successes, s.Failures, s.SuccessRate*100)
+	fmt.Fprintf(w, "latency min=%s avg=%s max=%s p50=%s p95=%s p99=%s\n", s.Min, s.Avg, s.Max, s.P50, s.P95, s.P99)
+	if len(s.StatusCounts) > 0 {
+		statuses := make([]int, 0, len(s.StatusCounts))
+		for status := range s.StatusCounts {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		fmt.Fprint(w, "status codes")
+		for _, status := range statuses {
+			if status == 0 {
+				continue
+			}
+			fmt.Fprintf(w, " %d=%d", status, s.StatusCounts[status])
+		}
+		if n := s.StatusCounts[0]; n > 0 {
+			fmt.Fprintf(w, " no-response=%d", n)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "retries total=%d succeeded after retry=%d exhausted=%d\n", s.Retries, s.RetriedSuccesses, s.RetriesExhausted)
+	for _, p := range s.Phases {
+		fmt.Fprintf(w, "phase %s p50=%s p95=%s p99=%s\n", p.Name, p.P50, p.P95, p.P99)
+	}
+	// A single target's numbers would repeat the totals
+	if len(s.Targets) > 1 {
+		urls := make([]string, 0, len(s.Targets))
+		for url := range s.Targets {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+		for _, url := range urls {
+			t := s.Targets[url]
+			fmt.Fprintf(w, "target %s requests=%d failed=%d avg latency=%s\n", url, t.Total, t.Failures, t.Avg())
+		}
+	}
+	if len(s.ErrorCounts) > 0 {
+		classes := make([]string, 0, len(s.ErrorCounts))
+		for class := range s.ErrorCounts {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		fmt.Fprint(w, "errors")
+		for _, class := range classes {
+			fmt.Fprintf(w, " %s=%d", class, s.ErrorCounts[class])
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// PrintWorkers writes one line per worker, in ID order, for -per-worker.
+func (s resultsSummary) PrintWorkers(w io.Writer) {
+	ids := make([]int, 0, len(s.Workers))
+	for id := range s.Workers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		ws := s.Workers[id]
+		fmt.Fprintf(w, "worker %d requests=%d success=%d failed=%d latency avg=%s p50=%s p95=%s max=%s\n",
+			id, ws.Total, ws.Successes, ws.Failures, ws.Avg, ws.P50, ws.P95, ws.Max)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResultsCollectorSummary(t *testing.T) {
+	c := newResultsCollector()
+	// 1ms..100ms, fed concurrently and out of order
+	var wg sync.WaitGroup
+	for i := 100; i >= 1; i-- {
+		wg.Add(1)
+		go func(ms int) {
+			defer wg.Done()
+			c.Add(true, time.Duration(ms)*time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 25; i++ {
+		c.Add(false, 0)
+	}
+
+	s := c.Summary()
+	if s.Total != 125 || s.Successes != 100 || s.Failures != 25 {
+		t.Errorf("expected 125/100/25, got total=%d success=%d failed=%d", s.Total, s.Successes, s.Failures)
+	}
+	if s.SuccessRate != 0.8 {
+		t.Errorf("expected success rate 0.8, got %v", s.SuccessRate)
+	}
+	want := map[string][2]time.Duration{
+		"min": {s.Min, time.Millisecond},
+		"max": {s.Max, 100 * time.Millisecond},
+		"avg": {s.Avg, 50500 * time.Microsecond},
+		"p50": {s.P50, 50 * time.Millisecond},
+		"p95": {s.P95, 95 * time.Millisecond},
+		"p99": {s.P99, 99 * time.Millisecond},
+	}
+	for name, v := range want {
+		if v[0] != v[1] {
+			t.Errorf("%s: expected %v, got %v", name, v[1], v[0])
+		}
+	}
+
+	var buf bytes.Buffer
+	s.Print(&buf)
+	if !strings.Contains(buf.String(), "success rate=80.0%") || !strings.Contains(buf.String(), "p99=99ms") {
+		t.Errorf("unexpected summary output:\n%s", buf.String())
+	}
+}
+
+func TestPercentileSmallSamples(t *testing.T) {
+	sorted := []time.Duration{10, 20, 30}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 10},
+		{33, 10},
+		{50, 20},
+		{99, 30},
+		{100, 30},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("p%v: expected %v, got %v", tt.p, tt.want, got)
+		}
+	}
+}
+
+func TestResultsCollectorEmpty(t *testing.T) {
+	s := newResultsCollector().Summary()
+	if s.Total != 0 || s.SuccessRate != 0 || s.P99 != 0 {
+		t.Errorf("expected an empty summary, got %+v", s)
+	}
+}
+
+func TestResultsCollectorPerWorker(t *testing.T) {
+	c := newResultsCollector()
+	// Worker 0 is healthy, worker 1 slow and worker 2 failing throughout
+	for i := 1; i <= 20; i++ {
+		c.Record(requestRecord{Worker: 0, Success: true, Latency: time.Duration(i) * time.Millisecond})
+	}
+	for i := 1; i <= 4; i++ {
+		c.Record(requestRecord{Worker: 1, Success: true, Latency: time.Duration(i) * time.Second})
+	}
+	for i := 0; i < 5; i++ {
+		c.Record(requestRecord{Worker: 2, Status: 500})
+	}
+
+	s := c.Summary()
+	want := map[int]workerSummary{
+		0: {Total: 20, Successes: 20, Avg: 10500 * time.Microsecond, P50: 10 * time.Millisecond, P95: 19 * time.Millisecond, Max: 20 * time.Millisecond},
+		1: {Total: 4, Successes: 4, Avg: 2500 * time.Millisecond, P50: 2 * time.Second, P95: 4 * time.Second, Max: 4 * time.Second},
+		2: {Total: 5, Failures: 5},
+	}
+	if len(s.Workers) != len(want) {
+		t.Fatalf("expected %d workers, got %+v", len(want), s.Workers)
+	}
+	for id, w := range want {
+		if got := s.Workers[id]; got != w {
+			t.Errorf("worker %d: expected %+v, got %+v", id, w, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	s.PrintWorkers(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	wantLines := []string{
+		"worker 0 requests=20 success=20 failed=0 latency avg=10.5ms p50=10ms p95=19ms max=20ms",
+		"worker 1 requests=4 success=4 failed=0 latency avg=2.5s p50=2s p95=4s max=4s",
+		"worker 2 requests=5 success=0 failed=5 latency avg=0s p50=0s p95=0s max=0s",
+	}
+	if strings.Join(lines, "\n") != strings.Join(wantLines, "\n") {
+		t.Errorf("unexpected per-worker output:\n%s", buf.String())
+	}
+}
+
+func TestResultsCollectorKeepsRecordsOnlyWhenAsked(t *testing.T) {
+	c := newResultsCollector()
+	c.Record(requestRecord{Success: true, Status: 200, Latency: time.Millisecond})
+	if got := c.Records(); len(got) != 0 {
+		t.Errorf("expected no records by default, got %d", len(got))
+	}
+	if s := c.Summary(); s.Total != 1 || s.StatusCounts[200] != 1 {
+		t.Errorf("expected the request in the summary, got %+v", s)
+	}
+
+	c = newResultsCollector()
+	c.keepRecords = true
+	c.Record(requestRecord{Success: true, Status: 200, Latency: time.Millisecond})
+	if got := c.Records(); len(got) != 1 {
+		t.Errorf("expected 1 record, got %d", len(got))
+	}
+}
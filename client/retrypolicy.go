@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultRetryOn is the -retry-on default: rate limiting and server errors.
+const defaultRetryOn = "429,500-599"
+
+// statusRange is an inclusive range of HTTP status codes.
+type statusRange struct{ lo, hi int }
+
+// retryPolicy is the set of response status codes worth retrying. A nil
+// policy is the default, defaultRetryOn.
+type retryPolicy []statusRange
+
+var defaultRetryPolicy = mustParseRetryPolicy(defaultRetryOn)
+
+// parseRetryPolicy parses a comma-separated list of status codes and
+// lo-hi ranges such as "408,429,500-599". An empty list retries no status.
+func parseRetryPolicy(s string) (retryPolicy, error) {
+	policy := retryPolicy{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		loStr, hiStr, isRange := strings.Cut(field, "-")
+		if !isRange {
+			hiStr = loStr
+		}
+		lo, errLo := strconv.Atoi(strings.TrimSpace(loStr))
+		hi, errHi := strconv.Atoi(strings.TrimSpace(hiStr))
+		if errLo != nil || errHi != nil || lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("invalid status %q: want a code or lo-hi range within 100-599", field)
+		}
+		policy = append(policy, statusRange{lo, hi})
+	}
+	return policy, nil
+}
+
+func mustParseRetryPolicy(s string) retryPolicy {
+	policy, err := parseRetryPolicy(s)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// retries reports whether a response with statusCode should be retried.
+func (p retryPolicy) retries(statusCode int) bool {
+	if p == nil {
+		p = defaultRetryPolicy
+	}
+	for _, r := range p {
+		if statusCode >= r.lo && statusCode <= r.hi {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := parseRetryPolicy(" 408 , 500-503,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (retryPolicy{{408, 408}, {500, 503}}); !reflect.DeepEqual(policy, want) {
+		t.Errorf("expected %v, got %v", want, policy)
+	}
+	if !reflect.DeepEqual(defaultRetryPolicy, retryPolicy{{429, 429}, {500, 599}}) {
+		t.Errorf("unexpected default policy %v", defaultRetryPolicy)
+	}
+}
+
+func TestParseRetryPolicyErrors(t *testing.T) {
+	for _, s := range []string{"abc", "500-", "-500", "599-500", "99", "600", "500-700", "5xx"} {
+		if _, err := parseRetryPolicy(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
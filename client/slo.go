@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sloThresholds are the service level objectives a run must meet for the
+// client to exit 0, for gating CI on a load test. A zero p99 is not checked;
+// the error rate is checked only when hasErrorRate is set, since 0 is a
+// valid objective meaning no failures at all.
+type sloThresholds struct {
+	p99          time.Duration
+	errorRate    float64 // 0-1
+	hasErrorRate bool
+}
+
+// parseErrorRate parses an -slo-error-rate, either a percentage such as
+// "1%" or a fraction such as "0.01".
+func parseErrorRate(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	pct, isPct := strings.CutSuffix(s, "%")
+	f, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid error rate %q", s)
+	}
+	if isPct {
+		f /= 100
+	}
+	if !(f >= 0 && f <= 1) {
+		return 0, fmt.Errorf("error rate %q must be between 0%% and 100%%", s)
+	}
+	return f, nil
+}
+
+// violations returns a message for each objective s misses, or nil if it
+// meets them all. The p99 covers successful requests only, like the summary,
+// so a run with none misses it rather than passing on an empty sample.
+func (t sloThresholds) violations(s resultsSummary) []string {
+	var out []string
+	switch {
+	case t.p99 > 0 && s.Successes == 0:
+		out = append(out, fmt.Sprintf("no successful requests to measure against -slo-p99 %s", t.p99))
+	case t.p99 > 0 && s.P99 > t.p99:
+		out = append(out, fmt.Sprintf("p99 latency %s exceeds -slo-p99 %s", s.P99, t.p99))
+	}
+	if t.hasErrorRate && s.Total > 0 {
+		if rate := float64(s.Failures) / float64(s.Total); rate > t.errorRate {
+			out = append(out, fmt.Sprintf("error rate %.2f%% exceeds -slo-error-rate %.2f%%", rate*100, t.errorRate*100))
+		}
+	}
+	return out
+}
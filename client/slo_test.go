@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrorRate(t *testing.T) {
+	for s, want := range map[string]float64{"": 0, "1%": 0.01, "0.5%": 0.005, "0.01": 0.01, "100%": 1, "0": 0} {
+		got, err := parseErrorRate(s)
+		if err != nil || got != want {
+			t.Errorf("parseErrorRate(%q): expected %v, got %v (err=%v)", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"abc", "%", "-1%", "101%", "1.5", "NaN"} {
+		if _, err := parseErrorRate(s); err == nil {
+			t.Errorf("parseErrorRate(%q): expected an error", s)
+		}
+	}
+}
+
+// sloRun summarizes 100 requests: successes at 1ms..n ms, then failures.
+func sloRun(successes int) resultsSummary {
+	c := newResultsCollector()
+	for i := 1; i <= successes; i++ {
+		c.Add(true, time.Duration(i)*time.Millisecond)
+	}
+	for i := successes; i < 100; i++ {
+		c.Add(false, 0)
+	}
+	return c.Summary()
+}
+
+func TestSLOViolations(t *testing.T) {
+	tests := []struct {
+		name      string
+		slo       sloThresholds
+		successes int
+		want      []string
+	}{
+		{"no objectives", sloThresholds{}, 50, nil},
+		{"p99 met", sloThresholds{p99: 99 * time.Millisecond}, 100, nil},
+		{"p99 missed", sloThresholds{p99: 50 * time.Millisecond}, 100, []string{"p99 latency 99ms exceeds -slo-p99 50ms"}},
+		{"p99 with every request failed", sloThresholds{p99: 50 * time.Millisecond}, 0, []string{"no successful requests to measure against -slo-p99 50ms"}},
+		{"error rate met", sloThresholds{errorRate: 0.01, hasErrorRate: true}, 99, nil},
+		{"error rate missed", sloThresholds{errorRate: 0.01, hasErrorRate: true}, 98, []string{"error rate 2.00% exceeds -slo-error-rate 1.00%"}},
+		{"zero error rate met", sloThresholds{hasErrorRate: true}, 100, nil},
+		{"zero error rate missed", sloThresholds{hasErrorRate: true}, 99, []string{"error rate 1.00% exceeds -slo-error-rate 0.00%"}},
+		{"both missed", sloThresholds{p99: 10 * time.Millisecond, errorRate: 0.05, hasErrorRate: true}, 90, []string{"p99 latency", "error rate 10.00%"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.slo.violations(sloRun(tt.successes))
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d violations, got %q", len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("violation %d: expected %q, got %q", i, want, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSLOViolationsEmptyRun(t *testing.T) {
+	empty := newResultsCollector().Summary()
+	// There is no latency to meet a p99 with, but no error rate to miss
+	if got := (sloThresholds{p99: time.Millisecond}).violations(empty); len(got) != 1 {
+		t.Errorf("expected an empty run to miss the p99, got %q", got)
+	}
+	if got := (sloThresholds{hasErrorRate: true}).violations(empty); got != nil {
+		t.Errorf("expected an empty run to meet the error rate, got %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// weightedTarget is one -target URL and its share of the requests.
+type weightedTarget struct {
+	URL    string
+	Weight int
+}
+
+// parseWeightedTarget parses a target URL with an optional ":weight=N"
+// suffix, e.g. "http://a:8080/hello:weight=3". Without one the weight is 1.
+func parseWeightedTarget(s string) (weightedTarget, error) {
+	t := weightedTarget{URL: strings.TrimSpace(s), Weight: 1}
+	if i := strings.LastIndex(t.URL, ":weight="); i >= 0 {
+		w, err := strconv.Atoi(t.URL[i+len(":weight="):])
+		if err != nil || w < 1 {
+			return weightedTarget{}, fmt.Errorf("invalid weight in target %q", s)
+		}
+		t.URL, t.Weight = t.URL[:i], w
+	}
+	if t.URL == "" {
+		return weightedTarget{}, fmt.Errorf("empty target in %q", s)
+	}
+	return t, nil
+}
+
+// targetFlags collects repeated -target flags, each of which may also be a
+// comma-separated list. The first -target replaces the default.
+type targetFlags struct {
+	targets []weightedTarget
+	set     bool
+}
+
+func (f *targetFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	parts := make([]string, len(f.targets))
+	for i, t := range f.targets {
+		parts[i] = t.URL
+		if t.Weight != 1 {
+			parts[i] += ":weight=" + strconv.Itoa(t.Weight)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *targetFlags) Set(s string) error {
+	if !f.set {
+		f.targets, f.set = nil, true
+	}
+	for _, part := range strings.Split(s, ",") {
+		t, err := parseWeightedTarget(part)
+		if err != nil {
+			return err
+		}
+		f.targets = append(f.targets, t)
+	}
+	return nil
+}
+
+// targetPicker spreads requests across targets in proportion to their
+// weights using smooth weighted round-robin, which interleaves heavier
+// targets with lighter ones instead of sending them in bursts. It is safe
+// for concurrent use.
+type targetPicker struct {
+	mu      sync.Mutex
+	targets []weightedTarget
+	current []int
+	total   int
+}
+
+func newTargetPicker(targets []weightedTarget) *targetPicker {
+	p := &targetPicker{targets: targets, current: make([]int, len(targets))}
+	for _, t := range targets {
+		p.total += t.Weight
+	}
+	return p
+}
+
+// Next returns the URL for the next request.
+func (p *targetPicker) Next() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best := 0
+	for i, t := range p.targets {
+		p.current[i] += t.Weight
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= p.total
+	return p.targets[best].URL
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseWeightedTarget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    weightedTarget
+		wantErr bool
+	}{
+		{in: "http://a:8080/hello", want: weightedTarget{URL: "http://a:8080/hello", Weight: 1}},
+		{in: " http://a:weight=3", want: weightedTarget{URL: "http://a", Weight: 3}},
+		{in: "http://a:8080/hello:weight=2", want: weightedTarget{URL: "http://a:8080/hello", Weight: 2}},
+		{in: "http://a:weight=0", wantErr: true},
+		{in: "http://a:weight=x", wantErr: true},
+		{in: ":weight=2", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseWeightedTarget(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseWeightedTarget(%q): expected an error, got %+v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseWeightedTarget(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestTargetFlagsReplaceDefault(t *testing.T) {
+	f := &targetFlags{}
+	if err := f.Set("http://default"); err != nil {
+		t.Fatal(err)
+	}
+	f.set = false
+
+	for _, v := range []string{"http://a:weight=2,http://b", "http://c"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	want := []weightedTarget{{"http://a", 2}, {"http://b", 1}, {"http://c", 1}}
+	if !reflect.DeepEqual(f.targets, want) {
+		t.Errorf("expected %+v, got %+v", want, f.targets)
+	}
+	if got := f.String(); got != "http://a:weight=2,http://b,http://c" {
+		t.Errorf("unexpected String(): %q", got)
+	}
+}
+
+func TestTargetPickerFollowsWeights(t *testing.T) {
+	p := newTargetPicker([]weightedTarget{{"a", 5}, {"b", 1}, {"c", 1}})
+
+	var seq []string
+	counts := map[string]int{}
+	for i := 0; i < 700; i++ {
+		url := p.Next()
+		counts[url]++
+		if i < 7 {
+			seq = append(seq, url)
+		}
+	}
+	if want := map[string]int{"a": 500, "b": 100, "c": 100}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("expected %v, got %v", want, counts)
+	}
+	// Smooth: the light targets are spread through the heavy one's picks
+	if got := strings.Join(seq, ""); got != "aabacaa" {
+		t.Errorf("expected interleaved picks aabacaa, got %s", got)
+	}
+}
+
+func TestLoadRunSpreadsAcrossTargets(t *testing.T) {
+	var hitsA, hitsB atomic.Int64
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsA.Add(1) }))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsB.Add(1) }))
+	defer b.Close()
+
+	cfg := config{
+		target:      a.URL,
+		targets:     []weightedTarget{{a.URL, 3}, {b.URL, 1}},
+		total:       200,
+		concurrency: 4,
+		model:       "closed",
+	}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	lr.execute(context.Background())
+
+	if hitsA.Load() != 150 || hitsB.Load() != 50 {
+		t.Errorf("expected a 3:1 split of 200 requests, got %d:%d", hitsA.Load(), hitsB.Load())
+	}
+	s := lr.results.Summary()
+	if s.Targets[a.URL].Total != 150 || s.Targets[b.URL].Total != 50 {
+		t.Errorf("unexpected per-target stats: %+v", s.Targets)
+	}
+
+	var out strings.Builder
+	s.Print(&out)
+	if !strings.Contains(out.String(), "target "+b.URL+" requests=50 failed=0") {
+		t.Errorf("expected per-target lines in the summary, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	teeWorkers   = 4
+	teeQueueSize = 256
+)
+
+// teeForwarder mirrors requests to a recording endpoint on a small pool of
+// background workers. It is best-effort: copies are dropped rather than
+// delaying the caller when the queue is full, and their outcome never counts
+// toward the run's results.
+type teeForwarder struct {
+	base   *url.URL
+	client *http.Client
+	queue  chan teeCopy
+	wg     sync.WaitGroup
+
+	forwarded atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+}
+
+type teeCopy struct {
+	method  string
+	target  string
+	traceID string
+}
+
+func newTeeForwarder(teeURL string, timeout time.Duration) (*teeForwarder, error) {
+	base, err := url.Parse(teeURL)
+	if err != nil {
+		return nil, err
+	}
+	t := &teeForwarder{
+		base:   base,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan teeCopy, teeQueueSize),
+	}
+	for i := 0; i < teeWorkers; i++ {
+		t.wg.Add(1)
+		go t.run()
+	}
+	return t, nil
+}
+
+// Send queues a copy of a request to target without blocking.
+func (t *teeForwarder) Send(method, target, traceID string) {
+	select {
+	case t.queue <- teeCopy{method: method, target: target, traceID: traceID}:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Close stops accepting copies and waits for the queued ones to be sent.
+func (t *teeForwarder) Close() {
+	close(t.queue)
+	t.wg.Wait()
+}
+
+func (t *teeForwarder) run() {
+	defer t.wg.Done()
+	for c := range t.queue {
+		if err := t.forward(c); err != nil {
+			t.failed.Add(1)
+			continue
+		}
+		t.forwarded.Add(1)
+	}
+}
+
+// forward sends c to the tee endpoint, keeping the original path and query
+// and recording the original URL in X-Tee-Original-Url.
+func (t *teeForwarder) forward(c teeCopy) error {
+	mirror := *t.base
+	if orig, err := url.Parse(c.target); err == nil {
+		mirror.Path = orig.Path
+		mirror.RawQuery = orig.RawQuery
+	}
+	req, err := http.NewRequest(c.method, mirror.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Trace-Id", c.traceID)
+	req.Header.Set("X-Tee-Original-Url", c.target)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
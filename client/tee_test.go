@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTeeMirrorsRequestsWithoutAffectingStats(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer primary.Close()
+
+	var mu sync.Mutex
+	copies := map[string]string{}
+	tee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		copies[r.Header.Get("X-Trace-Id")] = r.URL.Path
+		mu.Unlock()
+		// A failing recorder must not turn into primary failures
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tee.Close()
+
+	cfg := config{target: primary.URL + "/hello", total: 10, concurrency: 2, maxRetries: 0, model: "closed"}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	forwarder, err := newTeeForwarder(tee.URL, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create tee: %v", err)
+	}
+	lr.tee = forwarder
+	lr.execute(context.Background())
+	forwarder.Close()
+
+	var requests, failures int
+	for _, u := range lr.users {
+		requests += u.requests
+		failures += u.failures
+	}
+	if requests != 10 || failures != 0 {
+		t.Errorf("expected 10 requests and no failures, got %d requests %d failures", requests, failures)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(copies) != 10 {
+		t.Errorf("expected 10 distinct copies at the tee, got %d", len(copies))
+	}
+	for traceID, path := range copies {
+		if path != "/hello" {
+			t.Errorf("copy %s: expected original path /hello, got %q", traceID, path)
+		}
+	}
+	if got := forwarder.forwarded.Load(); got != 10 {
+		t.Errorf("expected 10 forwarded copies, got %d", got)
+	}
+}
+
+func TestTeeSkipsRequestsCancelledBeforeSending(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer primary.Close()
+	tee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tee.Close()
+
+	cfg := config{target: primary.URL, total: 3, concurrency: 1, model: "closed"}
+	lr := newLoadRun(cfg, &http.Client{Timeout: time.Second})
+	// The first job goes at once and the second waits 10s for its slot
+	lr.rate = newRateLimiter(0.1)
+	forwarder, err := newTeeForwarder(tee.URL, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create tee: %v", err)
+	}
+	lr.tee = forwarder
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	lr.execute(ctx)
+	forwarder.Close()
+
+	if got := forwarder.forwarded.Load() + forwarder.failed.Load() + forwarder.dropped.Load(); got != 1 {
+		t.Errorf("expected only the request that was sent to be mirrored, got %d copies", got)
+	}
+}
+
+func TestTeeSendNeverBlocks(t *testing.T) {
+	block := make(chan struct{})
+	tee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer tee.Close()
+
+	forwarder, err := newTeeForwarder(tee.URL, 5*time.Second)
+	if err != nil {
+		t.Fatalf("failed to create tee: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < teeWorkers+teeQueueSize+10; i++ {
+		forwarder.Send(http.MethodGet, "http://primary/hello", "trace")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected Send to return immediately, took %v", elapsed)
+	}
+	if forwarder.dropped.Load() == 0 {
+		t.Error("expected copies beyond the queue to be dropped")
+	}
+
+	close(block)
+	forwarder.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// heatmapBounds are the upper bounds of the latency buckets counted per
+// second; latencyCounts has one extra slot for anything slower.
+var heatmapBounds = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// secondStats aggregates the requests that completed within one wall-clock second.
+type secondStats struct {
+	second     int64 // unix seconds
+	requests   int64
+	failures   int64
+	latencySum time.Duration
+	// latencyCounts[i] counts requests in (heatmapBounds[i-1], heatmapBounds[i]]
+	latencyCounts []int64
+}
+
+func (s secondStats) avgLatency() time.Duration {
+	if s.requests == 0 {
+		return 0
+	}
+	return s.latencySum / time.Duration(s.requests)
+}
+
+// timeSeries buckets request outcomes by the second they completed in.
+// It is safe for concurrent use by workers.
+type timeSeries struct {
+	mu      sync.Mutex
+	buckets map[int64]*secondStats
+}
+
+func newTimeSeries() *timeSeries {
+	return &timeSeries{buckets: map[int64]*secondStats{}}
+}
+
+func (ts *timeSeries) Observe(at time.Time, success bool, latency time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	second := at.Unix()
+	b, ok := ts.buckets[second]
+	if !ok {
+		b = &secondStats{second: second, latencyCounts: make([]int64, len(heatmapBounds)+1)}
+		ts.buckets[second] = b
+	}
+	b.requests++
+	if !success {
+		b.failures++
+	}
+	b.latencySum += latency
+	b.latencyCounts[sort.Search(len(heatmapBounds), func(i int) bool { return latency <= heatmapBounds[i] })]++
+}
+
+// Range returns copies of the buckets for seconds in [from, to), oldest first.
+func (ts *timeSeries) Range(from, to int64) []secondStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var out []secondStats
+	for second, b := range ts.buckets {
+		if second >= from && second < to {
+			c := *b
+			c.latencyCounts = append([]int64(nil), b.latencyCounts...)
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].second < out[j].second })
+	return out
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeSeriesRange(t *testing.T) {
+	ts := newTimeSeries()
+	ts.Observe(time.Unix(10, 0), true, 10*time.Millisecond)
+	ts.Observe(time.Unix(10, 500), false, 20*time.Millisecond)
+	ts.Observe(time.Unix(12, 0), true, 30*time.Millisecond)
+	ts.Observe(time.Unix(11, 0), true, 40*time.Millisecond)
+
+	got := ts.Range(10, 12)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(got))
+	}
+	if got[0].second != 10 || got[1].second != 11 {
+		t.Errorf("expected seconds [10 11], got [%d %d]", got[0].second, got[1].second)
+	}
+	if got[0].requests != 2 || got[0].failures != 1 {
+		t.Errorf("expected 2 requests and 1 failure in second 10, got %+v", got[0])
+	}
+	if avg := got[0].avgLatency(); avg != 15*time.Millisecond {
+		t.Errorf("expected avg latency 15ms, got %v", avg)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig builds the TLS settings for load requests from -insecure,
+// -client-cert/-client-key and -ca-cert, or returns nil to keep the
+// transport's defaults when none are set.
+func newTLSConfig(cfg config) (*tls.Config, error) {
+	if !cfg.insecure && cfg.clientCert == "" && cfg.caCert == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.insecure}
+	if cfg.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.caCert != "" {
+		data, err := os.ReadFile(cfg.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		// Trust the CA on top of the system roots, not instead of them
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no PEM certificates found in CA certificate file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM writes a single PEM block to a new file in dir.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// newClientCertFiles creates a CA and a client certificate it signs,
+// returning the CA certificate and the PEM files of the client pair.
+func newClientCertFiles(t *testing.T) (ca *x509.Certificate, certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err = x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "load client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, writePEM(t, dir, "client.pem", "CERTIFICATE", clientDER), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, certFile, keyFile := newClientCertFiles(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	// Trust the server's self-signed certificate through -ca-cert
+	caFile := writePEM(t, t.TempDir(), "server-ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	tests := []struct {
+		name    string
+		cfg     config
+		wantErr bool
+	}{
+		{"without client certificate", config{caCert: caFile}, true},
+		{"with client certificate", config{caCert: caFile, clientCert: certFile, clientKey: keyFile}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.timeout = time.Second
+			client, err := newHTTPClient(tt.cfg, &byteCounter{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	_, certFile, keyFile := newClientCertFiles(t)
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	if err := os.WriteFile(notPEM, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config
+		want string
+	}{
+		{"missing key", config{clientCert: certFile, clientKey: filepath.Join(t.TempDir(), "missing.pem")}, "failed to load client certificate"},
+		{"mismatched pair", config{clientCert: certFile, clientKey: certFile}, "failed to load client certificate"},
+		{"missing CA", config{caCert: filepath.Join(t.TempDir(), "missing.pem")}, "failed to read CA certificate"},
+		{"CA not PEM", config{caCert: notPEM}, "no PEM certificates"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newHTTPClient(tt.cfg, &byteCounter{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := newTLSConfig(config{clientCert: certFile, clientKey: keyFile}); err != nil {
+		t.Errorf("unexpected error for a valid pair: %v", err)
+	}
+	if err := (config{model: "closed", clientCert: certFile}).validate(); err == nil {
+		t.Error("expected error for -client-cert without -client-key")
+	}
+}
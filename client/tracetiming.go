@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// phaseTimings breaks one attempt's latency down into where the time went.
+// DNS, Connect and TLS are zero when a kept-alive connection was reused.
+type phaseTimings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is from sending the request to the first byte of the response
+	TTFB  time.Duration
+	Total time.Duration
+}
+
+// phaseNames lists the phases in the order they're reported.
+var phaseNames = []string{"dns", "connect", "tls", "ttfb", "total"}
+
+// durations returns the timings in phaseNames order.
+func (p phaseTimings) durations() []time.Duration {
+	return []time.Duration{p.DNS, p.Connect, p.TLS, p.TTFB, p.Total}
+}
+
+// timingTrace records the httptrace events of one attempt. Dialing can run
+// on another goroutine, so the hooks lock.
+type timingTrace struct {
+	mu                               sync.Mutex
+	start                            time.Time
+	dnsStart, connectStart, tlsStart time.Time
+	timings                          phaseTimings
+}
+
+// withTimingTrace returns ctx hooked to record the phases of a request
+// starting now.
+func withTimingTrace(ctx context.Context) (context.Context, *timingTrace) {
+	t := &timingTrace{start: time.Now()}
+	since := func(from time.Time) time.Duration {
+		return max(time.Since(from), 0)
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timings.DNS = since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			t.timings.Connect = since(t.connectStart)
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timings.TLS = since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.timings.TTFB = since(t.start)
+			t.mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), t
+}
+
+// finish stamps the total and returns the attempt's timings.
+func (t *timingTrace) finish() phaseTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings.Total = time.Since(t.start)
+	return t.timings
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoRequestTraceTiming(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	// Go through localhost so the lookup hooks fire too
+	target := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	cfg := config{target: target, method: http.MethodGet, timeout: time.Second, insecure: true, traceTiming: true}
+	client, err := newHTTPClient(cfg, &byteCounter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first := doRequest(context.Background(), 0, 1, cfg, client, "test-trace", nil)
+	if !first.Success || first.Timings == nil {
+		t.Fatalf("expected a successful request with timings, got %+v", first)
+	}
+	for i, d := range first.Timings.durations() {
+		if d < 0 {
+			t.Errorf("phase %s: expected a non-negative duration, got %v", phaseNames[i], d)
+		}
+	}
+	ft := first.Timings
+	if ft.Connect <= 0 || ft.TLS <= 0 {
+		t.Errorf("expected a new connection to spend time connecting and in the handshake, got %+v", *ft)
+	}
+	if ft.TTFB < 10*time.Millisecond || ft.Total < ft.TTFB {
+		t.Errorf("expected ttfb to cover the handler and total to cover ttfb, got %+v", *ft)
+	}
+
+	// The second request reuses the connection, skipping the setup phases
+	second := doRequest(context.Background(), 0, 2, cfg, client, "test-trace", nil)
+	if st := second.Timings; st == nil || st.DNS != 0 || st.Connect != 0 || st.TLS != 0 || st.TTFB <= 0 {
+		t.Errorf("expected only ttfb and total for a reused connection, got %+v", st)
+	}
+}
+
+func TestSummaryPhasePercentiles(t *testing.T) {
+	c := newResultsCollector()
+	for i := 1; i <= 100; i++ {
+		ms := time.Duration(i) * time.Millisecond
+		c.Record(requestRecord{Success: true, Status: 200, Latency: ms, Attempts: 1, Timings: &phaseTimings{TTFB: ms, Total: 2 * ms}})
+	}
+	c.Record(requestRecord{Success: true, Status: 200, Attempts: 1}) // without -trace-timing
+
+	s := c.Summary()
+	if len(s.Phases) != len(phaseNames) {
+		t.Fatalf("expected %d phases, got %+v", len(phaseNames), s.Phases)
+	}
+	if ttfb := s.Phases[3]; ttfb.Name != "ttfb" || ttfb.P50 != 50*time.Millisecond || ttfb.P99 != 99*time.Millisecond {
+		t.Errorf("unexpected ttfb percentiles: %+v", ttfb)
+	}
+
+	var out strings.Builder
+	s.Print(&out)
+	for _, line := range []string{"phase dns p50=0s p95=0s p99=0s\n", "phase total p50=100ms p95=190ms p99=198ms\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in the summary, got:\n%s", line, out.String())
+		}
+	}
+
+	if s := newResultsCollector().Summary(); s.Phases != nil {
+		t.Errorf("expected no phases without timings, got %+v", s.Phases)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+// authMiddleware rejects requests whose X-Api-Key header doesn't match
+// apiKey with a 401 JSON response. It wraps individual routes rather than
+// the whole mux so probes and metrics stay open. An empty apiKey disables
+// the check.
+func authMiddleware(apiKey string, next http.Handler) http.Handler {
+	if apiKey == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Api-Key")), []byte(apiKey)) != 1 {
+			traceID, _ := r.Context().Value(traceKey).(string)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   "missing or invalid API key",
+				"traceId": traceID,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
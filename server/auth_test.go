@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	newMux := func(apiKey string) http.Handler {
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		mux := http.NewServeMux()
+		mux.Handle("/hello", authMiddleware(apiKey, ok))
+		mux.Handle("/health", ok)
+		mux.Handle(metricsPath, ok)
+		return mux
+	}
+
+	tests := []struct {
+		name   string
+		apiKey string
+		path   string
+		header string
+		want   int
+	}{
+		{"valid key", "s3cret", "/hello", "s3cret", http.StatusOK},
+		{"invalid key", "s3cret", "/hello", "wrong", http.StatusUnauthorized},
+		{"missing key", "s3cret", "/hello", "", http.StatusUnauthorized},
+		{"health is open", "s3cret", "/health", "", http.StatusOK},
+		{"metrics is open", "s3cret", metricsPath, "", http.StatusOK},
+		{"disabled", "", "/hello", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Api-Key", tt.header)
+			}
+			w := httptest.NewRecorder()
+			newMux(tt.apiKey).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectionCarriesTraceID(t *testing.T) {
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+		authMiddleware("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler should not run without a valid key")
+		})))
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("X-Trace-Id", "auth-trace")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %q", ct)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body["traceId"] != "auth-trace" || body["error"] == "" {
+		t.Errorf("expected error with traceId auth-trace, got %v", body)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client that made r. Behind a trusted
+// proxy (TRUST_PROXY_HEADERS) that is the first X-Forwarded-For entry, or
+// else X-Real-IP; otherwise, or when neither header holds an IP, it is the
+// host of the connection's remote address. The headers are ignored unless
+// trusted because any client can send them.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		trust      bool
+		want       string
+	}{
+		{"direct", "203.0.113.7:51234", nil, false, "203.0.113.7"},
+		{"direct IPv6", "[2001:db8::1]:51234", nil, false, "2001:db8::1"},
+		{"forwarded but untrusted", "10.0.0.2:51234", map[string]string{"X-Forwarded-For": "198.51.100.9"}, false, "10.0.0.2"},
+		{"forwarded", "10.0.0.2:51234", map[string]string{"X-Forwarded-For": "198.51.100.9, 10.0.0.1"}, true, "198.51.100.9"},
+		{"real IP", "10.0.0.2:51234", map[string]string{"X-Real-IP": "198.51.100.10"}, true, "198.51.100.10"},
+		{"forwarded wins over real IP", "10.0.0.2:51234", map[string]string{"X-Forwarded-For": "198.51.100.9", "X-Real-IP": "198.51.100.10"}, true, "198.51.100.9"},
+		{"garbage header", "10.0.0.2:51234", map[string]string{"X-Forwarded-For": "not-an-ip"}, true, "10.0.0.2"},
+		{"no port", "10.0.0.2", nil, false, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/hello", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			if got := clientIP(req, tt.trust); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTraceMiddlewareLogsClientIP(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		var buf bytes.Buffer
+		handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{trustProxy: trust}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest("GET", "/hello", nil)
+		req.RemoteAddr = "10.0.0.2:51234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.9")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", buf.String(), err)
+		}
+		want := "10.0.0.2"
+		if trust {
+			want = "198.51.100.9"
+		}
+		if entry["clientIp"] != want || entry["remoteAddr"] != "10.0.0.2:51234" {
+			t.Errorf("trust=%v: expected clientIp %s and the raw remoteAddr, got %v", trust, want, entry)
+		}
+	}
+}
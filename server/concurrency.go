@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// concurrencyLimiter caps the number of requests handled at once. The limit
+// can be changed while requests are in flight: lowering it never interrupts
+// them, it only turns new requests away until enough have finished. A limit
+// of zero means unlimited. The last reserved slots are kept for
+// high-priority requests.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	reserved int
+	inUse    int
+}
+
+func newConcurrencyLimiter(limit, reserved int) *concurrencyLimiter {
+	return &concurrencyLimiter{limit: limit, reserved: reserved}
+}
+
+// TryAcquire takes a slot if one is free, counting reserved slots as free
+// only for high-priority requests.
+func (l *concurrencyLimiter) TryAcquire(high bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	capacity := l.limit
+	if !high {
+		capacity -= l.reserved
+	}
+	if l.limit > 0 && l.inUse >= capacity {
+		return false
+	}
+	l.inUse++
+	return true
+}
+
+func (l *concurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse--
+}
+
+func (l *concurrencyLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+}
+
+func (l *concurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// Reserved reports how many slots are kept for high-priority requests.
+func (l *concurrencyLimiter) Reserved() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reserved
+}
+
+// priorityPaths maps a path prefix to "high" or "low" priority, as configured
+// by PRIORITY_PATHS.
+type priorityPaths map[string]string
+
+// parsePriorityPaths parses a JSON object of prefix to priority, e.g.
+// {"/checkout": "high"}.
+func parsePriorityPaths(s string) (priorityPaths, error) {
+	var paths priorityPaths
+	if err := json.Unmarshal([]byte(s), &paths); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	for prefix, priority := range paths {
+		if priority != "high" && priority != "low" {
+			return nil, fmt.Errorf("invalid priority %q for prefix %q: want high or low", priority, prefix)
+		}
+	}
+	return paths, nil
+}
+
+// isHighPriority reports whether r should be admitted to reserved slots. An
+// X-Priority header of high or low wins; otherwise the longest matching
+// prefix in paths decides, and requests matching none are low priority.
+func (p priorityPaths) isHighPriority(r *http.Request) bool {
+	switch strings.ToLower(r.Header.Get("X-Priority")) {
+	case "high":
+		return true
+	case "low":
+		return false
+	}
+	best, high := -1, false
+	for prefix, priority := range p {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > best {
+			best, high = len(prefix), priority == "high"
+		}
+	}
+	return high
+}
+
+// concurrencyMiddleware answers 503 when limiter has no free slot. When
+// slots are reserved for high-priority requests (see priorities), a
+// low-priority request turned away is shed with 429 instead. Probes,
+// metrics, /debug/inflight and admin requests bypass it so the server stays
+// observable and the limit can always be raised again.
+func concurrencyMiddleware(limiter *concurrencyLimiter, priorities priorityPaths, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || r.URL.Path == "/readyz" || r.URL.Path == metricsPath || r.URL.Path == "/debug/inflight" || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		high := priorities.isHighPriority(r)
+		if !limiter.TryAcquire(high) {
+			traceID, _ := r.Context().Value(traceKey).(string)
+			status, msg := http.StatusServiceUnavailable, "too many concurrent requests"
+			if !high && limiter.Reserved() > 0 {
+				status, msg = http.StatusTooManyRequests, "low-priority request shed under load"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			// Slots free up as soon as in-flight requests finish
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   msg,
+				"traceId": traceID,
+			})
+			return
+		}
+		defer limiter.Release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleConcurrency reports (GET) or changes (POST {"limit": n}) the
+// concurrency limit at runtime. It is only registered when ENABLE_ADMIN=true.
+func handleConcurrency(limiter *concurrencyLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Limit *int `json:"limit"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Limit == nil || *req.Limit < 0 {
+				http.Error(w, `expected {"limit": n} with n >= 0`, http.StatusBadRequest)
+				return
+			}
+			limiter.SetLimit(*req.Limit)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"limit": limiter.Limit()})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimitLoweredMidFlight(t *testing.T) {
+	limiter := newConcurrencyLimiter(4, 0)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/work", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") == "true" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+	mux.Handle("/admin/concurrency", handleConcurrency(limiter))
+	handler := concurrencyMiddleware(limiter, nil, mux)
+
+	// Two requests hold slots while the limit changes under them
+	var wg sync.WaitGroup
+	inFlight := make([]*httptest.ResponseRecorder, 2)
+	for i := range inFlight {
+		inFlight[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/work?block=true", nil))
+		}(inFlight[i])
+	}
+	<-started
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/concurrency", strings.NewReader(`{"limit": 1}`)))
+	var resp map[string]int
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["limit"] != 1 {
+		t.Fatalf("expected admin to report limit 1, got %v (status %d, err=%v)", resp, w.Code, err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/work", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected excess request to get %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After 1 on the 503, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// In-flight requests keep their slots and finish normally
+	close(release)
+	wg.Wait()
+	for i, w := range inFlight {
+		if w.Code != http.StatusOK {
+			t.Errorf("in-flight request %d: expected %d, got %d", i, http.StatusOK, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/work", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a request within the new limit to succeed, got %d", w.Code)
+	}
+}
+
+func TestHandleConcurrencyRejectsBadInput(t *testing.T) {
+	limiter := newConcurrencyLimiter(2, 0)
+	tests := []struct {
+		method, body string
+		want         int
+	}{
+		{"POST", `{"limit": -1}`, http.StatusBadRequest},
+		{"POST", `{}`, http.StatusBadRequest},
+		{"POST", `nope`, http.StatusBadRequest},
+		{"DELETE", ``, http.StatusMethodNotAllowed},
+		{"GET", ``, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleConcurrency(limiter)(w, httptest.NewRequest(tt.method, "/admin/concurrency", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s %q: expected %d, got %d", tt.method, tt.body, tt.want, w.Code)
+		}
+	}
+	if limiter.Limit() != 2 {
+		t.Errorf("expected rejected updates to leave the limit at 2, got %d", limiter.Limit())
+	}
+}
+
+func TestConcurrencyPriorityShedding(t *testing.T) {
+	limiter := newConcurrencyLimiter(3, 1)
+	priorities, err := parsePriorityPaths(`{"/checkout": "high", "/checkout/preview": "low"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	handler := concurrencyMiddleware(limiter, priorities, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") == "true" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+
+	var wg sync.WaitGroup
+	hold := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(w, req)
+		}()
+		<-started
+		return w
+	}
+	serve := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	withPriority := func(req *http.Request, priority string) *http.Request {
+		req.Header.Set("X-Priority", priority)
+		return req
+	}
+
+	// Saturate the unreserved slots with low-priority work
+	held := []*httptest.ResponseRecorder{
+		hold(httptest.NewRequest("GET", "/work?block=true", nil)),
+		hold(httptest.NewRequest("GET", "/work?block=true", nil)),
+	}
+
+	if code := serve(httptest.NewRequest("GET", "/work", nil)); code != http.StatusTooManyRequests {
+		t.Errorf("expected low-priority request to be shed with %d, got %d", http.StatusTooManyRequests, code)
+	}
+	if code := serve(withPriority(httptest.NewRequest("GET", "/checkout", nil), "low")); code != http.StatusTooManyRequests {
+		t.Errorf("expected X-Priority: low to override the path, got %d", code)
+	}
+	if code := serve(httptest.NewRequest("GET", "/checkout/preview", nil)); code != http.StatusTooManyRequests {
+		t.Errorf("expected the longest prefix to make /checkout/preview low priority, got %d", code)
+	}
+	if code := serve(withPriority(httptest.NewRequest("GET", "/work", nil), "high")); code != http.StatusOK {
+		t.Errorf("expected high-priority request to use a reserved slot, got %d", code)
+	}
+	if code := serve(httptest.NewRequest("GET", "/checkout", nil)); code != http.StatusOK {
+		t.Errorf("expected /checkout to be high priority by path, got %d", code)
+	}
+
+	// Once the reserved slot is taken too, even high priority is turned away
+	held = append(held, hold(withPriority(httptest.NewRequest("GET", "/work?block=true", nil), "high")))
+	if code := serve(withPriority(httptest.NewRequest("GET", "/work", nil), "high")); code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d with every slot taken, got %d", http.StatusServiceUnavailable, code)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, w := range held {
+		if w.Code != http.StatusOK {
+			t.Errorf("held request %d: expected %d, got %d", i, http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestParsePriorityPathsRejectsBadInput(t *testing.T) {
+	for _, input := range []string{`nope`, `{"/a": "urgent"}`, `{"/a": 1}`} {
+		if _, err := parsePriorityPaths(input); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
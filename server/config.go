@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultReadTimeout  = 5 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+	defaultIdleTimeout  = 30 * time.Second
+)
+
+// serverConfig holds the server settings that can come from a config file
+// (-config or CONFIG_FILE) as well as the environment.
+type serverConfig struct {
+	port    string
+	logPath string
+	// shutdownTimeout bounds the drain of in-flight requests on shutdown
+	shutdownTimeout time.Duration
+	// requestTimeout is the default per-request deadline (REQUEST_TIMEOUT)
+	requestTimeout time.Duration
+	// readTimeout, writeTimeout and idleTimeout configure the http.Server
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	// logLevel and helloDelay are also re-read on SIGHUP
+	logLevel   slog.Level
+	helloDelay time.Duration
+}
+
+// configFile is the YAML layout of a config file. Every key is optional;
+// durations are Go duration strings such as "10s".
+type configFile struct {
+	Port            string `yaml:"port"`
+	LogPath         string `yaml:"logPath"`
+	ShutdownTimeout string `yaml:"shutdownTimeout"`
+	RequestTimeout  string `yaml:"requestTimeout"`
+	ReadTimeout     string `yaml:"readTimeout"`
+	WriteTimeout    string `yaml:"writeTimeout"`
+	IdleTimeout     string `yaml:"idleTimeout"`
+	LogLevel        string `yaml:"logLevel"`
+	HelloDelay      string `yaml:"helloDelay"`
+}
+
+// loadConfig resolves the server settings from the built-in defaults, then
+// the YAML file at path (skipped if empty), then the environment as read
+// by getenv, each overriding the last. Unknown keys in the file, and
+// unparseable or negative durations from either source, are errors.
+func loadConfig(path string, getenv func(string) string) (serverConfig, error) {
+	var file configFile
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return serverConfig{}, fmt.Errorf("read config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return serverConfig{}, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	pick := func(envKey, fromFile, def string) string {
+		if v := getenv(envKey); v != "" {
+			return v
+		}
+		if fromFile != "" {
+			return fromFile
+		}
+		return def
+	}
+	cfg := serverConfig{
+		port:    pick("PORT", file.Port, defaultPort),
+		logPath: pick("LOG_PATH", file.LogPath, defaultLogPath),
+	}
+	durations := []struct {
+		envKey   string
+		fromFile string
+		def      time.Duration
+		dst      *time.Duration
+	}{
+		{"SHUTDOWN_TIMEOUT", file.ShutdownTimeout, defaultShutdownTimeout, &cfg.shutdownTimeout},
+		{"REQUEST_TIMEOUT", file.RequestTimeout, defaultRequestTimeout, &cfg.requestTimeout},
+		{"READ_TIMEOUT", file.ReadTimeout, defaultReadTimeout, &cfg.readTimeout},
+		{"WRITE_TIMEOUT", file.WriteTimeout, defaultWriteTimeout, &cfg.writeTimeout},
+		{"IDLE_TIMEOUT", file.IdleTimeout, defaultIdleTimeout, &cfg.idleTimeout},
+		{"HELLO_DELAY", file.HelloDelay, defaultHelloDelay, &cfg.helloDelay},
+	}
+	for _, d := range durations {
+		v := pick(d.envKey, d.fromFile, d.def.String())
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			return serverConfig{}, fmt.Errorf("invalid %s %q: want a non-negative duration such as 10s", d.envKey, v)
+		}
+		*d.dst = parsed
+	}
+	level, err := parseLogLevel(pick("LOG_LEVEL", file.LogLevel, "info"))
+	if err != nil {
+		return serverConfig{}, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	cfg.logLevel = level
+	return cfg, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file with contents to a temp dir.
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+// mapEnv returns a getenv reading from env.
+func mapEnv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "port: \"9090\"\nlogPath: /tmp/file.log\nshutdownTimeout: 20s\nrequestTimeout: 2s\nlogLevel: warn\nhelloDelay: 5ms\n")
+
+	tests := []struct {
+		name string
+		path string
+		env  map[string]string
+		want serverConfig
+	}{
+		{"defaults", "", nil, serverConfig{
+			port: defaultPort, logPath: defaultLogPath, shutdownTimeout: defaultShutdownTimeout, requestTimeout: defaultRequestTimeout,
+			readTimeout: defaultReadTimeout, writeTimeout: defaultWriteTimeout, idleTimeout: defaultIdleTimeout,
+			helloDelay: defaultHelloDelay,
+		}},
+		{"file over defaults", path, nil, serverConfig{
+			port: "9090", logPath: "/tmp/file.log", shutdownTimeout: 20 * time.Second, requestTimeout: 2 * time.Second,
+			readTimeout: defaultReadTimeout, writeTimeout: defaultWriteTimeout, idleTimeout: defaultIdleTimeout,
+			logLevel: slog.LevelWarn, helloDelay: 5 * time.Millisecond,
+		}},
+		{"env over file", path, map[string]string{"PORT": "7070", "REQUEST_TIMEOUT": "0s", "IDLE_TIMEOUT": "1m", "LOG_LEVEL": "debug"}, serverConfig{
+			port: "7070", logPath: "/tmp/file.log", shutdownTimeout: 20 * time.Second, requestTimeout: 0,
+			readTimeout: defaultReadTimeout, writeTimeout: defaultWriteTimeout, idleTimeout: time.Minute,
+			logLevel: slog.LevelDebug, helloDelay: 5 * time.Millisecond,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadConfig(tt.path, mapEnv(tt.env))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		env  map[string]string
+		want string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.yaml"), nil, "read config file"},
+		{"malformed YAML", writeConfigFile(t, "port: [unclosed\n"), nil, "parse config file"},
+		{"unknown key", writeConfigFile(t, "prot: 8080\n"), nil, "parse config file"},
+		{"bad file duration", writeConfigFile(t, "shutdownTimeout: soon\n"), nil, "invalid SHUTDOWN_TIMEOUT"},
+		{"bad env duration", "", map[string]string{"READ_TIMEOUT": "-1s"}, "invalid READ_TIMEOUT"},
+		{"bad file log level", writeConfigFile(t, "logLevel: loud\n"), nil, "invalid LOG_LEVEL"},
+		{"bad env hello delay", "", map[string]string{"HELLO_DELAY": "later"}, "invalid HELLO_DELAY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConfig(tt.path, mapEnv(tt.env)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// An empty file is valid and leaves the defaults
+	if _, err := loadConfig(writeConfigFile(t, ""), mapEnv(nil)); err != nil {
+		t.Errorf("unexpected error for an empty file: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// flakyHandler fails a configurable fraction of requests with a 500, for
+// exercising client backoff and circuit breaking.
+type flakyHandler struct {
+	logger *slog.Logger
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFlakyHandler(logger *slog.Logger, seed uint64) *flakyHandler {
+	return &flakyHandler{logger: logger, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// ServeHTTP answers 500 with probability ?fail_rate= (0 to 1, default 0.5)
+// and 200 otherwise. Without ?seed= the draw comes from the handler's shared
+// generator; with it, from a generator seeded with that value, so the same
+// seed and rate always give the same outcome.
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traceID, _ := r.Context().Value(traceKey).(string)
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	rate := 0.5
+	if v := query.Get("fail_rate"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0 && f <= 1) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   fmt.Sprintf("invalid fail_rate %q: must be between 0 and 1", v),
+				"traceId": traceID,
+			})
+			return
+		}
+		rate = f
+	}
+
+	var draw float64
+	if v := query.Get("seed"); v != "" {
+		seed, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   fmt.Sprintf("invalid seed %q: must be a non-negative integer", v),
+				"traceId": traceID,
+			})
+			return
+		}
+		draw = rand.New(rand.NewPCG(seed, seed)).Float64()
+	} else {
+		h.mu.Lock()
+		draw = h.rng.Float64()
+		h.mu.Unlock()
+	}
+
+	status := http.StatusOK
+	if draw < rate {
+		status = http.StatusInternalServerError
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   http.StatusText(status),
+			"traceId": traceID,
+		})
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "ok",
+			"traceId": traceID,
+		})
+	}
+	logEvent(h.logger, logEntry{
+		TraceID: traceID,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Status:  status,
+		Message: "handler finished",
+		Level:   slog.LevelDebug,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func serveFlaky(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func TestFlakyFailRateBounds(t *testing.T) {
+	h := newFlakyHandler(slog.New(newFileHandler(io.Discard, nil)), 1)
+	for _, tt := range []struct {
+		target string
+		want   int
+	}{
+		{"/flaky?fail_rate=0", http.StatusOK},
+		{"/flaky?fail_rate=1", http.StatusInternalServerError},
+		{"/flaky?fail_rate=0&seed=7", http.StatusOK},
+		{"/flaky?fail_rate=1&seed=7", http.StatusInternalServerError},
+	} {
+		for i := 0; i < 200; i++ {
+			if w := serveFlaky(t, h, tt.target); w.Code != tt.want {
+				t.Fatalf("%s: expected %d, got %d", tt.target, tt.want, w.Code)
+			}
+		}
+	}
+}
+
+func TestFlakyFailRateMix(t *testing.T) {
+	h := newFlakyHandler(slog.New(newFileHandler(io.Discard, nil)), 42)
+	const n = 5000
+	failures := 0
+	for i := 0; i < n; i++ {
+		w := serveFlaky(t, h, "/flaky?fail_rate=0.3")
+		if w.Code == http.StatusInternalServerError {
+			failures++
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] == "" {
+				t.Fatalf("expected a JSON error body, got %q (err=%v)", w.Body.String(), err)
+			}
+		}
+	}
+	if got := float64(failures) / n; got < 0.27 || got > 0.33 {
+		t.Errorf("expected ~30%% failures, got %.3f", got)
+	}
+}
+
+func TestFlakySeedIsDeterministic(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	// Outcomes for seeds 0..99 must not depend on the handler's own
+	// generator or on earlier requests
+	a, b := newFlakyHandler(logger, 1), newFlakyHandler(logger, 2)
+	failures := 0
+	for seed := 0; seed < 100; seed++ {
+		target := "/flaky?fail_rate=0.5&seed=" + strconv.Itoa(seed)
+		first := serveFlaky(t, a, target).Code
+		for i := 0; i < 3; i++ {
+			if got := serveFlaky(t, b, target).Code; got != first {
+				t.Fatalf("seed %d: expected %d every time, got %d", seed, first, got)
+			}
+		}
+		if first == http.StatusInternalServerError {
+			failures++
+		}
+	}
+	if failures == 0 || failures == 100 {
+		t.Errorf("expected seeds to give a mix of outcomes, got %d/100 failures", failures)
+	}
+
+	// Without a seed, handlers built with the same seed agree
+	c, d := newFlakyHandler(logger, 9), newFlakyHandler(logger, 9)
+	for i := 0; i < 100; i++ {
+		if x, y := serveFlaky(t, c, "/flaky").Code, serveFlaky(t, d, "/flaky").Code; x != y {
+			t.Fatalf("request %d: expected matching outcomes, got %d and %d", i, x, y)
+		}
+	}
+}
+
+func TestFlakyRejectsBadParams(t *testing.T) {
+	h := newFlakyHandler(slog.New(newFileHandler(io.Discard, nil)), 1)
+	for _, target := range []string{"/flaky?fail_rate=abc", "/flaky?fail_rate=-0.1", "/flaky?fail_rate=1.5", "/flaky?fail_rate=NaN", "/flaky?seed=-1", "/flaky?seed=x"} {
+		w := serveFlaky(t, h, target)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", target, http.StatusBadRequest, w.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] == "" {
+			t.Errorf("%s: expected a JSON error, got %q (err=%v)", target, w.Body.String(), err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzipMiddlewareRoundTrip(t *testing.T) {
+	body := strings.Repeat(`{"message":"hello"}`, 100)
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected Content-Type to be kept, got %q", got)
+	}
+	if w.Body.Len() >= len(body) {
+		t.Errorf("expected compressed body smaller than %d bytes, got %d", len(body), w.Body.Len())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %v", err)
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress body: %v", err)
+	}
+	if string(decoded) != body {
+		t.Errorf("round-tripped body mismatch")
+	}
+}
+
+func TestGzipMiddlewarePlainForOtherClients(t *testing.T) {
+	handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+
+	for _, accept := range []string{"", "br", "gzip;q=0"} {
+		req := httptest.NewRequest("GET", "/hello", nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: expected no Content-Encoding, got %q", accept, got)
+		}
+		if w.Body.String() != "plain" {
+			t.Errorf("Accept-Encoding %q: expected plain body, got %q", accept, w.Body.String())
+		}
+	}
+}
+
+func TestGzipMiddlewareKeepsStatusForMetrics(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+		gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream failed"))
+		})))
+
+	req := httptest.NewRequest("GET", "/gzip-error", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if stats := endpointMetrics["/gzip-error"]; stats == nil || stats.errorCount != 1 {
+		t.Errorf("expected the compressed 502 to be counted as an error, got %+v", stats)
+	}
+}
+
+func TestGzipMiddlewareStreams(t *testing.T) {
+	server := httptest.NewServer(gzipMiddleware(handleStream(time.Millisecond)))
+	defer server.Close()
+
+	// The default transport asks for gzip and decompresses transparently
+	resp, err := http.Get(server.URL + "/stream?n=3")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if !resp.Uncompressed {
+		t.Error("expected a gzip-encoded response")
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if got := strings.Count(string(data), "\n"); got != 3 {
+		t.Errorf("expected 3 streamed events, got %d: %q", got, data)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+
+	// defaultRedactHeaders are request headers whose values are never logged.
+	defaultRedactHeaders = "Authorization,Cookie,X-Api-Key"
+)
+
+// headerFilter decides which request headers traceMiddleware logs. When
+// allow is non-empty only those headers are recorded; otherwise all are.
+// Headers in redact are recorded with their value replaced by
+// "[REDACTED]", so a log reader can still see that they were sent.
+type headerFilter struct {
+	allow  map[string]bool
+	redact map[string]bool
+}
+
+// newHeaderFilter builds a filter from comma-separated header name lists.
+// Names are case-insensitive.
+func newHeaderFilter(allow, redact string) *headerFilter {
+	return &headerFilter{allow: headerSet(allow), redact: headerSet(redact)}
+}
+
+func headerSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return set
+}
+
+// filter returns the headers to log, multiple values joined with ", ".
+func (f *headerFilter) filter(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		name = http.CanonicalHeaderKey(name)
+		if len(f.allow) > 0 && !f.allow[name] {
+			continue
+		}
+		if f.redact[name] {
+			out[name] = redactedValue
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	return out
+}
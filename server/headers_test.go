@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Cookie", "session=secret")
+	h.Set("X-Api-Key", "secret")
+	h.Set("User-Agent", "load-client/1.0")
+	h.Add("Accept", "text/plain")
+	h.Add("Accept", "application/json")
+
+	tests := []struct {
+		name          string
+		allow, redact string
+		want          map[string]string
+	}{
+		{
+			name:   "defaults redact secrets",
+			redact: defaultRedactHeaders,
+			want: map[string]string{
+				"Authorization": redactedValue,
+				"Cookie":        redactedValue,
+				"X-Api-Key":     redactedValue,
+				"User-Agent":    "load-client/1.0",
+				"Accept":        "text/plain, application/json",
+			},
+		},
+		{
+			name:   "allowlist limits headers",
+			allow:  "user-agent, authorization",
+			redact: defaultRedactHeaders,
+			want: map[string]string{
+				"Authorization": redactedValue,
+				"User-Agent":    "load-client/1.0",
+			},
+		},
+		{
+			name:   "custom denylist",
+			redact: "user-agent",
+			want: map[string]string{
+				"Authorization": "Bearer secret",
+				"Cookie":        "session=secret",
+				"X-Api-Key":     "secret",
+				"User-Agent":    redactedValue,
+				"Accept":        "text/plain, application/json",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newHeaderFilter(tt.allow, tt.redact).filter(h)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTraceMiddlewareLogsFilteredHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	filter := newHeaderFilter("", defaultRedactHeaders)
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{logHeaders: filter}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("User-Agent", "load-client/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("expected secrets to be redacted, got %s", buf.String())
+	}
+	var entry logEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+	}
+	for _, name := range []string{"Authorization", "Cookie", "X-Api-Key"} {
+		if entry.Headers[name] != redactedValue {
+			t.Errorf("expected %s=%q, got %q", name, redactedValue, entry.Headers[name])
+		}
+	}
+	if entry.Headers["User-Agent"] != "load-client/1.0" {
+		t.Errorf("expected User-Agent to pass through, got %q", entry.Headers["User-Agent"])
+	}
+}
+
+func TestTraceMiddlewareOmitsHeadersByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("User-Agent", "load-client/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if strings.Contains(buf.String(), `"headers"`) {
+		t.Errorf("expected no headers in log entry, got %s", buf.String())
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// inflightRequest describes a request that is still being served.
+type inflightRequest struct {
+	TraceID string
+	Method  string
+	Path    string
+	Start   time.Time
+}
+
+// inflightRegistry tracks the requests currently being served so they can
+// be inspected when the server appears stuck. Entries are keyed by a
+// registry-assigned ID because clients may reuse trace IDs.
+type inflightRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]inflightRequest
+}
+
+func newInflightRegistry() *inflightRegistry {
+	return &inflightRegistry{requests: map[uint64]inflightRequest{}}
+}
+
+// Add records req and returns the ID to pass to Remove once it completes.
+func (r *inflightRegistry) Add(req inflightRequest) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.requests[r.nextID] = req
+	return r.nextID
+}
+
+func (r *inflightRegistry) Remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.requests, id)
+}
+
+// Snapshot returns the in-flight requests, oldest first.
+func (r *inflightRegistry) Snapshot() []inflightRequest {
+	r.mu.Lock()
+	reqs := make([]inflightRequest, 0, len(r.requests))
+	for _, req := range r.requests {
+		reqs = append(reqs, req)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Start.Before(reqs[j].Start) })
+	return reqs
+}
+
+// handleInflight lists the requests currently being served, oldest first,
+// with how long each has been running. It is only registered when
+// ENABLE_ADMIN=true.
+func handleInflight(registry *inflightRegistry) http.HandlerFunc {
+	type inflightJSON struct {
+		TraceID   string    `json:"traceId"`
+		Method    string    `json:"method"`
+		Path      string    `json:"path"`
+		Start     time.Time `json:"start"`
+		ElapsedMs int64     `json:"elapsedMs"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		now := time.Now()
+		reqs := registry.Snapshot()
+		out := make([]inflightJSON, 0, len(reqs))
+		for _, req := range reqs {
+			out = append(out, inflightJSON{
+				TraceID:   req.TraceID,
+				Method:    req.Method,
+				Path:      req.Path,
+				Start:     req.Start,
+				ElapsedMs: now.Sub(req.Start).Milliseconds(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"requests": out})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleInflightListsRunningRequest(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	mux.Handle("/debug/inflight", handleInflight(activeRequests))
+	srv := httptest.NewServer(traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, mux))
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequest("GET", srv.URL+"/slow", nil)
+		req.Header.Set("X-Trace-Id", "slow-trace")
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	time.Sleep(20 * time.Millisecond)
+
+	var body struct {
+		Requests []struct {
+			TraceID   string `json:"traceId"`
+			Method    string `json:"method"`
+			Path      string `json:"path"`
+			ElapsedMs int64  `json:"elapsedMs"`
+		} `json:"requests"`
+	}
+	fetch := func() {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/debug/inflight")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetch()
+	found := false
+	for _, req := range body.Requests {
+		if req.TraceID == "slow-trace" {
+			found = true
+			if req.Method != "GET" || req.Path != "/slow" {
+				t.Errorf("expected GET /slow, got %s %s", req.Method, req.Path)
+			}
+			if req.ElapsedMs < 20 {
+				t.Errorf("expected elapsedMs >= 20, got %d", req.ElapsedMs)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected slow-trace in /debug/inflight, got %+v", body.Requests)
+	}
+
+	close(release)
+	<-done
+	fetch()
+	for _, req := range body.Requests {
+		if req.TraceID == "slow-trace" {
+			t.Errorf("expected slow-trace to be removed after completion, got %+v", body.Requests)
+		}
+	}
+}
+
+func TestInflightRegistrySnapshotOrder(t *testing.T) {
+	registry := newInflightRegistry()
+	now := time.Now()
+	registry.Add(inflightRequest{TraceID: "newer", Start: now})
+	id := registry.Add(inflightRequest{TraceID: "older", Start: now.Add(-time.Second)})
+
+	reqs := registry.Snapshot()
+	if len(reqs) != 2 || reqs[0].TraceID != "older" || reqs[1].TraceID != "newer" {
+		t.Fatalf("expected [older newer], got %+v", reqs)
+	}
+
+	registry.Remove(id)
+	if reqs := registry.Snapshot(); len(reqs) != 1 || reqs[0].TraceID != "newer" {
+		t.Errorf("expected [newer] after remove, got %+v", reqs)
+	}
+}
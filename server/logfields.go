@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// accessLogFields maps the LOG_FIELDS names to the logEntry fields they
+// select.
+var accessLogFields = map[string]string{
+	"trace":   "traceId",
+	"method":  "method",
+	"path":    "path",
+	"status":  "status",
+	"latency": "latencyMs",
+	"ua":      "userAgent",
+	"remote":  "remoteAddr",
+	"ip":      "clientIp",
+}
+
+// logFieldSet is the set of access log fields traceMiddleware writes, keyed
+// by LOG_FIELDS name. A nil set writes them all.
+type logFieldSet map[string]bool
+
+// parseLogFields parses a comma-separated LOG_FIELDS list, returning the
+// selected fields and, sorted, any names it doesn't know. An empty list
+// selects every field.
+func parseLogFields(list string) (fields logFieldSet, unknown []string) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := accessLogFields[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if fields == nil {
+			fields = logFieldSet{}
+		}
+		fields[name] = true
+	}
+	sort.Strings(unknown)
+	return fields, unknown
+}
+
+// has reports whether the set includes the named field.
+func (s logFieldSet) has(name string) bool {
+	return s == nil || s[name]
+}
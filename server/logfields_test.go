@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseLogFields(t *testing.T) {
+	fields, unknown := parseLogFields(" Trace, status,bogus,, latency ,nope")
+	if want := (logFieldSet{"trace": true, "status": true, "latency": true}); !reflect.DeepEqual(fields, want) {
+		t.Errorf("expected %v, got %v", want, fields)
+	}
+	if want := []string{"bogus", "nope"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("expected unknown %v, got %v", want, unknown)
+	}
+
+	fields, unknown = parseLogFields("")
+	if fields != nil || unknown != nil {
+		t.Errorf("expected every field for an empty list, got %v and unknown %v", fields, unknown)
+	}
+	for name := range accessLogFields {
+		if !fields.has(name) {
+			t.Errorf("expected the default set to include %s", name)
+		}
+	}
+}
+
+func TestTraceMiddlewareLogFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		want   []string
+	}{
+		{"default full set", "", []string{"clientIp", "latencyMs", "level", "message", "method", "path", "remoteAddr", "status", "traceId", "userAgent"}},
+		{"custom subset", "trace,status,ua", []string{"level", "message", "status", "traceId", "userAgent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, _ := parseLogFields(tt.fields)
+			var buf bytes.Buffer
+			handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{logFields: fields}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest("GET", "/hello", nil)
+			req.Header.Set("User-Agent", "load-client/1.0")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("invalid log line %q: %v", buf.String(), err)
+			}
+			got := make([]string, 0, len(entry))
+			for key := range entry {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected fields %v, got %v", tt.want, got)
+			}
+			if entry["userAgent"] != "load-client/1.0" {
+				t.Errorf("expected the request's User-Agent, got %v", entry["userAgent"])
+			}
+		})
+	}
+}
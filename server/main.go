@@ -3,40 +3,129 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 const (
 	defaultLogPath         = "/var/log/app/app.log"
 	defaultPort            = "8080"
 	defaultShutdownTimeout = 10 * time.Second
+	defaultRequestTimeout  = 5 * time.Second
+	defaultHelloDelay      = 50 * time.Millisecond
+	defaultLogMaxBytes     = 100 << 20 // 100 MiB
+	defaultLogMaxBackups   = 5
+	metricsPath            = "/metrics"
+
+	// /stream and profiles outlive WriteTimeout and REQUEST_TIMEOUT by default
+	defaultRouteWriteTimeouts = `{"/stream": "60s", "/debug/pprof/": "60s"}`
+	streamInterval            = time.Second
+
+	// maxHelloPayloadSize bounds /hello's padding so a single request can't
+	// make the server build an arbitrarily large response
+	maxHelloPayloadSize = 1 << 20 // 1 MiB
+
+	// statusClientClosedRequest is nginx's non-standard status for a request
+	// the client abandoned before the response, so metrics count it as an
+	// error rather than a 200 that was never sent
+	statusClientClosedRequest = 499
 )
 
+// endpointStats holds the request metrics recorded for a single path.
+type endpointStats struct {
+	requestCount int64
+	errorCount   int64
+	// latencyMsSum is in fractional milliseconds, to match the buckets
+	latencyMsSum float64
+	// bucketCounts[i] counts requests with latency <= latencyBuckets[i] (cumulative)
+	bucketCounts []int64
+
+	// Time handlers spent blocked reading request bodies
+	bodyReadCount int64
+	bodyReadMsSum float64
+	slowBodyReads int64
+
+	// Body bytes read from requests and written to responses
+	requestBytes  int64
+	responseBytes int64
+}
+
 var (
-	// Metrics for observability
-	requestCount   int64
-	errorCount     int64
-	totalLatencyMs int64
-	metricsMutex   sync.RWMutex
+	// Metrics for observability, keyed by request path
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex    sync.RWMutex
+
+	// Requests currently being served (excluding metrics scrapes)
+	inFlightRequests atomic.Int64
+	// and their details, for /debug/inflight
+	activeRequests = newInflightRegistry()
+
+	// ready is set once the listener is bound and cleared when shutdown
+	// begins; draining distinguishes the latter from not having started yet
+	ready    atomic.Bool
+	draining atomic.Bool
+
+	// startTime is when the process started, for process_uptime_seconds.
+	// main resets it; tests may set it directly.
+	startTime = time.Now()
+
+	// Upper bounds (ms) of the request duration histogram buckets
+	defaultLatencyBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000}
+	latencyBuckets        = defaultLatencyBuckets
 )
 
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "none"
+)
+
+// labelEscaper escapes label values for the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
 type ctxKey string
 
-const traceKey ctxKey = "traceId"
+const (
+	traceKey ctxKey = "traceId"
+	// delayAppliedKey marks requests whose simulated latency was already
+	// applied by pathDelayMiddleware, so handlers skip their own delay
+	delayAppliedKey ctxKey = "delayApplied"
+	// deadlineOverrideKey marks requests given their own write deadline by
+	// routeDeadlineMiddleware, which replaces REQUEST_TIMEOUT for them
+	deadlineOverrideKey ctxKey = "deadlineOverride"
+)
 
 type statusRecorder struct {
 	http.ResponseWriter
 	status int
+	// bytes written to the response body, after any compression
+	bytes int64
 }
 
 func (r *statusRecorder) WriteHeader(status int) {
@@ -44,13 +133,192 @@ func (r *statusRecorder) WriteHeader(status int) {
 	r.ResponseWriter.WriteHeader(status)
 }
 
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection to
+// flush and set deadlines.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logEntry is a request-scoped log record. The JSON tags document the field
+// names written to the log file, which the Vector pipeline depends on.
 type logEntry struct {
-	TraceID   string `json:"traceId"`
-	Method    string `json:"method"`
-	Path      string `json:"path"`
-	Status    int    `json:"status"`
-	LatencyMs int64  `json:"latencyMs"`
-	Message   string `json:"message"`
+	TraceID   string     `json:"traceId"`
+	Method    string     `json:"method"`
+	Path      string     `json:"path"`
+	Status    int        `json:"status"`
+	LatencyMs int64      `json:"latencyMs"`
+	Message   string     `json:"message"`
+	Level     slog.Level `json:"level"`
+	Slow      bool       `json:"slow,omitempty"`
+	// Cancelled and Timeout record why the request context ended early,
+	// if it did: the client went away, or a deadline passed
+	Cancelled bool `json:"cancelled,omitempty"`
+	Timeout   bool `json:"timeout,omitempty"`
+	// BodyReadMs is set when the handler read a request body
+	BodyReadMs   int64  `json:"bodyReadMs,omitempty"`
+	SlowBodyRead bool   `json:"slowBodyRead,omitempty"`
+	ReceivedAt   string `json:"receivedAt,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Stack        string `json:"stack,omitempty"`
+
+	// Headers is set when request header logging is enabled
+	Headers map[string]string `json:"headers,omitempty"`
+
+	UserAgent  string `json:"userAgent,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// ClientIP is the client's address, taken from X-Forwarded-For or
+	// X-Real-IP when TRUST_PROXY_HEADERS is set
+	ClientIP string `json:"clientIp,omitempty"`
+
+	// fields limits which of the access log fields above are written
+	// (LOG_FIELDS); nil writes them all
+	fields logFieldSet
+}
+
+// attrs returns the entry's fields, other than message and level, as typed
+// slog attributes.
+func (e logEntry) attrs() []slog.Attr {
+	var attrs []slog.Attr
+	if e.fields.has("trace") {
+		attrs = append(attrs, slog.String("traceId", e.TraceID))
+	}
+	if e.fields.has("method") {
+		attrs = append(attrs, slog.String("method", e.Method))
+	}
+	if e.fields.has("path") {
+		attrs = append(attrs, slog.String("path", e.Path))
+	}
+	if e.fields.has("status") {
+		attrs = append(attrs, slog.Int("status", e.Status))
+	}
+	if e.fields.has("latency") {
+		attrs = append(attrs, slog.Int64("latencyMs", e.LatencyMs))
+	}
+	if e.UserAgent != "" && e.fields.has("ua") {
+		attrs = append(attrs, slog.String("userAgent", e.UserAgent))
+	}
+	if e.RemoteAddr != "" && e.fields.has("remote") {
+		attrs = append(attrs, slog.String("remoteAddr", e.RemoteAddr))
+	}
+	if e.ClientIP != "" && e.fields.has("ip") {
+		attrs = append(attrs, slog.String("clientIp", e.ClientIP))
+	}
+	if e.Slow {
+		attrs = append(attrs, slog.Bool("slow", true))
+	}
+	if e.Cancelled {
+		attrs = append(attrs, slog.Bool("cancelled", true))
+	}
+	if e.Timeout {
+		attrs = append(attrs, slog.Bool("timeout", true))
+	}
+	if e.BodyReadMs > 0 {
+		attrs = append(attrs, slog.Int64("bodyReadMs", e.BodyReadMs))
+	}
+	if e.SlowBodyRead {
+		attrs = append(attrs, slog.Bool("slowBodyRead", true))
+	}
+	if len(e.Headers) > 0 {
+		names := make([]string, 0, len(e.Headers))
+		for name := range e.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		headers := make([]any, 0, len(names))
+		for _, name := range names {
+			headers = append(headers, slog.String(name, e.Headers[name]))
+		}
+		attrs = append(attrs, slog.Group("headers", headers...))
+	}
+	if e.ReceivedAt != "" {
+		attrs = append(attrs, slog.String("receivedAt", e.ReceivedAt))
+	}
+	if e.Error != "" {
+		attrs = append(attrs, slog.String("error", e.Error))
+	}
+	if e.Stack != "" {
+		attrs = append(attrs, slog.String("stack", e.Stack))
+	}
+	return attrs
+}
+
+// slowThresholds holds the latency above which a request is logged as slow.
+// Per-route values come from SLOW_MS_<ROUTE> env vars (e.g. SLOW_MS_HELLO for
+// /hello) and routes without one use the SLOW_MS default. bodyRead, from
+// SLOW_BODY_READ_MS, flags requests whose body took too long to read. Zero
+// disables.
+type slowThresholds struct {
+	byRoute  map[string]time.Duration
+	fallback time.Duration
+	bodyRead time.Duration
+}
+
+// routeKey converts a path to its env var suffix, e.g. "/debug/vars" -> "DEBUG_VARS".
+func routeKey(path string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(strings.TrimPrefix(path, "/")))
+}
+
+func (s slowThresholds) forPath(path string) time.Duration {
+	if d, ok := s.byRoute[routeKey(path)]; ok {
+		return d
+	}
+	return s.fallback
+}
+
+// loadSlowThresholds reads SLOW_MS, SLOW_MS_<ROUTE> and SLOW_BODY_READ_MS
+// entries from environ.
+func loadSlowThresholds(environ []string) (slowThresholds, error) {
+	thresholds := slowThresholds{byRoute: map[string]time.Duration{}}
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if value == "" || (key != "SLOW_MS" && key != "SLOW_BODY_READ_MS" && !strings.HasPrefix(key, "SLOW_MS_")) {
+			continue
+		}
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return slowThresholds{}, fmt.Errorf("invalid %s %q: must be a non-negative number of milliseconds", key, value)
+		}
+		switch d := time.Duration(ms) * time.Millisecond; key {
+		case "SLOW_MS":
+			thresholds.fallback = d
+		case "SLOW_BODY_READ_MS":
+			thresholds.bodyRead = d
+		default:
+			thresholds.byRoute[strings.TrimPrefix(key, "SLOW_MS_")] = d
+		}
+	}
+	return thresholds, nil
+}
+
+// timedBody wraps a request body to total the time spent blocked in Read,
+// which is how long a slow uploader held the handler up, and the bytes read.
+// The totals are atomic because a timed-out handler may still be reading
+// after the middleware returns.
+type timedBody struct {
+	io.ReadCloser
+	elapsed atomic.Int64 // nanoseconds
+	reads   atomic.Int64
+	bytes   atomic.Int64
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	b.elapsed.Add(int64(time.Since(start)))
+	b.reads.Add(1)
+	b.bytes.Add(int64(n))
+	return n, err
 }
 
 func ensureLogFile(path string) (*os.File, error) {
@@ -60,65 +328,663 @@ func ensureLogFile(path string) (*os.File, error) {
 	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
 }
 
-func newLogger(path string) (*log.Logger, *os.File, *log.Logger, error) {
-	f, err := ensureLogFile(path)
+// Formats accepted by LOG_FORMAT for the log file.
+const (
+	logFormatJSON   = "json"
+	logFormatLogfmt = "logfmt"
+)
+
+// fileHandlerOptions names fields the way the Vector pipeline expects:
+// "message" instead of slog's "msg", a lowercase "level", and no "time"
+// (Vector stamps @timestamp on ingest). Records below level are dropped.
+func fileHandlerOptions(level slog.Leveler) *slog.HandlerOptions {
+	return &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				return slog.Attr{}
+			case slog.MessageKey:
+				a.Key = "message"
+			case slog.LevelKey:
+				a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+			}
+			return a
+		},
+	}
+}
+
+// newFileHandler writes one JSON object per line, as described by
+// fileHandlerOptions.
+func newFileHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, fileHandlerOptions(level))
+}
+
+// newLogfmtFileHandler writes the same fields as newFileHandler as logfmt
+// key=value pairs, quoting values with spaces, quotes or other special
+// characters. Nested fields such as headers are flattened to headers.name.
+func newLogfmtFileHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewTextHandler(w, fileHandlerOptions(level))
+}
+
+// parseLogFormat checks a LOG_FORMAT value, defaulting to JSON.
+func parseLogFormat(s string) (string, error) {
+	switch s {
+	case "", logFormatJSON:
+		return logFormatJSON, nil
+	case logFormatLogfmt:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid log format %q (want json or logfmt)", s)
+}
+
+// fanoutHandler sends each record to every wrapped handler enabled for its level.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		out[i] = handler.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	out := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		out[i] = handler.WithGroup(name)
+	}
+	return out
+}
+
+// newLogger returns a logger writing human-readable text to stdout for docker
+// logs and lines in format (logFormatJSON or logFormatLogfmt) to the file at
+// path for Vector, dropping records below level. The file is rotated once it
+// reaches maxBytes, keeping maxBackups old files.
+func newLogger(path, format string, maxBytes int64, maxBackups int, level slog.Leveler) (*slog.Logger, *rotatingWriter, error) {
+	f, err := newRotatingWriter(path, maxBytes, maxBackups)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	fileHandler := newFileHandler(f, level)
+	if format == logFormatLogfmt {
+		fileHandler = newLogfmtFileHandler(f, level)
 	}
-	// Write to stdout with timestamp for docker logs, file without timestamp for Fluent Bit parsing
-	stdoutLogger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
-	fileLogger := log.New(f, "", 0) // No timestamp prefix for clean JSON
-	return stdoutLogger, f, fileLogger, nil
+	logger := slog.New(fanoutHandler{
+		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
+		fileHandler,
+	})
+	return logger, f, nil
+}
+
+// parseLogLevel parses a LOG_LEVEL value: debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// logEvent writes entry at its level with its fields as typed attributes.
+// Entries for 5xx responses are always raised to error level.
+func logEvent(logger *slog.Logger, entry logEntry) {
+	if entry.Status >= 500 && entry.Level < slog.LevelError {
+		entry.Level = slog.LevelError
+	}
+	logger.LogAttrs(context.Background(), entry.Level, entry.Message, entry.attrs()...)
+}
+
+// parseTraceparent extracts the trace-id from a W3C Trace Context traceparent
+// header ("00-<32 hex trace-id>-<16 hex parent-id>-<2 hex flags>"). It
+// reports false for a missing or malformed header.
+func parseTraceparent(h string) (string, bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) < 4 {
+		return "", false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	// Version 00 has exactly four fields; later versions may append more
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) || !isLowerHex(flags, 2) {
+		return "", false
+	}
+	return traceID, true
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// traceOptions configures what traceMiddleware logs.
+type traceOptions struct {
+	// slow flags requests that took too long
+	slow slowThresholds
+	// slowRequest, if set, logs a separate "slow request" warning for
+	// requests slower than it (SLOW_REQUEST_THRESHOLD)
+	slowRequest time.Duration
+	// logHeaders, when non-nil, selects request headers to log
+	logHeaders *headerFilter
+	// logStart adds a "request received" line when each request arrives
+	// (LOG_REQUEST_START), so time before the handler ran can be measured
+	logStart bool
+	// logFields selects the access log fields to write (LOG_FIELDS); nil
+	// writes them all
+	logFields logFieldSet
+	// trustProxy takes the logged client IP from X-Forwarded-For or
+	// X-Real-IP (TRUST_PROXY_HEADERS); only safe behind a proxy that sets
+	// them
+	trustProxy bool
+	// tracer, if set, records a span per request (OTEL_EXPORTER_OTLP_ENDPOINT)
+	tracer trace.Tracer
+	// routes, if set, keys metrics on the route pattern a request matches
+	// (e.g. /status/{code}) instead of its raw path, so arbitrary paths
+	// can't grow the metrics without bound
+	routes *http.ServeMux
+}
+
+// unmatchedRoute is the metrics key for requests that match no route.
+const unmatchedRoute = "other"
+
+// metricsKey returns the key r's metrics are recorded under.
+func (o traceOptions) metricsKey(r *http.Request) string {
+	if o.routes == nil {
+		return r.URL.Path
+	}
+	if _, pattern := o.routes.Handler(r); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
 }
 
-func traceMiddleware(stdoutLogger *log.Logger, fileLogger *log.Logger, next http.Handler) http.Handler {
+// traceMiddleware assigns each request a trace ID, records metrics and logs
+// its completion, as configured by opts.
+func traceMiddleware(logger *slog.Logger, opts traceOptions, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		traceID := r.Header.Get("X-Trace-Id")
+		traceID, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if !ok {
+			traceID = r.Header.Get("X-Trace-Id")
+		}
 		if traceID == "" {
 			traceID = uuid.NewString()
 		}
+		// Echo the chosen ID so callers can correlate with the logs
+		w.Header().Set("X-Trace-Id", traceID)
+		if opts.logStart {
+			logEvent(logger, logEntry{
+				TraceID:    traceID,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				ReceivedAt: start.UTC().Format(time.RFC3339Nano),
+				Message:    "request received",
+				fields:     opts.logFields,
+			})
+		}
+
+		if r.URL.Path != metricsPath {
+			inFlightRequests.Add(1)
+			// Deferred so the gauge is decremented even if the handler panics
+			defer inFlightRequests.Add(-1)
+
+			id := activeRequests.Add(inflightRequest{TraceID: traceID, Method: r.Method, Path: r.URL.Path, Start: start})
+			defer activeRequests.Remove(id)
+		}
 
+		route := opts.metricsKey(r)
 		ctx := context.WithValue(r.Context(), traceKey, traceID)
+		ctx, span := startSpan(ctx, opts.tracer, r, route)
 		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		var body *timedBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &timedBody{ReadCloser: r.Body}
+			r.Body = body
+		}
 
-		next.ServeHTTP(rec, r.WithContext(ctx))
+		next.ServeHTTP(rec, r)
 
 		latency := time.Since(start)
+		endSpan(span, rec.status, latency)
 
-		// Update metrics
-		metricsMutex.Lock()
-		requestCount++
-		if rec.status >= 400 {
-			errorCount++
-		}
-		totalLatencyMs += latency.Milliseconds()
-		metricsMutex.Unlock()
-
-		logJSON(stdoutLogger, fileLogger, logEntry{
-			TraceID:   traceID,
-			Method:    r.Method,
-			Path:      r.URL.Path,
-			Status:    rec.status,
-			LatencyMs: latency.Milliseconds(),
-			Message:   "request completed",
+		// Update metrics, skipping scrapes and resets so they don't pollute
+		// the numbers
+		if r.URL.Path != metricsPath && r.URL.Path != metricsResetPath {
+			recordRequest(route, rec.status, latency)
+			otelMetrics.Load().record(ctx, route, rec.status, float64(latency)/float64(time.Millisecond))
+			promMetrics.Load().record(route, rec.status, float64(latency)/float64(time.Millisecond))
+			var requestBytes int64
+			if body != nil {
+				requestBytes = body.bytes.Load()
+			}
+			recordBytes(route, requestBytes, rec.bytes)
+			promMetrics.Load().recordBytes(route, requestBytes, rec.bytes)
+		}
+
+		entry := logEntry{
+			TraceID:    traceID,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rec.status,
+			LatencyMs:  latency.Milliseconds(),
+			Message:    "request completed",
+			UserAgent:  r.UserAgent(),
+			RemoteAddr: r.RemoteAddr,
+			ClientIP:   clientIP(r, opts.trustProxy),
+			fields:     opts.logFields,
+		}
+		if opts.logHeaders != nil {
+			entry.Headers = opts.logHeaders.filter(r.Header)
+		}
+		switch r.Context().Err() {
+		case context.Canceled:
+			entry.Cancelled = true
+		case context.DeadlineExceeded:
+			entry.Timeout = true
+		}
+		if threshold := opts.slow.forPath(r.URL.Path); threshold > 0 && latency > threshold {
+			entry.Level = slog.LevelWarn
+			entry.Slow = true
+		}
+		if body != nil && body.reads.Load() > 0 {
+			readTime := time.Duration(body.elapsed.Load())
+			entry.BodyReadMs = readTime.Milliseconds()
+			if opts.slow.bodyRead > 0 && readTime > opts.slow.bodyRead {
+				entry.Level = slog.LevelWarn
+				entry.SlowBodyRead = true
+			}
+			if r.URL.Path != metricsPath {
+				recordBodyRead(route, readTime, entry.SlowBodyRead)
+			}
+		}
+		if opts.slowRequest > 0 && latency > opts.slowRequest {
+			// Logged first so Vector folds it into the trace before
+			// "request completed" flushes it
+			logEvent(logger, logEntry{
+				TraceID:   traceID,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    rec.status,
+				LatencyMs: latency.Milliseconds(),
+				Level:     slog.LevelWarn,
+				Slow:      true,
+				Message:   "slow request",
+				fields:    opts.logFields,
+			})
+		}
+		logEvent(logger, entry)
+	})
+}
+
+// rateLimitMiddleware enforces a global request rate with limiter, answering
+// 429 with a Retry-After header once the bucket is empty. Every limited
+// response also carries the draft IETF RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers so clients can slow down before being
+// rejected. Health, readiness and metrics requests are never limited so
+// probes and scrapes keep working. It must run inside traceMiddleware so
+// rejections are counted as errors.
+func rateLimitMiddleware(limiter *rate.Limiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health", "/readyz", metricsPath:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		res := limiter.ReserveN(now, 1)
+		delay := res.DelayFrom(now)
+		if delay > 0 {
+			res.CancelAt(now)
+		}
+		setRateLimitHeaders(w.Header(), limiter, now)
+		if delay == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		traceID, _ := r.Context().Value(traceKey).(string)
+		retryAfter := 1
+		if res.OK() {
+			retryAfter = max(int(math.Ceil(delay.Seconds())), 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "rate limit exceeded",
+			"traceId": traceID,
 		})
 	})
 }
 
-func logJSON(stdoutLogger *log.Logger, fileLogger *log.Logger, entry logEntry) {
-	b, err := json.Marshal(entry)
-	if err != nil {
-		stdoutLogger.Printf(`{"message":"failed to marshal log","error":"%v"}\n`, err)
-		fileLogger.Printf(`{"message":"failed to marshal log","error":"%v"}\n`, err)
-		return
+// setRateLimitHeaders describes the bucket's state at now: its size, the
+// whole tokens left, and the seconds until it is full again.
+func setRateLimitHeaders(h http.Header, limiter *rate.Limiter, now time.Time) {
+	burst := limiter.Burst()
+	tokens := math.Max(limiter.TokensAt(now), 0)
+	reset := math.Ceil((float64(burst) - tokens) / float64(limiter.Limit()))
+	h.Set("RateLimit-Limit", strconv.Itoa(burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+}
+
+// newRateLimiter builds the limiter for RATE_LIMIT_RPS and RATE_LIMIT_BURST.
+// An empty or zero rps disables limiting; the burst defaults to one second's
+// worth of requests.
+func newRateLimiter(rps, burst string) (*rate.Limiter, error) {
+	if rps == "" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseFloat(rps, 64)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_RPS %q", rps)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	b := int(math.Ceil(limit))
+	if burst != "" {
+		if b, err = strconv.Atoi(burst); err != nil || b < 1 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_BURST %q", burst)
+		}
+	}
+	return rate.NewLimiter(rate.Limit(limit), b), nil
+}
+
+// recoverMiddleware turns a handler panic into a logged 500 response. It must
+// run inside traceMiddleware so the trace ID is available and the 500 is
+// counted in metrics.
+func recoverMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate abort; let net/http handle it silently
+				panic(p)
+			}
+
+			traceID, _ := r.Context().Value(traceKey).(string)
+			logEvent(logger, logEntry{
+				TraceID: traceID,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Status:  http.StatusInternalServerError,
+				Message: "panic recovered",
+				Level:   slog.LevelError,
+				Error:   fmt.Sprint(p),
+				Stack:   string(debug.Stack()),
+			})
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   "internal server error",
+				"traceId": traceID,
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutJSONWriter marks http.TimeoutHandler's 503 body as JSON. Once the
+// handler has finished, TimeoutHandler is replaying the handler's own response
+// and the headers are left alone. TimeoutHandler also answers 503 when the
+// client goes away; that is rewritten to 499 so it isn't mistaken for a
+// timeout.
+type timeoutJSONWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	finished *atomic.Bool
+}
+
+func (w timeoutJSONWriter) WriteHeader(status int) {
+	switch {
+	case status == http.StatusServiceUnavailable && w.ctx.Err() == context.Canceled:
+		// Even if the handler finished, TimeoutHandler may have seen the
+		// cancellation first
+		status = statusClientClosedRequest
+	case !w.finished.Load():
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// timeoutMiddleware bounds how long next may take to respond. Requests that
+// exceed d get a 503 JSON body with the trace ID, and requests the client
+// abandons first get a bare 499; either way the handler keeps running in the
+// background but its output is discarded. A non-positive d disables it.
+func timeoutMiddleware(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if override, _ := r.Context().Value(deadlineOverrideKey).(bool); override {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		traceID, _ := r.Context().Value(traceKey).(string)
+		body, _ := json.Marshal(map[string]string{
+			"error":   "request timed out",
+			"traceId": traceID,
+		})
+
+		var finished atomic.Bool
+		inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			finished.Store(true)
+		})
+		http.TimeoutHandler(inner, d, string(body)).ServeHTTP(timeoutJSONWriter{ResponseWriter: w, ctx: r.Context(), finished: &finished}, r)
+	})
+}
+
+// prefixDurations maps a path prefix to a duration, as configured by
+// PATH_DELAYS and ROUTE_WRITE_TIMEOUTS.
+type prefixDurations map[string]time.Duration
+
+// parsePrefixDurations parses a JSON object of prefix to duration string,
+// e.g. {"/slow": "200ms"}.
+func parsePrefixDurations(s string) (prefixDurations, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	durations := make(prefixDurations, len(raw))
+	for prefix, value := range raw {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q for prefix %q", value, prefix)
+		}
+		durations[prefix] = d
+	}
+	return durations, nil
+}
+
+// match returns the delay for the longest prefix of path.
+func (p prefixDurations) match(path string) (time.Duration, bool) {
+	var best string
+	var found bool
+	for prefix := range p {
+		if strings.HasPrefix(path, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	return p[best], found
+}
+
+// pathDelayMiddleware sleeps for the delay configured for the request's path
+// prefix before calling next, giving up early if the client goes away.
+// Matched requests replace the handler's own simulated delay.
+func pathDelayMiddleware(delays prefixDurations, next http.Handler) http.Handler {
+	if len(delays) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := delays.match(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), delayAppliedKey, true)))
+	})
+}
+
+// routeDeadlineMiddleware extends the server-wide WriteTimeout for routes that
+// hold their connection open, such as /stream, by setting a per-request write
+// deadline. Matched requests also skip REQUEST_TIMEOUT, whose buffering would
+// defeat streaming.
+func routeDeadlineMiddleware(timeouts prefixDurations, next http.Handler) http.Handler {
+	if len(timeouts) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := timeouts.match(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d)); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deadlineOverrideKey, true)))
+	})
+}
+
+func recordRequest(path string, status int, latency time.Duration) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	stats, ok := endpointMetrics[path]
+	if !ok {
+		stats = &endpointStats{bucketCounts: make([]int64, len(latencyBuckets))}
+		endpointMetrics[path] = stats
+	}
+	stats.requestCount++
+	if status >= 400 {
+		stats.errorCount++
+	}
+	latencyMs := float64(latency) / float64(time.Millisecond)
+	stats.latencyMsSum += latencyMs
+	for i, bound := range latencyBuckets {
+		if latencyMs <= bound {
+			stats.bucketCounts[i]++
+		}
+	}
+}
+
+// recordBodyRead adds the time a request spent reading its body to the
+// path's body read metrics.
+func recordBodyRead(path string, d time.Duration, slow bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	stats, ok := endpointMetrics[path]
+	if !ok {
+		stats = &endpointStats{bucketCounts: make([]int64, len(latencyBuckets))}
+		endpointMetrics[path] = stats
 	}
-	// Write to stdout with timestamp, file without timestamp (pure JSON)
-	stdoutLogger.Println(string(b))
-	fileLogger.Printf("%s\n", string(b))
+	stats.bodyReadCount++
+	stats.bodyReadMsSum += float64(d) / float64(time.Millisecond)
+	if slow {
+		stats.slowBodyReads++
+	}
+}
+
+// recordBytes adds a request's body bytes read and response bytes written
+// to the path's byte counters.
+func recordBytes(path string, read, written int64) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	stats, ok := endpointMetrics[path]
+	if !ok {
+		stats = &endpointStats{bucketCounts: make([]int64, len(latencyBuckets))}
+		endpointMetrics[path] = stats
+	}
+	stats.requestBytes += read
+	stats.responseBytes += written
+}
+
+// parseLatencyBuckets parses a comma-separated list of strictly increasing,
+// positive bucket boundaries in milliseconds (e.g. "5,10,25").
+func parseLatencyBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", field, err)
+		}
+		if bound <= 0 {
+			return nil, fmt.Errorf("bucket %v must be positive", bound)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be strictly increasing, got %v after %v", bound, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
 }
 
-func handleHello(stdoutLogger *log.Logger, fileLogger *log.Logger) http.HandlerFunc {
+// handleHello simulates work by waiting delay (HELLO_DELAY, reloaded on
+// SIGHUP) before replying. A ?delay= query parameter overrides it for a
+// single call; otherwise a path delay from PATH_DELAYS replaces it. The
+// reply's status is drawn from statuses (STATUS_DISTRIBUTION), with error
+// statuses getting a JSON error body instead of the greeting. The greeting is
+// padded with a "data" field of payloadSize bytes (HELLO_PAYLOAD_SIZE), or
+// ?size= for a single call, up to maxHelloPayloadSize.
+func handleHello(logger *slog.Logger, delay *durationVar, statuses *statusDistribution, payloadSize int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		traceID, _ := r.Context().Value(traceKey).(string)
 		resp := map[string]string{
@@ -126,35 +992,190 @@ func handleHello(stdoutLogger *log.Logger, fileLogger *log.Logger) http.HandlerF
 			"traceId": traceID,
 			"path":    r.URL.Path,
 		}
-		time.Sleep(50 * time.Millisecond) // simulate work
+		wait := delay.Load()
+		if applied, _ := r.Context().Value(delayAppliedKey).(bool); applied {
+			wait = 0
+		}
+		if v := r.URL.Query().Get("delay"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":   fmt.Sprintf("invalid delay %q: must be a non-negative duration", v),
+					"traceId": traceID,
+				})
+				return
+			}
+			wait = d
+		}
+		size := payloadSize
+		if v := r.URL.Query().Get("size"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxHelloPayloadSize {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":   fmt.Sprintf("invalid size %q: must be between 0 and %d bytes", v, maxHelloPayloadSize),
+					"traceId": traceID,
+				})
+				return
+			}
+			size = n
+		}
+		if size > 0 {
+			resp["data"] = strings.Repeat("x", size)
+		}
 
+		// Simulate work, giving up if the client goes away
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			entry := logEntry{
+				TraceID: traceID,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Message: "handler abandoned",
+				Level:   slog.LevelDebug,
+			}
+			// A passed deadline is answered by timeoutMiddleware
+			if r.Context().Err() == context.Canceled {
+				w.WriteHeader(statusClientClosedRequest)
+				entry.Status, entry.Cancelled = statusClientClosedRequest, true
+			} else {
+				entry.Timeout = true
+			}
+			logEvent(logger, entry)
+			return
+		}
+
+		status := statuses.Pick()
+		if status >= 400 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   http.StatusText(status),
+				"traceId": traceID,
+			})
+			logEvent(logger, logEntry{
+				TraceID: traceID,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Status:  status,
+				Message: "handler finished",
+				Level:   slog.LevelDebug,
+			})
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			logJSON(stdoutLogger, fileLogger, logEntry{
+			logEvent(logger, logEntry{
 				TraceID: traceID,
 				Method:  r.Method,
 				Path:    r.URL.Path,
 				Status:  http.StatusInternalServerError,
 				Message: "failed to encode response",
+				Level:   slog.LevelError,
 			})
 			return
 		}
 
-		logJSON(stdoutLogger, fileLogger, logEntry{
+		logEvent(logger, logEntry{
 			TraceID: traceID,
 			Method:  r.Method,
 			Path:    r.URL.Path,
-			Status:  http.StatusOK,
+			Status:  status,
 			Message: "handler finished",
+			Level:   slog.LevelDebug,
 		})
 	}
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
+// handleStream streams ?n= (default 10) newline-delimited JSON events, one
+// per interval, flushing each as it is written. It stops early if the client
+// goes away.
+func handleStream(interval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID, _ := r.Context().Value(traceKey).(string)
+		n := 10
+		if v := r.URL.Query().Get("n"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				http.Error(w, "invalid n", http.StatusBadRequest)
+				return
+			}
+			n = parsed
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for seq := 1; seq <= n; seq++ {
+			if err := enc.Encode(map[string]any{"seq": seq, "traceId": traceID}); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			if seq == n {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// handleHealth is the liveness check. With ?deep=true it also probes that the
+// log file is still writable and reports "degraded" with a 503, naming the
+// failed subsystem, if not.
+func handleHealth(logFile *rotatingWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{
+			"status":  "healthy",
+			"service": "prr-playground-server",
+		}
+		status := http.StatusOK
+		if r.URL.Query().Get("deep") == "true" {
+			if err := logFile.probe(); err != nil {
+				status = http.StatusServiceUnavailable
+				body["status"] = "degraded"
+				body["failed"] = "logFile"
+				body["error"] = err.Error()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// handleReadyz reports whether the server should receive traffic. Unlike
+// /health (liveness) it returns 503 before startup completes and while
+// draining for shutdown.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status, code := "ready", http.StatusOK
+	if !ready.Load() {
+		status, code = "not ready", http.StatusServiceUnavailable
+		if draining.Load() {
+			status = "draining"
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
+		"status":  status,
 		"service": "prr-playground-server",
 	})
 }
@@ -163,21 +1184,182 @@ func handleMetrics(w http.ResponseWriter, r *http.Request) {
 	metricsMutex.RLock()
 	defer metricsMutex.RUnlock()
 
-	var avgLatencyMs int64
-	if requestCount > 0 {
-		avgLatencyMs = totalLatencyMs / requestCount
+	paths := make([]string, 0, len(endpointMetrics))
+	for path := range endpointMetrics {
+		paths = append(paths, path)
 	}
+	sort.Strings(paths)
 
 	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "# HELP http_requests_in_flight Number of HTTP requests currently being served\n")
+	fmt.Fprintf(w, "# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", inFlightRequests.Load())
+	fmt.Fprintf(w, "# HELP build_info Build metadata of the running server, always 1\n")
+	fmt.Fprintf(w, "# TYPE build_info gauge\n")
+	fmt.Fprintf(w, "build_info{version=\"%s\",commit=\"%s\",go_version=\"%s\"} 1\n",
+		labelEscaper.Replace(version), labelEscaper.Replace(commit), labelEscaper.Replace(runtime.Version()))
+	fmt.Fprintf(w, "# HELP process_uptime_seconds Seconds since the server process started\n")
+	fmt.Fprintf(w, "# TYPE process_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "process_uptime_seconds %.3f\n", time.Since(startTime).Seconds())
 	fmt.Fprintf(w, "# HELP http_requests_total Total number of HTTP requests\n")
 	fmt.Fprintf(w, "# TYPE http_requests_total counter\n")
-	fmt.Fprintf(w, "http_requests_total %d\n", requestCount)
+	for _, path := range paths {
+		fmt.Fprintf(w, "http_requests_total{path=\"%s\"} %d\n", labelEscaper.Replace(path), endpointMetrics[path].requestCount)
+	}
 	fmt.Fprintf(w, "# HELP http_errors_total Total number of HTTP errors (4xx, 5xx)\n")
 	fmt.Fprintf(w, "# TYPE http_errors_total counter\n")
-	fmt.Fprintf(w, "http_errors_total %d\n", errorCount)
-	fmt.Fprintf(w, "# HELP http_request_duration_ms Average request latency in milliseconds\n")
-	fmt.Fprintf(w, "# TYPE http_request_duration_ms gauge\n")
-	fmt.Fprintf(w, "http_request_duration_ms %d\n", avgLatencyMs)
+	for _, path := range paths {
+		fmt.Fprintf(w, "http_errors_total{path=\"%s\"} %d\n", labelEscaper.Replace(path), endpointMetrics[path].errorCount)
+	}
+	fmt.Fprintf(w, "# HELP http_request_bytes_total Total bytes read from request bodies\n")
+	fmt.Fprintf(w, "# TYPE http_request_bytes_total counter\n")
+	for _, path := range paths {
+		fmt.Fprintf(w, "http_request_bytes_total{path=\"%s\"} %d\n", labelEscaper.Replace(path), endpointMetrics[path].requestBytes)
+	}
+	fmt.Fprintf(w, "# HELP http_response_bytes_total Total bytes written to response bodies\n")
+	fmt.Fprintf(w, "# TYPE http_response_bytes_total counter\n")
+	for _, path := range paths {
+		fmt.Fprintf(w, "http_response_bytes_total{path=\"%s\"} %d\n", labelEscaper.Replace(path), endpointMetrics[path].responseBytes)
+	}
+	fmt.Fprintf(w, "# HELP http_request_duration_ms Request latency in milliseconds\n")
+	fmt.Fprintf(w, "# TYPE http_request_duration_ms histogram\n")
+	for _, path := range paths {
+		stats := endpointMetrics[path]
+		label := labelEscaper.Replace(path)
+		for i, bound := range latencyBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "http_request_duration_ms_bucket{path=\"%s\",le=\"%s\"} %d\n", label, le, stats.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_ms_bucket{path=\"%s\",le=\"+Inf\"} %d\n", label, stats.requestCount)
+		fmt.Fprintf(w, "http_request_duration_ms_sum{path=\"%s\"} %g\n", label, stats.latencyMsSum)
+		fmt.Fprintf(w, "http_request_duration_ms_count{path=\"%s\"} %d\n", label, stats.requestCount)
+	}
+	// Kept for dashboards built on the old average gauge
+	fmt.Fprintf(w, "# HELP http_request_duration_avg_ms Average request latency in milliseconds\n")
+	fmt.Fprintf(w, "# TYPE http_request_duration_avg_ms gauge\n")
+	for _, path := range paths {
+		stats := endpointMetrics[path]
+		var avgLatencyMs int64
+		if stats.requestCount > 0 {
+			avgLatencyMs = int64(stats.latencyMsSum / float64(stats.requestCount))
+		}
+		fmt.Fprintf(w, "http_request_duration_avg_ms{path=\"%s\"} %d\n", labelEscaper.Replace(path), avgLatencyMs)
+	}
+	fmt.Fprintf(w, "# HELP http_request_body_read_ms Time spent reading request bodies in milliseconds\n")
+	fmt.Fprintf(w, "# TYPE http_request_body_read_ms summary\n")
+	for _, path := range paths {
+		stats := endpointMetrics[path]
+		if stats.bodyReadCount == 0 {
+			continue
+		}
+		label := labelEscaper.Replace(path)
+		fmt.Fprintf(w, "http_request_body_read_ms_sum{path=\"%s\"} %.3f\n", label, stats.bodyReadMsSum)
+		fmt.Fprintf(w, "http_request_body_read_ms_count{path=\"%s\"} %d\n", label, stats.bodyReadCount)
+	}
+	fmt.Fprintf(w, "# HELP http_slow_body_reads_total Requests whose body read exceeded SLOW_BODY_READ_MS\n")
+	fmt.Fprintf(w, "# TYPE http_slow_body_reads_total counter\n")
+	for _, path := range paths {
+		if stats := endpointMetrics[path]; stats.bodyReadCount > 0 {
+			fmt.Fprintf(w, "http_slow_body_reads_total{path=\"%s\"} %d\n", labelEscaper.Replace(path), stats.slowBodyReads)
+		}
+	}
+}
+
+// listenAddr returns the address to listen on: BIND_ADDR (bindAddr) as-is
+// when set, so the server can be limited to one interface, else ":" + port.
+func listenAddr(bindAddr, port string) (string, error) {
+	if bindAddr == "" {
+		return ":" + port, nil
+	}
+	host, p, err := net.SplitHostPort(bindAddr)
+	if err != nil {
+		return "", err
+	}
+	if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", p)
+	}
+	if strings.ContainsAny(host, " /") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return bindAddr, nil
+}
+
+// serve accepts connections on ln, over TLS when certFile and keyFile are set
+// and plaintext HTTP otherwise. Either way it returns http.ErrServerClosed
+// after Shutdown, so graceful shutdown is the same in both modes.
+func serve(server *http.Server, ln net.Listener, certFile, keyFile string) error {
+	if certFile != "" && keyFile != "" {
+		return server.ServeTLS(ln, certFile, keyFile)
+	}
+	return server.Serve(ln)
+}
+
+// shutdownServer drains server until ctx expires, then forces it closed. It
+// logs how many requests were in flight when the drain began and how many
+// were still running if it had to give up, to help tune SHUTDOWN_TIMEOUT.
+func shutdownServer(ctx context.Context, logger *slog.Logger, server *http.Server) {
+	inFlight := inFlightRequests.Load()
+	logger.Info("draining requests", "in_flight", inFlight)
+
+	if err := server.Shutdown(ctx); err != nil {
+		remaining := inFlightRequests.Load()
+		logger.Error("server shutdown error", "error", err, "in_flight", remaining, "drained", max(inFlight-remaining, 0))
+		// Force close if graceful shutdown fails
+		server.Close()
+		return
+	}
+	logger.Info("server shutdown gracefully", "drained", inFlight)
+}
+
+// middlewareOptions configures the middleware newHandler wraps around the mux.
+type middlewareOptions struct {
+	maxBodyBytes   int64
+	limiter        *rate.Limiter
+	concurrency    *concurrencyLimiter
+	priorities     priorityPaths
+	writeTimeouts  prefixDurations
+	requestTimeout time.Duration
+	delays         prefixDurations
+}
+
+// newHandler wraps mux in the server's middleware chain, outermost first,
+// keying metrics on the route patterns registered on mux.
+func newHandler(logger *slog.Logger, traceOpts traceOptions, opts middlewareOptions, mux *http.ServeMux) http.Handler {
+	traceOpts.routes = mux
+	return traceMiddleware(logger, traceOpts,
+		recoverMiddleware(logger,
+			maxBodyMiddleware(opts.maxBodyBytes,
+				rateLimitMiddleware(opts.limiter,
+					concurrencyMiddleware(opts.concurrency, opts.priorities,
+						gzipMiddleware(
+							routeDeadlineMiddleware(opts.writeTimeouts,
+								timeoutMiddleware(opts.requestTimeout,
+									pathDelayMiddleware(opts.delays, mux)))))))))
+}
+
+// registerPprof adds the net/http/pprof handlers under /debug/pprof/ when
+// ENABLE_PPROF=true. They are wired onto mux by hand because the pprof
+// package only registers itself on http.DefaultServeMux, which we don't serve.
+func registerPprof(mux *http.ServeMux) bool {
+	if os.Getenv("ENABLE_PPROF") != "true" {
+		return false
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return true
+}
+
+// getDurationEnvOrDefault parses the duration in key, falling back to
+// defaultValue if it is unset or invalid.
+func getDurationEnvOrDefault(key string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(getEnvOrDefault(key, defaultValue.String()))
+	if err != nil {
+		return defaultValue
+	}
+	return d
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -188,84 +1370,244 @@ func getEnvOrDefault(key, defaultValue string) string {
 }
 
 func main() {
-	// Configuration from environment variables
-	logPath := getEnvOrDefault("LOG_PATH", defaultLogPath)
-	port := getEnvOrDefault("PORT", defaultPort)
-	shutdownTimeoutStr := getEnvOrDefault("SHUTDOWN_TIMEOUT", defaultShutdownTimeout.String())
-	shutdownTimeout, err := time.ParseDuration(shutdownTimeoutStr)
+	startTime = time.Now()
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file; environment variables override its values")
+	flag.Parse()
+	cfg, err := loadConfig(*configPath, os.Getenv)
 	if err != nil {
-		shutdownTimeout = defaultShutdownTimeout
+		log.Fatalf("invalid configuration: %v", err)
 	}
 
-	stdoutLogger, file, fileLogger, err := newLogger(logPath)
+	// The rest of the configuration comes from environment variables only
+	addr, err := listenAddr(os.Getenv("BIND_ADDR"), cfg.port)
+	if err != nil {
+		log.Fatalf("invalid BIND_ADDR %q (want host:port, e.g. 127.0.0.1:8080): %v", os.Getenv("BIND_ADDR"), err)
+	}
+	if v := os.Getenv("LATENCY_BUCKETS"); v != "" {
+		buckets, err := parseLatencyBuckets(v)
+		if err != nil {
+			log.Fatalf("invalid LATENCY_BUCKETS: %v", err)
+		}
+		latencyBuckets = buckets
+	}
+	writeTimeouts, err := parsePrefixDurations(getEnvOrDefault("ROUTE_WRITE_TIMEOUTS", defaultRouteWriteTimeouts))
+	if err != nil {
+		log.Fatalf("invalid ROUTE_WRITE_TIMEOUTS: %v", err)
+	}
+	var delays prefixDurations
+	if v := os.Getenv("PATH_DELAYS"); v != "" {
+		if delays, err = parsePrefixDurations(v); err != nil {
+			log.Fatalf("invalid PATH_DELAYS: %v", err)
+		}
+	}
+	limiter, err := newRateLimiter(os.Getenv("RATE_LIMIT_RPS"), os.Getenv("RATE_LIMIT_BURST"))
+	if err != nil {
+		log.Fatalf("invalid rate limit: %v", err)
+	}
+
+	maxBodyBytes, err := strconv.ParseInt(getEnvOrDefault("MAX_BODY_BYTES", "0"), 10, 64)
+	if err != nil || maxBodyBytes < 0 {
+		log.Fatalf("invalid MAX_BODY_BYTES: %q", os.Getenv("MAX_BODY_BYTES"))
+	}
+	maxConcurrent, err := strconv.Atoi(getEnvOrDefault("MAX_CONCURRENT", "0"))
+	if err != nil || maxConcurrent < 0 {
+		log.Fatalf("invalid MAX_CONCURRENT: %q", os.Getenv("MAX_CONCURRENT"))
+	}
+	reservedSlots, err := strconv.Atoi(getEnvOrDefault("PRIORITY_RESERVED", "0"))
+	if err != nil || reservedSlots < 0 {
+		log.Fatalf("invalid PRIORITY_RESERVED: %q", os.Getenv("PRIORITY_RESERVED"))
+	}
+	concurrency := newConcurrencyLimiter(maxConcurrent, reservedSlots)
+	var priorities priorityPaths
+	if v := os.Getenv("PRIORITY_PATHS"); v != "" {
+		priorities, err = parsePriorityPaths(v)
+		if err != nil {
+			log.Fatalf("invalid PRIORITY_PATHS: %v", err)
+		}
+	}
+	slow, err := loadSlowThresholds(os.Environ())
+	if err != nil {
+		log.Fatalf("invalid slow request thresholds: %v", err)
+	}
+	var logHeaders *headerFilter
+	if getEnvOrDefault("LOG_HEADERS", "false") == "true" {
+		logHeaders = newHeaderFilter(os.Getenv("LOG_HEADERS_ALLOW"), getEnvOrDefault("LOG_HEADERS_REDACT", defaultRedactHeaders))
+	}
+
+	logMaxBytes, err := strconv.ParseInt(getEnvOrDefault("LOG_MAX_BYTES", strconv.Itoa(defaultLogMaxBytes)), 10, 64)
+	if err != nil || logMaxBytes < 0 {
+		logMaxBytes = defaultLogMaxBytes
+	}
+	logMaxBackups, err := strconv.Atoi(getEnvOrDefault("LOG_MAX_BACKUPS", strconv.Itoa(defaultLogMaxBackups)))
+	if err != nil || logMaxBackups < 0 {
+		logMaxBackups = defaultLogMaxBackups
+	}
+
+	// The log level and hello delay are re-read from the config file and
+	// environment on SIGHUP
+	reloadable := &reloadableConfig{path: *configPath}
+	reloadable.apply(cfg)
+
+	logFormat, err := parseLogFormat(os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	logger, file, err := newLogger(cfg.logPath, logFormat, logMaxBytes, logMaxBackups, &reloadable.logLevel)
 	if err != nil {
 		log.Fatalf("cannot init logger: %v", err)
 	}
 	defer func() {
 		// Ensure file is synced and closed on exit
 		if err := file.Sync(); err != nil {
-			stdoutLogger.Printf(`{"message":"failed to sync log file","error":"%v"}`, err)
+			logger.Error("failed to sync log file", "error", err)
 		}
 		if err := file.Close(); err != nil {
-			stdoutLogger.Printf(`{"message":"failed to close log file","error":"%v"}`, err)
+			logger.Error("failed to close log file", "error", err)
+		}
+	}()
+
+	tracer, shutdownTelemetry, err := initTelemetry(context.Background())
+	if err != nil {
+		log.Fatalf("cannot init telemetry: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTelemetry(ctx); err != nil {
+			logger.Error("failed to flush telemetry", "error", err)
 		}
 	}()
 
 	mux := http.NewServeMux()
-	mux.Handle("/hello", handleHello(stdoutLogger, fileLogger))
-	mux.HandleFunc("/health", handleHealth)
-	mux.HandleFunc("/metrics", handleMetrics)
+	var statuses *statusDistribution
+	if spec := os.Getenv("STATUS_DISTRIBUTION"); spec != "" {
+		seed, err := strconv.ParseUint(getEnvOrDefault("STATUS_SEED", "1"), 10, 64)
+		if err != nil {
+			log.Fatalf("invalid STATUS_SEED: %q", os.Getenv("STATUS_SEED"))
+		}
+		statuses, err = parseStatusDistribution(spec, seed)
+		if err != nil {
+			log.Fatalf("invalid STATUS_DISTRIBUTION: %v", err)
+		}
+	}
+	apiKey := os.Getenv("API_KEY")
+	if apiKey == "" {
+		logger.Warn("API_KEY not set, /hello is open to unauthenticated requests")
+	}
+	payloadSize, err := strconv.Atoi(getEnvOrDefault("HELLO_PAYLOAD_SIZE", "0"))
+	if err != nil || payloadSize < 0 || payloadSize > maxHelloPayloadSize {
+		log.Fatalf("invalid HELLO_PAYLOAD_SIZE %q: must be between 0 and %d bytes", os.Getenv("HELLO_PAYLOAD_SIZE"), maxHelloPayloadSize)
+	}
+	mux.Handle("/hello", authMiddleware(apiKey, handleHello(logger, &reloadable.helloDelay, statuses, payloadSize)))
+	mux.Handle("/stream", handleStream(streamInterval))
+	mux.Handle("/health", handleHealth(file))
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.Handle("/status/{code}", handleStatus(logger))
+	mux.Handle("/flaky", newFlakyHandler(logger, rand.Uint64()))
+	if getEnvOrDefault("METRICS_CLIENT_GOLANG", "false") == "true" {
+		instruments := newPromInstruments()
+		promMetrics.Store(instruments)
+		mux.Handle(metricsPath, instruments.handler())
+	} else {
+		mux.HandleFunc(metricsPath, handleMetrics)
+	}
+	if getEnvOrDefault("ENABLE_ADMIN", "false") == "true" {
+		mux.Handle("/admin/concurrency", handleConcurrency(concurrency))
+		mux.Handle("/debug/inflight", handleInflight(activeRequests))
+	}
+	if registerMetricsReset(mux) {
+		logger.Warn("metrics reset enabled", "path", metricsResetPath)
+	}
+	if registerPprof(mux) {
+		logger.Warn("pprof enabled", "path", "/debug/pprof/")
+	}
 
-	handler := traceMiddleware(stdoutLogger, fileLogger, mux)
+	logFields, unknownFields := parseLogFields(os.Getenv("LOG_FIELDS"))
+	if len(unknownFields) > 0 {
+		logger.Warn("ignoring unknown LOG_FIELDS", "fields", strings.Join(unknownFields, ","))
+	}
+	traceOpts := traceOptions{
+		slow:        slow,
+		slowRequest: getDurationEnvOrDefault("SLOW_REQUEST_THRESHOLD", 0),
+		logHeaders:  logHeaders,
+		logStart:    getEnvOrDefault("LOG_REQUEST_START", "false") == "true",
+		logFields:   logFields,
+		trustProxy:  getEnvOrDefault("TRUST_PROXY_HEADERS", "false") == "true",
+		tracer:      tracer,
+	}
+	handler := newHandler(logger, traceOpts, middlewareOptions{
+		maxBodyBytes:   maxBodyBytes,
+		limiter:        limiter,
+		concurrency:    concurrency,
+		priorities:     priorities,
+		writeTimeouts:  writeTimeouts,
+		requestTimeout: cfg.requestTimeout,
+		delays:         delays,
+	}, mux)
 
 	server := &http.Server{
-		Addr:         ":" + port,
+		Addr:         addr,
 		Handler:      handler,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
+		ReadTimeout:  cfg.readTimeout,
+		WriteTimeout: cfg.writeTimeout,
+		IdleTimeout:  cfg.idleTimeout,
+	}
+
+	tlsCert, tlsKey := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (tlsCert == "") != (tlsKey == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
 	// Channel to listen for interrupt signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go watchReload(hupChan, reloadable, logger)
+
+	// Bind before reporting ready so /readyz never claims readiness early
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logger.Error("server error", "error", err)
+		os.Exit(1)
+	}
 
 	// Start server in a goroutine
 	serverErrChan := make(chan error, 1)
 	go func() {
-		stdoutLogger.Printf(`{"message":"server starting","addr":":%s"}`, port)
-		fileLogger.Printf(`{"message":"server starting","addr":":%s"}\n`, port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		mode := "http"
+		if tlsCert != "" {
+			mode = "https"
+		}
+		logger.Info("server starting", "addr", server.Addr, "mode", mode)
+		if err := serve(server, ln, tlsCert, tlsKey); err != nil && err != http.ErrServerClosed {
 			serverErrChan <- err
 		}
 	}()
+	ready.Store(true)
 
 	// Wait for interrupt signal or server error
 	select {
 	case err := <-serverErrChan:
-		stdoutLogger.Fatalf(`{"message":"server error","error":"%v"}`, err)
+		logger.Error("server error", "error", err)
+		os.Exit(1)
 	case sig := <-sigChan:
-		stdoutLogger.Printf(`{"message":"received signal","signal":"%v","shutting_down":true}`, sig)
-		fileLogger.Printf(`{"message":"received signal","signal":"%v","shutting_down":true}\n`, sig)
+		logger.Info("received signal", "signal", sig.String(), "shutting_down", true)
+
+		// Fail readiness first so load balancers stop routing new traffic
+		draining.Store(true)
+		ready.Store(false)
 
 		// Create shutdown context with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
 		defer cancel()
 
-		// Graceful shutdown
-		if err := server.Shutdown(ctx); err != nil {
-			stdoutLogger.Printf(`{"message":"server shutdown error","error":"%v"}`, err)
-			fileLogger.Printf(`{"message":"server shutdown error","error":"%v"}\n`, err)
-			// Force close if graceful shutdown fails
-			server.Close()
-		} else {
-			stdoutLogger.Println(`{"message":"server shutdown gracefully"}`)
-			fileLogger.Printf(`{"message":"server shutdown gracefully"}\n`)
-		}
+		shutdownServer(ctx, logger, server)
 
 		// Final sync of log file
 		if err := file.Sync(); err != nil {
-			stdoutLogger.Printf(`{"message":"failed to sync log file on shutdown","error":"%v"}`, err)
+			logger.Error("failed to sync log file on shutdown", "error", err)
 		}
 	}
 }
@@ -1,21 +1,41 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
-	"log"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestHandleHealth(t *testing.T) {
 	req := httptest.NewRequest("GET", "/health", nil)
 	w := httptest.NewRecorder()
 
-	handleHealth(w, req)
+	handleHealth(nil)(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
@@ -31,13 +51,132 @@ func TestHandleHealth(t *testing.T) {
 	}
 }
 
+func TestHandleHealthDeep(t *testing.T) {
+	logFile, err := newRotatingWriter(filepath.Join(t.TempDir(), "app.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	defer logFile.Close()
+
+	w := httptest.NewRecorder()
+	handleHealth(logFile)(w, httptest.NewRequest("GET", "/health?deep=true", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response map[string]string
+	json.NewDecoder(w.Body).Decode(&response)
+	if response["status"] != "healthy" {
+		t.Errorf("expected status 'healthy', got %q", response["status"])
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(logFile.path), ".healthcheck-*")); len(leftovers) != 0 {
+		t.Errorf("expected probe files to be removed, found %v", leftovers)
+	}
+}
+
+func TestHandleHealthDeepReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+	dir := t.TempDir()
+	logFile, err := newRotatingWriter(filepath.Join(dir, "app.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	defer logFile.Close()
+	if err := os.Chmod(dir, 0o555); err != nil {
+		t.Fatalf("failed to make dir read-only: %v", err)
+	}
+	defer os.Chmod(dir, 0o755)
+
+	assertDegraded(t, handleHealth(logFile))
+}
+
+func TestHandleHealthDeepBrokenHandle(t *testing.T) {
+	logFile, err := newRotatingWriter(filepath.Join(t.TempDir(), "app.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	logFile.Close()
+
+	assertDegraded(t, handleHealth(logFile))
+
+	// Without ?deep the check stays static
+	w := httptest.NewRecorder()
+	handleHealth(logFile)(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected shallow check to return %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func assertDegraded(t *testing.T, h http.Handler) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health?deep=true", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response["status"] != "degraded" || response["failed"] != "logFile" || response["error"] == "" {
+		t.Errorf("expected degraded logFile response with error, got %v", response)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	defer ready.Store(false)
+	defer draining.Store(false)
+
+	tests := []struct {
+		name       string
+		ready      bool
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"starting", false, false, http.StatusServiceUnavailable, "not ready"},
+		{"ready", true, false, http.StatusOK, "ready"},
+		{"draining", false, true, http.StatusServiceUnavailable, "draining"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready.Store(tt.ready)
+			draining.Store(tt.draining)
+
+			w := httptest.NewRecorder()
+			handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			var response map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response["status"] != tt.wantStatus {
+				t.Errorf("expected status '%s', got '%s'", tt.wantStatus, response["status"])
+			}
+		})
+	}
+
+	// Liveness is unaffected by readiness
+	ready.Store(false)
+	w := httptest.NewRecorder()
+	handleHealth(nil)(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected /health to stay %d while not ready, got %d", http.StatusOK, w.Code)
+	}
+}
+
 func TestHandleMetrics(t *testing.T) {
 	// Reset metrics
 	metricsMutex.Lock()
-	requestCount = 0
-	errorCount = 0
-	totalLatencyMs = 0
+	endpointMetrics = map[string]*endpointStats{}
 	metricsMutex.Unlock()
+	recordRequest("/hello", http.StatusOK, 0)
 
 	req := httptest.NewRequest("GET", "/metrics", nil)
 	w := httptest.NewRecorder()
@@ -49,14 +188,57 @@ func TestHandleMetrics(t *testing.T) {
 	}
 
 	body := w.Body.String()
-	if !strings.Contains(body, "http_requests_total") {
+	if !strings.Contains(body, `http_requests_total{path="/hello"}`) {
 		t.Error("metrics output should contain http_requests_total")
 	}
-	if !strings.Contains(body, "http_errors_total") {
+	if !strings.Contains(body, `http_errors_total{path="/hello"}`) {
 		t.Error("metrics output should contain http_errors_total")
 	}
-	if !strings.Contains(body, "http_request_duration_ms") {
-		t.Error("metrics output should contain http_request_duration_ms")
+	if !strings.Contains(body, `http_request_duration_ms_count{path="/hello"}`) {
+		t.Error("metrics output should contain http_request_duration_ms histogram")
+	}
+	if !strings.Contains(body, `http_request_duration_avg_ms{path="/hello"}`) {
+		t.Error("metrics output should contain http_request_duration_avg_ms")
+	}
+}
+
+func TestBuildInfoMetric(t *testing.T) {
+	prevVersion, prevCommit := version, commit
+	version, commit = "1.2.3", "abc123"
+	defer func() { version, commit = prevVersion, prevCommit }()
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := fmt.Sprintf(`build_info{version="1.2.3",commit="abc123",go_version="%s"} 1`, runtime.Version())
+	if !strings.Contains(w.Body.String(), want+"\n") {
+		t.Errorf("expected metrics to contain %s, got:\n%s", want, w.Body.String())
+	}
+}
+
+func TestProcessUptimeMetric(t *testing.T) {
+	previous := startTime
+	startTime = time.Now().Add(-90 * time.Second)
+	defer func() { startTime = previous }()
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var uptime string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, "process_uptime_seconds "); ok {
+			uptime = v
+		}
+	}
+	if uptime == "" {
+		t.Fatal("metrics output should contain process_uptime_seconds")
+	}
+	seconds, err := strconv.ParseFloat(uptime, 64)
+	if err != nil {
+		t.Fatalf("process_uptime_seconds %q is not a float: %v", uptime, err)
+	}
+	if seconds < 90 || seconds > 95 {
+		t.Errorf("expected uptime of about 90s, got %v", seconds)
 	}
 }
 
@@ -69,10 +251,9 @@ func TestTraceMiddleware(t *testing.T) {
 	defer os.Remove(tmpFile.Name())
 	tmpFile.Close()
 
-	stdoutLogger := log.New(os.Stdout, "", 0)
-	fileLogger := log.New(os.Stdout, "", 0)
+	logger := slog.New(newFileHandler(os.Stdout, nil))
 
-	handler := traceMiddleware(stdoutLogger, fileLogger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := traceMiddleware(logger, traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		traceID := r.Context().Value(traceKey)
 		if traceID == nil {
 			t.Error("traceId not found in context")
@@ -92,71 +273,1588 @@ func TestTraceMiddleware(t *testing.T) {
 
 	// Verify metrics were updated
 	metricsMutex.RLock()
-	if requestCount == 0 {
+	if stats := endpointMetrics["/test"]; stats == nil || stats.requestCount == 0 {
 		t.Error("requestCount should be incremented")
 	}
 	metricsMutex.RUnlock()
 }
 
-func TestHandleHello(t *testing.T) {
-	stdoutLogger := log.New(os.Stdout, "", 0)
-	fileLogger := log.New(os.Stdout, "", 0)
+func TestTraceMiddlewareTraceIDSources(t *testing.T) {
+	const traceparentID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		traceparent string
+		xTraceID    string
+		want        string
+	}{
+		{"valid traceparent wins", "00-" + traceparentID + "-00f067aa0ba902b7-01", "x-trace-123", traceparentID},
+		{"malformed traceparent falls through", "00-not-a-trace-01", "x-trace-123", "x-trace-123"},
+		{"all-zero trace-id falls through", "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01", "x-trace-123", "x-trace-123"},
+		{"plain X-Trace-Id", "", "x-trace-123", "x-trace-123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = r.Context().Value(traceKey).(string)
+			}))
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			req.Header.Set("X-Trace-Id", tt.xTraceID)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if seen != tt.want {
+				t.Errorf("expected trace ID %q in context, got %q", tt.want, seen)
+			}
+			if got := w.Header().Get("X-Trace-Id"); got != tt.want {
+				t.Errorf("expected X-Trace-Id response header %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTraceMiddlewareEchoesTraceIDHeader(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/health", handleHealth(nil))
+	// Flushes before writing a body, committing the headers early
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		http.NewResponseController(w).Flush()
+	})
+	server := httptest.NewServer(traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, mux))
+	defer server.Close()
+
+	for _, path := range []string{"/health", "/flush"} {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("X-Trace-Id", "client-trace-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Trace-Id"); got != "client-trace-1" {
+			t.Errorf("%s: expected echoed trace ID %q, got %q", path, "client-trace-1", got)
+		}
+
+		resp, err = http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if _, err := uuid.Parse(resp.Header.Get("X-Trace-Id")); err != nil {
+			t.Errorf("%s: expected a generated UUID trace ID, got %q", path, resp.Header.Get("X-Trace-Id"))
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseTraceparent(tt.header); ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+		}
+	}
+}
+
+func TestTraceMiddlewareTagsCancelledRequest(t *testing.T) {
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/hello", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the cancelled request to fail")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not finish after the client cancelled")
+	}
+	var entry logEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+	}
+	if !entry.Cancelled || entry.Timeout {
+		t.Errorf("expected cancelled=true timeout=false, got %s", buf.String())
+	}
+}
+
+func TestTraceMiddlewareTagsTimedOutRequest(t *testing.T) {
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil).WithContext(ctx))
+
+	var entry logEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+	}
+	if !entry.Timeout || entry.Cancelled {
+		t.Errorf("expected timeout=true cancelled=false, got %s", buf.String())
+	}
+
+	// Requests that complete normally carry neither tag
+	buf.Reset()
+	traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+	if strings.Contains(buf.String(), "cancelled") || strings.Contains(buf.String(), "timeout") {
+		t.Errorf("expected no cancellation tags, got %s", buf.String())
+	}
+}
 
-	handler := handleHello(stdoutLogger, fileLogger)
+func TestTraceMiddlewareLogsRequestStart(t *testing.T) {
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{logStart: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
+	before := time.Now()
 	req := httptest.NewRequest("GET", "/hello", nil)
-	ctx := context.WithValue(req.Context(), traceKey, "test-trace-123")
-	req = req.WithContext(ctx)
+	req.Header.Set("X-Trace-Id", "start-trace")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a start and a completion line, got %d lines:\n%s", len(lines), buf.String())
+	}
+	var start, done logEntry
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil {
+		t.Fatalf("failed to decode start line %q: %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &done); err != nil {
+		t.Fatalf("failed to decode completion line %q: %v", lines[1], err)
+	}
+	if start.Message != "request received" || done.Message != "request completed" {
+		t.Errorf("expected received then completed, got %q then %q", start.Message, done.Message)
+	}
+	if start.TraceID != "start-trace" || done.TraceID != start.TraceID {
+		t.Errorf("expected matching trace IDs, got %q and %q", start.TraceID, done.TraceID)
+	}
+	receivedAt, err := time.Parse(time.RFC3339Nano, start.ReceivedAt)
+	if err != nil {
+		t.Fatalf("expected an RFC 3339 receivedAt, got %q: %v", start.ReceivedAt, err)
+	}
+	if receivedAt.Before(before.Add(-time.Second)) || receivedAt.After(time.Now()) {
+		t.Errorf("expected receivedAt around %v, got %v", before, receivedAt)
+	}
+
+	// Off by default
+	buf.Reset()
+	traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+	if strings.Contains(buf.String(), "request received") {
+		t.Errorf("expected no start line by default, got %s", buf.String())
+	}
+}
+
+func TestTraceMiddlewareSlowThreshold(t *testing.T) {
+	slow, err := loadSlowThresholds([]string{"SLOW_MS=1000", "SLOW_MS_HELLO=10", "PATH=/usr/bin"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{slow: slow}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+
+	for _, path := range []string{"/hello", "/other"} {
+		buf.Reset()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+
+		var entry logEntry
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+		}
+		wantSlow := path == "/hello"
+		if entry.Slow != wantSlow {
+			t.Errorf("%s: expected slow=%v, got %v", path, wantSlow, entry.Slow)
+		}
+		wantLevel := slog.LevelInfo
+		if wantSlow {
+			wantLevel = slog.LevelWarn
+		}
+		if entry.Level != wantLevel {
+			t.Errorf("%s: expected level %v, got %v", path, wantLevel, entry.Level)
+		}
+	}
+}
+
+func TestTraceMiddlewareSlowRequestWarning(t *testing.T) {
+	var buf bytes.Buffer
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{slowRequest: 20 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a warning and a completion line, got %q", buf.String())
+	}
+	var warning, completed logEntry
+	if err := json.Unmarshal([]byte(lines[0]), &warning); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &completed); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", lines[1], err)
+	}
+	if warning.Message != "slow request" || warning.Level != slog.LevelWarn || warning.Path != "/slow" || warning.LatencyMs < 40 {
+		t.Errorf("expected a warn slow request entry for /slow with its latency, got %+v", warning)
+	}
+	if warning.TraceID == "" || warning.TraceID != completed.TraceID {
+		t.Errorf("expected both entries to share a trace ID, got %q and %q", warning.TraceID, completed.TraceID)
+	}
+	if completed.Message != "request completed" {
+		t.Errorf("expected the completion line last, got %q", completed.Message)
+	}
+
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 1 {
+		t.Errorf("expected a single line for a fast request, got %q", buf.String())
+	}
+}
+
+func TestSlowBodyReadIsRecorded(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	var buf bytes.Buffer
+	slow := slowThresholds{bodyRead: 50 * time.Millisecond}
+	handler := traceMiddleware(slog.New(newFileHandler(&buf, nil)), traceOptions{slow: slow}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+	}))
+
+	// The client trickles the body in over ~150ms
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			pw.Write([]byte("chunk"))
+		}
+		pw.Close()
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", pr))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid log line %q: %v", buf.String(), err)
+	}
+	if ms, _ := entry["bodyReadMs"].(float64); ms < 140 {
+		t.Errorf("expected bodyReadMs of about 150, got %v", entry["bodyReadMs"])
+	}
+	if entry["slowBodyRead"] != true || entry["level"] != "warn" {
+		t.Errorf("expected a warn entry flagged slowBodyRead, got %v", entry)
+	}
+
 	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `http_request_body_read_ms_count{path="/upload"} 1`) {
+		t.Errorf("expected one body read recorded for /upload, got:\n%s", body)
+	}
+	if !strings.Contains(body, `http_slow_body_reads_total{path="/upload"} 1`) {
+		t.Errorf("expected one slow body read for /upload, got:\n%s", body)
+	}
 
-	handler.ServeHTTP(w, req)
+	// Requests without a body don't report body reads
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+	if strings.Contains(buf.String(), "bodyReadMs") {
+		t.Errorf("expected no bodyReadMs for a bodyless request, got %s", buf.String())
+	}
+}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+func TestBodyBytesAreCounted(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(bytes.Repeat([]byte("y"), 300))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 1000))))
+		if w.Body.Len() != 300 {
+			t.Fatalf("expected a 300 byte response, got %d", w.Body.Len())
+		}
 	}
+	// A bodyless request counts no request bytes
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/upload", nil))
 
-	var response map[string]string
-	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
-		t.Fatalf("failed to decode response: %v", err)
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `http_request_bytes_total{path="/upload"} 2000`) {
+		t.Errorf("expected 2000 request bytes for /upload, got:\n%s", body)
+	}
+	if !strings.Contains(body, `http_response_bytes_total{path="/upload"} 900`) {
+		t.Errorf("expected 900 response bytes for /upload, got:\n%s", body)
 	}
+}
 
-	if response["message"] != "hello" {
-		t.Errorf("expected message 'hello', got '%s'", response["message"])
+func TestLoadSlowThresholds(t *testing.T) {
+	slow, err := loadSlowThresholds([]string{"SLOW_MS=250", "SLOW_MS_DEBUG_VARS=5", "SLOW_BODY_READ_MS=1000"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if response["traceId"] != "test-trace-123" {
-		t.Errorf("expected traceId 'test-trace-123', got '%s'", response["traceId"])
+	if slow.bodyRead != time.Second {
+		t.Errorf("expected 1s body read threshold, got %v", slow.bodyRead)
+	}
+	if got := slow.forPath("/debug/vars"); got != 5*time.Millisecond {
+		t.Errorf("expected 5ms for /debug/vars, got %v", got)
+	}
+	if got := slow.forPath("/hello"); got != 250*time.Millisecond {
+		t.Errorf("expected fallback 250ms for /hello, got %v", got)
+	}
+
+	if _, err := loadSlowThresholds([]string{"SLOW_MS_HELLO=fast"}); err == nil {
+		t.Error("expected error for non-numeric threshold")
 	}
 }
 
-func TestGetEnvOrDefault(t *testing.T) {
-	// Test with default value
-	result := getEnvOrDefault("NONEXISTENT_VAR", "default")
-	if result != "default" {
-		t.Errorf("expected 'default', got '%s'", result)
+func TestInFlightGauge(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := traceMiddleware(logger, traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/blocking", nil))
+	}()
+
+	<-entered
+	if got := inFlightRequests.Load(); got != 1 {
+		t.Errorf("expected 1 request in flight, got %d", got)
+	}
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+	if !strings.Contains(w.Body.String(), "http_requests_in_flight 1\n") {
+		t.Errorf("expected metrics to report 1 in-flight request:\n%s", w.Body.String())
 	}
 
-	// Test with environment variable
-	os.Setenv("TEST_VAR", "test-value")
-	defer os.Unsetenv("TEST_VAR")
-	result = getEnvOrDefault("TEST_VAR", "default")
-	if result != "test-value" {
-		t.Errorf("expected 'test-value', got '%s'", result)
+	close(release)
+	<-done
+	if got := inFlightRequests.Load(); got != 0 {
+		t.Errorf("expected 0 requests in flight after completion, got %d", got)
 	}
 }
 
-func TestStatusRecorder(t *testing.T) {
+func TestInFlightGaugeDecrementsOnPanic(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	handler := traceMiddleware(logger, traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+
+	if got := inFlightRequests.Load(); got != 0 {
+		t.Errorf("expected 0 requests in flight after panic, got %d", got)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	limiter, err := newRateLimiter("1", "3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+		rateLimitMiddleware(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+
+	var limited int
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+		if w.Code != http.StatusTooManyRequests {
+			continue
+		}
+		limited++
+		if w.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected Retry-After 1, got %q", w.Header().Get("Retry-After"))
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] != "rate limit exceeded" || body["traceId"] == "" {
+			t.Errorf("expected JSON error body with trace ID, got %v (err=%v)", body, err)
+		}
+	}
+	// The burst of 3 passes; the rest of the back-to-back requests do not
+	if limited != 7 {
+		t.Errorf("expected 7 of 10 requests to be limited, got %d", limited)
+	}
+
+	metricsMutex.RLock()
+	stats := endpointMetrics["/hello"]
+	metricsMutex.RUnlock()
+	if stats == nil || stats.requestCount != 10 || stats.errorCount != 7 {
+		t.Errorf("expected 10 requests and 7 errors in metrics, got %+v", stats)
+	}
+
+	// Probes are never limited
 	w := httptest.NewRecorder()
-	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected /health to bypass the limiter, got %d", w.Code)
+	}
+}
 
-	if rec.status != http.StatusOK {
-		t.Errorf("expected initial status %d, got %d", http.StatusOK, rec.status)
+func TestRateLimitHeaders(t *testing.T) {
+	limiter, err := newRateLimiter("2", "4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	handler := rateLimitMiddleware(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 
-	rec.WriteHeader(http.StatusNotFound)
-	if rec.status != http.StatusNotFound {
-		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.status)
+	var responses []*httptest.ResponseRecorder
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+		responses = append(responses, w)
 	}
-	if w.Code != http.StatusNotFound {
-		t.Errorf("expected response writer status %d, got %d", http.StatusNotFound, w.Code)
+
+	// Remaining counts down through the burst of 4, then the 5th is rejected
+	for i, w := range responses {
+		h := w.Header()
+		if h.Get("RateLimit-Limit") != "4" {
+			t.Errorf("request %d: expected RateLimit-Limit 4, got %q", i, h.Get("RateLimit-Limit"))
+		}
+		if want := strconv.Itoa(max(3-i, 0)); h.Get("RateLimit-Remaining") != want {
+			t.Errorf("request %d: expected RateLimit-Remaining %s, got %q", i, want, h.Get("RateLimit-Remaining"))
+		}
+		reset, err := strconv.Atoi(h.Get("RateLimit-Reset"))
+		if err != nil || reset < 1 || reset > 2 {
+			t.Errorf("request %d: expected RateLimit-Reset of 1-2s at 2 rps, got %q", i, h.Get("RateLimit-Reset"))
+		}
+	}
+	rejected := responses[4]
+	if rejected.Code != http.StatusTooManyRequests || rejected.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected a 429 with Retry-After 1, got %d %q", rejected.Code, rejected.Header().Get("Retry-After"))
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if l, err := newRateLimiter("", ""); l != nil || err != nil {
+		t.Errorf("expected limiting disabled when unset, got %v, %v", l, err)
+	}
+	l, err := newRateLimiter("2.5", "")
+	if err != nil || l.Burst() != 3 {
+		t.Errorf("expected default burst 3 for 2.5 rps, got %v (err=%v)", l, err)
+	}
+	for _, tt := range [][2]string{{"fast", ""}, {"-1", ""}, {"5", "0"}, {"5", "many"}} {
+		if _, err := newRateLimiter(tt[0], tt[1]); err == nil {
+			t.Errorf("expected error for rps=%q burst=%q", tt[0], tt[1])
+		}
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	var buf bytes.Buffer
+	logger := slog.New(newFileHandler(&buf, nil))
+	handler := traceMiddleware(logger, traceOptions{}, recoverMiddleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Trace-Id", "panic-trace")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response["traceId"] != "panic-trace" {
+		t.Errorf("expected traceId 'panic-trace', got '%s'", response["traceId"])
+	}
+
+	var panicEntry *logEntry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		if entry.Message == "panic recovered" {
+			panicEntry = &entry
+		}
+	}
+	if panicEntry == nil {
+		t.Fatalf("expected a panic log entry, got:\n%s", buf.String())
+	}
+	if panicEntry.Status != http.StatusInternalServerError || panicEntry.Error != "boom" || panicEntry.Stack == "" {
+		t.Errorf("unexpected panic log entry: %+v", panicEntry)
+	}
+	if panicEntry.TraceID != "panic-trace" {
+		t.Errorf("expected traceId 'panic-trace' in log, got '%s'", panicEntry.TraceID)
+	}
+
+	metricsMutex.RLock()
+	stats := endpointMetrics["/panic"]
+	metricsMutex.RUnlock()
+	if stats == nil || stats.errorCount != 1 {
+		t.Errorf("expected recovered panic to count as an error, got %+v", stats)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("too late"))
+	})
+	handler := traceMiddleware(logger, traceOptions{}, timeoutMiddleware(50*time.Millisecond, mux))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("expected fast handler to pass through, got %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("expected handler content type to be kept, got %q", ct)
+	}
+
+	req := httptest.NewRequest("GET", "/slow", nil)
+	req.Header.Set("X-Trace-Id", "slow-trace")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %q", ct)
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response["traceId"] != "slow-trace" {
+		t.Errorf("expected traceId 'slow-trace', got '%s'", response["traceId"])
+	}
+
+	metricsMutex.RLock()
+	fast, slow := endpointMetrics["/fast"], endpointMetrics["/slow"]
+	metricsMutex.RUnlock()
+	if fast == nil || fast.errorCount != 0 {
+		t.Errorf("expected no errors for /fast, got %+v", fast)
+	}
+	if slow == nil || slow.errorCount != 1 {
+		t.Errorf("expected timed out request to count as an error, got %+v", slow)
+	}
+}
+
+func TestFileHandlerFieldNames(t *testing.T) {
+	var buf bytes.Buffer
+	logEvent(slog.New(newFileHandler(&buf, nil)), logEntry{
+		TraceID:   "trace-1",
+		Method:    "GET",
+		Path:      "/hello",
+		Status:    http.StatusOK,
+		LatencyMs: 42,
+		Message:   "request completed",
+	})
+
+	var fields map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("failed to decode log line %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"traceId":   "trace-1",
+		"method":    "GET",
+		"path":      "/hello",
+		"status":    float64(http.StatusOK),
+		"latencyMs": float64(42),
+		"message":   "request completed",
+		"level":     "info",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, fields[key])
+		}
+	}
+	for _, key := range []string{"msg", "time", "slow", "error", "stack"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("unexpected key %q in %s", key, buf.String())
+		}
+	}
+}
+
+func TestLogFileLinesAreStandaloneJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, file, err := newLogger(path, logFormatJSON, 0, 0, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	// The same kinds of records main and the middleware write
+	logger.Info("server starting", "addr", ":8080")
+	handler := traceMiddleware(logger, traceOptions{}, recoverMiddleware(logger, handleHello(logger, newDurationVar(defaultHelloDelay), nil, 0)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+	logger.Info("received signal", "signal", "terminated", "shutting_down", true)
+	logger.Error("server shutdown error", "error", errors.New("multi\nline \"quoted\" error"))
+	logger.Info("server shutdown gracefully")
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close log file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 log lines, got %d:\n%s", len(lines), data)
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("line is not valid JSON: %q", line)
+		}
+		if strings.HasSuffix(line, `\n`) {
+			t.Errorf("line ends with a literal \\n: %q", line)
+		}
+	}
+}
+
+func TestLogfmtFileHandlerQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newLogfmtFileHandler(&buf, slog.LevelDebug))
+	logEvent(logger, logEntry{
+		TraceID:   "abc-123",
+		Method:    "GET",
+		Path:      "/hello",
+		Status:    http.StatusOK,
+		Message:   `client said "hi" a=b` + "\nbye",
+		UserAgent: "curl/8.0 (x86_64)",
+	})
+
+	want := `level=info message="client said \"hi\" a=b\nbye" traceId=abc-123 method=GET path=/hello status=200 latencyMs=0 userAgent="curl/8.0 (x86_64)"` + "\n"
+	if buf.String() != want {
+		t.Errorf("unexpected logfmt line:\n got: %s\nwant: %s", buf.String(), want)
+	}
+}
+
+func TestLogfmtFileHandlerMatchesJSONFields(t *testing.T) {
+	entry := logEntry{
+		TraceID:   "abc-123",
+		Method:    "POST",
+		Path:      "/hello",
+		Status:    http.StatusServiceUnavailable,
+		LatencyMs: 12,
+		Message:   "request completed",
+		Timeout:   true,
+		ClientIP:  "10.0.0.1",
+	}
+	var jsonBuf, logfmtBuf bytes.Buffer
+	logEvent(slog.New(newFileHandler(&jsonBuf, slog.LevelDebug)), entry)
+	logEvent(slog.New(newLogfmtFileHandler(&logfmtBuf, slog.LevelDebug)), entry)
+
+	var fields map[string]any
+	if err := json.Unmarshal(jsonBuf.Bytes(), &fields); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	pairs := parseLogfmt(t, strings.TrimSpace(logfmtBuf.String()))
+	if len(pairs) != len(fields) {
+		t.Fatalf("expected %d logfmt fields to match JSON, got %d: %s", len(fields), len(pairs), logfmtBuf.String())
+	}
+	for key, value := range pairs {
+		if want, ok := fields[key]; !ok || fmt.Sprint(want) != value {
+			t.Errorf("expected %s=%v as in JSON, got %q", key, want, value)
+		}
+	}
+}
+
+// parseLogfmt splits a logfmt line into its keys and unquoted values.
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	t.Helper()
+	pairs := map[string]string{}
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("malformed logfmt at %q", line)
+		}
+		value := rest
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				t.Fatalf("bad quoting at %q: %v", rest, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		pairs[key] = value
+		line = strings.TrimPrefix(rest, " ")
+	}
+	return pairs
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for in, want := range map[string]string{"": logFormatJSON, "json": logFormatJSON, "logfmt": logFormatLogfmt} {
+		if got, err := parseLogFormat(in); err != nil || got != want {
+			t.Errorf("parseLogFormat(%q): expected %q, got %q (err=%v)", in, want, got, err)
+		}
+	}
+	if _, err := parseLogFormat("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	level, err := parseLogLevel("warn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	logger := slog.New(newFileHandler(&buf, level))
+
+	logEvent(logger, logEntry{Message: "handler finished", Status: http.StatusOK, Level: slog.LevelDebug})
+	logEvent(logger, logEntry{Message: "request completed", Status: http.StatusOK})
+	logEvent(logger, logEntry{Message: "slow request", Status: http.StatusOK, Level: slog.LevelWarn})
+	logEvent(logger, logEntry{Message: "panic recovered", Status: http.StatusInternalServerError, Level: slog.LevelError})
+	// 5xx responses are logged at error level even if the caller said info
+	logEvent(logger, logEntry{Message: "request completed", Status: http.StatusServiceUnavailable})
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		got = append(got, fmt.Sprintf("%s/%v", entry.Message, entry.Level))
+	}
+	want := []string{"slow request/WARN", "panic recovered/ERROR", "request completed/ERROR"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected entries %v, got %v", want, got)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for input, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := parseLogLevel(input)
+		if err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestFanoutHandler(t *testing.T) {
+	var text, jsonBuf bytes.Buffer
+	logger := slog.New(fanoutHandler{
+		slog.NewTextHandler(&text, &slog.HandlerOptions{Level: slog.LevelWarn}),
+		newFileHandler(&jsonBuf, nil),
+	})
+
+	logger.Info("only json")
+	logger.Warn("both", "traceId", "t-1")
+
+	if strings.Contains(text.String(), "only json") {
+		t.Error("text handler should not receive records below its level")
+	}
+	if !strings.Contains(text.String(), "traceId=t-1") {
+		t.Errorf("expected warn record in text output, got %q", text.String())
+	}
+	if lines := strings.Count(jsonBuf.String(), "\n"); lines != 2 {
+		t.Errorf("expected 2 JSON lines, got %d: %q", lines, jsonBuf.String())
+	}
+}
+
+func TestPathDelayMiddleware(t *testing.T) {
+	delays, err := parsePrefixDurations(`{"/slow": "200ms", "/slow/faster": "20ms"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := pathDelayMiddleware(delays, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path     string
+		min, max time.Duration
+	}{
+		{"/slow", 200 * time.Millisecond, 400 * time.Millisecond},
+		{"/slow/faster", 20 * time.Millisecond, 150 * time.Millisecond},
+		{"/other", 0, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+			t.Errorf("%s: expected delay in [%v, %v], got %v", tt.path, tt.min, tt.max, elapsed)
+		}
+	}
+}
+
+func TestPathDelayMiddlewareOverridesHelloDelay(t *testing.T) {
+	handler := pathDelayMiddleware(prefixDurations{"/hello": time.Millisecond}, handleHello(slog.New(newFileHandler(io.Discard, nil)), newDurationVar(defaultHelloDelay), nil, 0))
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("expected the 1ms path delay to replace the 50ms hello delay, took %v", elapsed)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestPathDelayMiddlewareCancelled(t *testing.T) {
+	called := false
+	handler := pathDelayMiddleware(prefixDurations{"/slow": time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected cancellation to cut the delay short, took %v", elapsed)
+	}
+	if called {
+		t.Error("handler should not run after the client went away")
+	}
+}
+
+func TestRouteDeadlineKeepsStreamOpen(t *testing.T) {
+	// 10 events 30ms apart outlive both the 100ms WriteTimeout and the 50ms
+	// request timeout unless /stream gets its own deadline
+	newServer := func(timeouts prefixDurations) *httptest.Server {
+		mux := http.NewServeMux()
+		mux.Handle("/stream", handleStream(30*time.Millisecond))
+		handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+			routeDeadlineMiddleware(timeouts, timeoutMiddleware(50*time.Millisecond, mux)))
+		server := httptest.NewUnstartedServer(handler)
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Start()
+		return server
+	}
+	countEvents := func(server *httptest.Server) int {
+		resp, err := http.Get(server.URL + "/stream?n=10")
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		var events int
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var event map[string]any
+			if err := dec.Decode(&event); err != nil || event["seq"] == nil {
+				return events
+			}
+			events++
+		}
+	}
+
+	server := newServer(prefixDurations{"/stream": 2 * time.Second})
+	defer server.Close()
+	if got := countEvents(server); got != 10 {
+		t.Errorf("expected all 10 events with a route deadline, got %d", got)
+	}
+
+	cutOff := newServer(nil)
+	defer cutOff.Close()
+	if got := countEvents(cutOff); got == 10 {
+		t.Error("expected the stream to be cut off without a route deadline")
+	}
+}
+
+func TestParsePathDelaysInvalid(t *testing.T) {
+	for _, input := range []string{`not json`, `{"/a": "soon"}`, `{"/a": "-1s"}`} {
+		if _, err := parsePrefixDurations(input); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
+
+func TestPerEndpointMetrics(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc(metricsPath, handleMetrics)
+	handler := traceMiddleware(logger, traceOptions{}, mux)
+
+	for _, path := range []string{"/ok", "/ok", "/ok", "/fail", metricsPath} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	metricsMutex.RLock()
+	ok, fail := endpointMetrics["/ok"], endpointMetrics["/fail"]
+	_, scraped := endpointMetrics[metricsPath]
+	metricsMutex.RUnlock()
+
+	if ok == nil || ok.requestCount != 3 || ok.errorCount != 0 {
+		t.Errorf("expected /ok to have 3 requests and 0 errors, got %+v", ok)
+	}
+	if fail == nil || fail.requestCount != 1 || fail.errorCount != 1 {
+		t.Errorf("expected /fail to have 1 request and 1 error, got %+v", fail)
+	}
+	if scraped {
+		t.Error("metrics scrapes should not be recorded")
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{path="/ok"} 3`,
+		`http_requests_total{path="/fail"} 1`,
+		`http_errors_total{path="/ok"} 0`,
+		`http_errors_total{path="/fail"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics output missing %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestLatencyHistogramBuckets(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	for _, ms := range []int{1, 7, 7, 30, 120, 600, 2000} {
+		recordRequest("/hello", http.StatusOK, time.Duration(ms)*time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+
+	var counts []int64
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, `http_request_duration_ms_bucket{path="/hello"`) {
+			continue
+		}
+		fields := strings.Fields(line)
+		n, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			t.Fatalf("failed to parse bucket line %q: %v", line, err)
+		}
+		counts = append(counts, n)
+	}
+
+	if len(counts) != len(latencyBuckets)+1 {
+		t.Fatalf("expected %d buckets including +Inf, got %d", len(latencyBuckets)+1, len(counts))
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] < counts[i-1] {
+			t.Errorf("bucket %d count %d is less than previous %d", i, counts[i], counts[i-1])
+		}
+	}
+	if counts[0] != 1 {
+		t.Errorf("expected 1 request in le=5 bucket, got %d", counts[0])
+	}
+	if counts[len(counts)-1] != 7 {
+		t.Errorf("expected +Inf bucket to equal request count 7, got %d", counts[len(counts)-1])
+	}
+	if !strings.Contains(w.Body.String(), `http_request_duration_ms_count{path="/hello"} 7`) {
+		t.Error("expected http_request_duration_ms_count of 7")
+	}
+}
+
+func TestLatencySumKeepsSubMillisecond(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	// Four requests under 1ms, which whole milliseconds would sum to 0
+	for i := 0; i < 4; i++ {
+		recordRequest("/hello", http.StatusOK, 250*time.Microsecond)
+	}
+	recordRequest("/hello", http.StatusOK, 3*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", metricsPath, nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `http_request_duration_ms_sum{path="/hello"} 4`+"\n") {
+		t.Errorf("expected a sum of 4ms, got:\n%s", body)
+	}
+	if !strings.Contains(body, `http_request_duration_avg_ms{path="/hello"} 0`+"\n") {
+		t.Errorf("expected the legacy average to stay whole milliseconds, got:\n%s", body)
+	}
+}
+
+func TestParseLatencyBuckets(t *testing.T) {
+	buckets, err := parseLatencyBuckets("1, 2.5,10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(buckets) != 3 || buckets[0] != 1 || buckets[1] != 2.5 || buckets[2] != 10 {
+		t.Errorf("unexpected buckets %v", buckets)
+	}
+
+	for _, invalid := range []string{"", "5,abc", "10,5", "0,5", "5,5"} {
+		if _, err := parseLatencyBuckets(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestHandleHello(t *testing.T) {
+	handler := handleHello(slog.New(newFileHandler(io.Discard, nil)), newDurationVar(defaultHelloDelay), nil, 0)
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	ctx := context.WithValue(req.Context(), traceKey, "test-trace-123")
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if response["message"] != "hello" {
+		t.Errorf("expected message 'hello', got '%s'", response["message"])
+	}
+	if response["traceId"] != "test-trace-123" {
+		t.Errorf("expected traceId 'test-trace-123', got '%s'", response["traceId"])
+	}
+}
+
+func TestHandleHelloDelay(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		query    string
+		min, max time.Duration
+	}{
+		{"default", defaultHelloDelay, "", 50 * time.Millisecond, 150 * time.Millisecond},
+		{"env override", 150 * time.Millisecond, "", 150 * time.Millisecond, 250 * time.Millisecond},
+		{"query override", defaultHelloDelay, "?delay=0s", 0, 30 * time.Millisecond},
+		{"query override longer", defaultHelloDelay, "?delay=120ms", 120 * time.Millisecond, 220 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			start := time.Now()
+			handleHello(logger, newDurationVar(tt.delay), nil, 0)(w, httptest.NewRequest("GET", "/hello"+tt.query, nil))
+			elapsed := time.Since(start)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("expected delay in [%v, %v], got %v", tt.min, tt.max, elapsed)
+			}
+		})
+	}
+}
+
+func TestHandleHelloAbandonsCancelledRequest(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logs := &lockedBuffer{}
+	logger := slog.New(newFileHandler(logs, slog.LevelDebug))
+	mux := http.NewServeMux()
+	mux.Handle("/hello", handleHello(logger, newDurationVar(time.Second), nil, 0))
+	writeTimeouts, err := parsePrefixDurations(defaultRouteWriteTimeouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The chain main builds with the default settings, REQUEST_TIMEOUT
+	// included
+	handler := newHandler(logger, traceOptions{}, middlewareOptions{
+		concurrency:    newConcurrencyLimiter(0, 0),
+		writeTimeouts:  writeTimeouts,
+		requestTimeout: defaultRequestTimeout,
+	}, mux)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the handler to return once cancelled, took %v", elapsed)
+	}
+	if w.Code != statusClientClosedRequest || strings.Contains(w.Body.String(), "hello") {
+		t.Errorf("expected a bare %d instead of the greeting, got %d %q", statusClientClosedRequest, w.Code, w.Body.String())
+	}
+	metricsMutex.RLock()
+	stats := endpointMetrics["/hello"]
+	metricsMutex.RUnlock()
+	if stats == nil || stats.errorCount != 1 {
+		t.Errorf("expected the abandoned request counted as an error, got %+v", stats)
+	}
+
+	// The handler runs on behind the timeout middleware, so its log line
+	// may land after the response
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), `"message":"handler abandoned"`) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(logs.String(), `"message":"handler abandoned"`) || !strings.Contains(logs.String(), `"cancelled":true`) {
+		t.Errorf("expected an abandoned, cancelled log entry, got %s", logs.String())
+	}
+	if strings.Contains(logs.String(), `"status":503`) {
+		t.Errorf("expected no 503 for a cancelled request, got %s", logs.String())
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for a handler goroutine to write while
+// the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHandleHelloRejectsBadDelay(t *testing.T) {
+	for _, query := range []string{"?delay=-1s", "?delay=soon", "?delay=10"} {
+		w := httptest.NewRecorder()
+		handleHello(slog.New(newFileHandler(io.Discard, nil)), newDurationVar(defaultHelloDelay), nil, 0)(w, httptest.NewRequest("GET", "/hello"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || !strings.Contains(body["error"], "invalid delay") {
+			t.Errorf("%s: expected JSON error body, got %v (err=%v)", query, body, err)
+		}
+	}
+}
+
+func TestHandleHelloPayloadSize(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	tests := []struct {
+		name        string
+		payloadSize int
+		query       string
+		want        int
+	}{
+		{"no padding", 0, "", 0},
+		{"default", 512, "", 512},
+		{"query", 0, "?size=1024", 1024},
+		{"query overrides default", 512, "?size=4096", 4096},
+		{"query disables default", 512, "?size=0", 0},
+		{"maximum", 0, "?size=" + strconv.Itoa(maxHelloPayloadSize), maxHelloPayloadSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleHello(logger, newDurationVar(0), nil, tt.payloadSize)(w, httptest.NewRequest("GET", "/hello"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			size := w.Body.Len()
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("expected JSON body: %v", err)
+			}
+			if len(body["data"]) != tt.want {
+				t.Errorf("expected %d bytes of data, got %d", tt.want, len(body["data"]))
+			}
+			if _, ok := body["data"]; ok != (tt.want > 0) {
+				t.Errorf("expected data field only when padding, got %v", body)
+			}
+			if tt.want > 0 && size <= tt.want {
+				t.Errorf("expected the response to grow with the payload, got %d bytes for %d of data", size, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleHelloRejectsBadSize(t *testing.T) {
+	for _, query := range []string{"?size=-1", "?size=big", "?size=" + strconv.Itoa(maxHelloPayloadSize+1)} {
+		w := httptest.NewRecorder()
+		handleHello(slog.New(newFileHandler(io.Discard, nil)), newDurationVar(0), nil, 0)(w, httptest.NewRequest("GET", "/hello"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || !strings.Contains(body["error"], "invalid size") {
+			t.Errorf("%s: expected JSON error body, got %v (err=%v)", query, body, err)
+		}
+	}
+}
+
+func TestGetDurationEnvOrDefault(t *testing.T) {
+	t.Setenv("HELLO_DELAY", "250ms")
+	if got := getDurationEnvOrDefault("HELLO_DELAY", defaultHelloDelay); got != 250*time.Millisecond {
+		t.Errorf("expected 250ms from env, got %v", got)
+	}
+	t.Setenv("HELLO_DELAY", "later")
+	if got := getDurationEnvOrDefault("HELLO_DELAY", defaultHelloDelay); got != defaultHelloDelay {
+		t.Errorf("expected default for invalid value, got %v", got)
+	}
+	t.Setenv("HELLO_DELAY", "")
+	if got := getDurationEnvOrDefault("HELLO_DELAY", defaultHelloDelay); got != defaultHelloDelay {
+		t.Errorf("expected default when unset, got %v", got)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, roots := writeSelfSignedCert(t, t.TempDir())
+
+	mux := http.NewServeMux()
+	mux.Handle("/health", handleHealth(nil))
+	server := &http.Server{Handler: mux}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(server, ln, certFile, keyFile) }()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/health")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("expected a 200 over TLS, got status %d (tls=%v)", resp.StatusCode, resp.TLS != nil)
+	}
+
+	// Shutdown ends a TLS server the same way as a plaintext one
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		t.Errorf("expected http.ErrServerClosed, got %v", err)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		bindAddr, port, want string
+		wantErr              bool
+	}{
+		{"", "8080", ":8080", false},
+		{"127.0.0.1:9090", "8080", "127.0.0.1:9090", false},
+		{"[::1]:9090", "8080", "[::1]:9090", false},
+		{":9090", "8080", ":9090", false},
+		{"localhost:0", "8080", "localhost:0", false},
+		{"127.0.0.1", "8080", "", true},
+		{"127.0.0.1:http", "8080", "", true},
+		{"127.0.0.1:70000", "8080", "", true},
+		{"::1:9090", "8080", "", true},
+	}
+	for _, tt := range tests {
+		got, err := listenAddr(tt.bindAddr, tt.port)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("listenAddr(%q, %q): expected error=%v, got %v", tt.bindAddr, tt.port, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("listenAddr(%q, %q): expected %q, got %q", tt.bindAddr, tt.port, tt.want, got)
+		}
+	}
+}
+
+func TestBindAddrLimitsInterface(t *testing.T) {
+	addr, err := listenAddr("127.0.0.1:0", defaultPort)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/health", handleHealth(nil))
+	server := &http.Server{Handler: mux}
+	go serve(server, ln, "", "")
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	resp, err := http.Get("http://127.0.0.1:" + port + "/health")
+	if err != nil {
+		t.Fatalf("request on 127.0.0.1 failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// The same port on any other local address must not accept connections
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatalf("failed to list interface addresses: %v", err)
+	}
+	checked := 0
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ipNet.IP.String(), port), time.Second)
+		if err == nil {
+			conn.Close()
+			t.Errorf("expected %s:%s to be unreachable", ipNet.IP, port)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Log("no non-loopback IPv4 address to check against")
+	}
+}
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to dir,
+// returning their paths and a pool that trusts the certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, roots
+}
+
+func TestShutdownServerLogsDrain(t *testing.T) {
+	tests := []struct {
+		name     string
+		hold     time.Duration
+		timeout  time.Duration
+		wantLogs []string
+	}{
+		{"drains in time", 50 * time.Millisecond, 2 * time.Second,
+			[]string{`"message":"draining requests","in_flight":1`, `"message":"server shutdown gracefully","drained":1`}},
+		{"forced close", 500 * time.Millisecond, 50 * time.Millisecond,
+			[]string{`"message":"draining requests","in_flight":1`, `"in_flight":1,"drained":0`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			done := make(chan struct{})
+			handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tt.hold)
+				close(done)
+			}))
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			go http.Get(server.URL + "/long")
+			<-started
+
+			var buf bytes.Buffer
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			shutdownServer(ctx, slog.New(newFileHandler(&buf, nil)), server.Config)
+			<-done
+
+			for _, want := range tt.wantLogs {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected log to contain %s, got:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterPprof(t *testing.T) {
+	for _, enabled := range []string{"", "true"} {
+		t.Run("ENABLE_PPROF="+enabled, func(t *testing.T) {
+			t.Setenv("ENABLE_PPROF", enabled)
+			mux := http.NewServeMux()
+			registered := registerPprof(mux)
+
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				if enabled == "true" && w.Code != http.StatusOK {
+					t.Errorf("%s: expected %d when enabled, got %d", path, http.StatusOK, w.Code)
+				}
+				if enabled != "true" && w.Code != http.StatusNotFound {
+					t.Errorf("%s: expected %d when disabled, got %d", path, http.StatusNotFound, w.Code)
+				}
+			}
+			if registered != (enabled == "true") {
+				t.Errorf("expected registerPprof to report %v, got %v", enabled == "true", registered)
+			}
+		})
+	}
+}
+
+func TestGetEnvOrDefault(t *testing.T) {
+	// Test with default value
+	result := getEnvOrDefault("NONEXISTENT_VAR", "default")
+	if result != "default" {
+		t.Errorf("expected 'default', got '%s'", result)
+	}
+
+	// Test with environment variable
+	os.Setenv("TEST_VAR", "test-value")
+	defer os.Unsetenv("TEST_VAR")
+	result = getEnvOrDefault("TEST_VAR", "default")
+	if result != "test-value" {
+		t.Errorf("expected 'test-value', got '%s'", result)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	if rec.status != http.StatusOK {
+		t.Errorf("expected initial status %d, got %d", http.StatusOK, rec.status)
+	}
+
+	rec.WriteHeader(http.StatusNotFound)
+	if rec.status != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.status)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected response writer status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestMetricsKeyedOnRoutePattern(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle("/status/{code}", handleStatus(logger))
+	handler := newHandler(logger, traceOptions{}, middlewareOptions{concurrency: newConcurrencyLimiter(0, 0)}, mux)
+
+	for _, path := range []string{"/status/200", "/status/503", "/status/418", "/nope", "/wp-login.php"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if len(endpointMetrics) != 2 {
+		var keys []string
+		for key := range endpointMetrics {
+			keys = append(keys, key)
+		}
+		t.Errorf("expected metrics for 2 routes, got %v", keys)
+	}
+	if stats := endpointMetrics["/status/{code}"]; stats == nil || stats.requestCount != 3 {
+		t.Errorf("expected 3 requests under /status/{code}, got %+v", stats)
+	}
+	if stats := endpointMetrics[unmatchedRoute]; stats == nil || stats.requestCount != 2 {
+		t.Errorf("expected 2 unmatched requests under %q, got %+v", unmatchedRoute, stats)
 	}
 }
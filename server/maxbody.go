@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// maxBodyMiddleware caps request bodies at n bytes (MAX_BODY_BYTES). A
+// request whose Content-Length is over the cap gets a 413 JSON response
+// before any handler runs; otherwise the body is wrapped with
+// http.MaxBytesReader, so a handler reading past n gets an
+// *http.MaxBytesError and should answer with writeBodyTooLarge. It must run
+// inside traceMiddleware so rejections are counted as errors. n <= 0
+// disables the cap.
+func maxBodyMiddleware(n int64, next http.Handler) http.Handler {
+	if n <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			writeBodyTooLarge(w, r)
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyTooLarge answers 413 with the request's trace ID.
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	traceID, _ := r.Context().Value(traceKey).(string)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "request body too large",
+		"traceId": traceID,
+	})
+}
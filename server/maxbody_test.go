@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	var read int
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+		maxBodyMiddleware(10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeBodyTooLarge(w, r)
+				return
+			}
+			read = len(data)
+		})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("0123456789")))
+	if w.Code != http.StatusOK || read != 10 {
+		t.Errorf("expected a body at the limit to pass, got status %d after reading %d bytes", w.Code, read)
+	}
+
+	read = 0
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader("0123456789a"))
+	req.Header.Set("X-Trace-Id", "big-body")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if read != 0 {
+		t.Error("expected the handler not to run for an oversized Content-Length")
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	if body["traceId"] != "big-body" || body["error"] == "" {
+		t.Errorf("expected error with traceId big-body, got %v", body)
+	}
+
+	// Without a Content-Length the handler finds out by reading
+	req = httptest.NewRequest("POST", "/upload", io.MultiReader(strings.NewReader("0123456789a")))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d for an oversized chunked body, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if stats := endpointMetrics["/upload"]; stats == nil || stats.requestCount != 3 || stats.errorCount != 2 {
+		t.Errorf("expected 3 requests with 2 errors for /upload, got %+v", stats)
+	}
+}
+
+func TestMaxBodyMiddlewareDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	maxBodyMiddleware(0, next).ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 1<<20))))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected no limit when disabled, got status %d", w.Code)
+	}
+}
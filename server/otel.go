@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const meterName = "github.com/yinghanhung/prr-playground/server"
+
+// otelInstruments mirror the Prometheus request metrics as OTel instruments.
+type otelInstruments struct {
+	requests metric.Int64Counter
+	errors   metric.Int64Counter
+	latency  metric.Float64Histogram
+}
+
+// otelMetrics holds the active instruments; it starts out as no-ops so tests
+// and runs without a collector pay nothing.
+var otelMetrics atomic.Pointer[otelInstruments]
+
+func init() {
+	instruments, _ := newOTelInstruments(noop.NewMeterProvider())
+	otelMetrics.Store(instruments)
+}
+
+func newOTelInstruments(mp metric.MeterProvider) (*otelInstruments, error) {
+	meter := mp.Meter(meterName)
+	requests, err := meter.Int64Counter("http.server.request.count",
+		metric.WithDescription("Total HTTP requests"))
+	if err != nil {
+		return nil, err
+	}
+	errs, err := meter.Int64Counter("http.server.error.count",
+		metric.WithDescription("HTTP requests that returned a 4xx or 5xx status"))
+	if err != nil {
+		return nil, err
+	}
+	latency, err := meter.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("HTTP request latency"),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(latencyBuckets...))
+	if err != nil {
+		return nil, err
+	}
+	return &otelInstruments{requests: requests, errors: errs, latency: latency}, nil
+}
+
+// record updates the instruments for one completed request.
+func (m *otelInstruments) record(ctx context.Context, path string, status int, latencyMs float64) {
+	attrs := metric.WithAttributes(attribute.String("path", path))
+	m.requests.Add(ctx, 1, attrs)
+	if status >= 400 {
+		m.errors.Add(ctx, 1, attrs)
+	}
+	m.latency.Record(ctx, latencyMs, attrs)
+}
+
+// initTelemetry sets up OTel metric and trace export when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, returning the tracer for
+// traceMiddleware. Otherwise it leaves the no-op instruments in place and
+// returns a nil tracer, so no spans are made. The exporters read the
+// standard OTEL_EXPORTER_OTLP_* variables themselves. The returned function
+// flushes and stops them.
+func initTelemetry(ctx context.Context) (trace.Tracer, func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create OTLP metric exporter: %w", err)
+	}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	instruments, err := newOTelInstruments(provider)
+	if err != nil {
+		provider.Shutdown(ctx)
+		return nil, nil, fmt.Errorf("create OTel instruments: %w", err)
+	}
+
+	spanExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		provider.Shutdown(ctx)
+		return nil, nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+	}
+	tracerProvider := newTracerProvider(sdktrace.WithBatcher(spanExporter))
+
+	otelMetrics.Store(instruments)
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), provider.Shutdown(ctx))
+	}
+	return tracerProvider.Tracer(meterName), shutdown, nil
+}
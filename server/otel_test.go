@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTelRequestCounter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	instruments, err := newOTelInstruments(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatalf("failed to create instruments: %v", err)
+	}
+	previous := otelMetrics.Swap(instruments)
+	defer otelMetrics.Store(previous)
+
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	for _, path := range []string{"/otel", "/otel", "/missing", metricsPath} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+	sums := map[string]int64{}
+	var histogramCount uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					path, _ := dp.Attributes.Value("path")
+					sums[m.Name+" "+path.AsString()] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					histogramCount += dp.Count
+				}
+			}
+		}
+	}
+
+	if got := sums["http.server.request.count /otel"]; got != 2 {
+		t.Errorf("expected 2 requests for /otel, got %d", got)
+	}
+	if got := sums["http.server.error.count /missing"]; got != 1 {
+		t.Errorf("expected 1 error for /missing, got %d", got)
+	}
+	if got := sums["http.server.request.count "+metricsPath]; got != 0 {
+		t.Errorf("expected scrapes to be skipped, got %d", got)
+	}
+	if histogramCount != 3 {
+		t.Errorf("expected 3 latency observations, got %d", histogramCount)
+	}
+}
+
+func TestInitTelemetryWithoutEndpointIsNoop(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	before := otelMetrics.Load()
+
+	tracer, shutdown, err := initTelemetry(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tracer != nil {
+		t.Error("expected tracing to be disabled")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+	if otelMetrics.Load() != before {
+		t.Error("expected the no-op instruments to stay in place")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promInstruments hold the request metrics as client_golang collectors, for
+// METRICS_CLIENT_GOLANG=true. They cover the request, error and latency
+// series, body byte counters and the in-flight, build_info and uptime
+// gauges; the body read metrics are only in the hand-written format.
+type promInstruments struct {
+	registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	requestBytes  *prometheus.CounterVec
+	responseBytes *prometheus.CounterVec
+}
+
+// promMetrics holds the active instruments, or nil while the hand-written
+// handleMetrics serves /metrics.
+var promMetrics atomic.Pointer[promInstruments]
+
+// newPromInstruments registers the collectors on a fresh registry, bucketing
+// latency by the current latencyBuckets.
+func newPromInstruments() *promInstruments {
+	m := &promInstruments{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests",
+		}, []string{"path"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_errors_total",
+			Help: "Total number of HTTP errors (4xx, 5xx)",
+		}, []string{"path"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_ms",
+			Help:    "Request latency in milliseconds",
+			Buckets: latencyBuckets,
+		}, []string{"path"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_request_bytes_total",
+			Help: "Total bytes read from request bodies",
+		}, []string{"path"}),
+		responseBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_response_bytes_total",
+			Help: "Total bytes written to response bodies",
+		}, []string{"path"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.errors,
+		m.latency,
+		m.requestBytes,
+		m.responseBytes,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being served",
+		}, func() float64 { return float64(inFlightRequests.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "build_info",
+			Help:        "Build metadata of the running server, always 1",
+			ConstLabels: prometheus.Labels{"version": version, "commit": commit, "go_version": runtime.Version()},
+		}, func() float64 { return 1 }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "process_uptime_seconds",
+			Help: "Seconds since the server process started",
+		}, func() float64 { return time.Since(startTime).Seconds() }),
+	)
+	return m
+}
+
+// record updates the collectors for one completed request. A nil
+// *promInstruments records nothing.
+func (m *promInstruments) record(path string, status int, latencyMs float64) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(path).Inc()
+	if status >= 400 {
+		m.errors.WithLabelValues(path).Inc()
+	}
+	m.latency.WithLabelValues(path).Observe(latencyMs)
+}
+
+// recordBytes adds one request's body bytes read and response bytes written.
+func (m *promInstruments) recordBytes(path string, read, written int64) {
+	if m == nil {
+		return
+	}
+	m.requestBytes.WithLabelValues(path).Add(float64(read))
+	m.responseBytes.WithLabelValues(path).Add(float64(written))
+}
+
+// reset drops every recorded series, as if no requests had been served.
+func (m *promInstruments) reset() {
+	if m == nil {
+		return
+	}
+	m.requests.Reset()
+	m.errors.Reset()
+	m.latency.Reset()
+	m.requestBytes.Reset()
+	m.responseBytes.Reset()
+}
+
+// handler serves the registry in the Prometheus exposition format.
+func (m *promInstruments) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPromInstrumentsExposition(t *testing.T) {
+	instruments := newPromInstruments()
+	previous := promMetrics.Swap(instruments)
+	defer promMetrics.Store(previous)
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, instruments.handler())
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/prom", func(w http.ResponseWriter, r *http.Request) {})
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, mux)
+	for _, path := range []string{"/prom", "/prom", "/missing", metricsPath} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", metricsPath, nil))
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("scrape is not valid exposition format: %v", err)
+	}
+
+	for _, name := range []string{"http_requests_total", "http_errors_total", "http_request_duration_ms", "http_request_bytes_total", "http_response_bytes_total", "http_requests_in_flight", "build_info", "process_uptime_seconds"} {
+		if families[name] == nil {
+			t.Errorf("expected metric family %s", name)
+		}
+	}
+	counts := map[string]float64{}
+	for _, m := range families["http_requests_total"].GetMetric() {
+		counts[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+	}
+	if counts["/prom"] != 2 || counts["/missing"] != 1 {
+		t.Errorf("expected 2 requests for /prom and 1 for /missing, got %v", counts)
+	}
+	if _, ok := counts[metricsPath]; ok {
+		t.Error("expected scrapes to be skipped")
+	}
+	errs := families["http_errors_total"].GetMetric()
+	if len(errs) != 1 || errs[0].GetCounter().GetValue() != 1 {
+		t.Errorf("expected 1 error series with value 1, got %v", errs)
+	}
+	var observations uint64
+	for _, m := range families["http_request_duration_ms"].GetMetric() {
+		h := m.GetHistogram()
+		observations += h.GetSampleCount()
+		// The text format lists +Inf as a bucket too
+		if got := len(h.GetBucket()); got != len(latencyBuckets)+1 {
+			t.Errorf("expected %d buckets, got %d", len(latencyBuckets)+1, got)
+		}
+	}
+	if observations != 3 {
+		t.Errorf("expected 3 latency observations, got %d", observations)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// durationVar is a time.Duration that can be read and replaced while
+// requests are using it.
+type durationVar struct{ ns atomic.Int64 }
+
+func newDurationVar(d time.Duration) *durationVar {
+	v := &durationVar{}
+	v.Store(d)
+	return v
+}
+
+func (v *durationVar) Load() time.Duration   { return time.Duration(v.ns.Load()) }
+func (v *durationVar) Store(d time.Duration) { v.ns.Store(int64(d)) }
+
+// reloadableConfig holds the settings a SIGHUP re-reads: the log level and
+// hello delay. Both are swapped atomically, so in-flight requests see
+// either the old or the new value.
+type reloadableConfig struct {
+	// path is the config file re-read on reload, as at startup; an
+	// environment variable still wins over its value
+	path       string
+	logLevel   slog.LevelVar
+	helloDelay durationVar
+}
+
+// apply switches to the reloadable settings in cfg.
+func (c *reloadableConfig) apply(cfg serverConfig) {
+	c.logLevel.Set(cfg.logLevel)
+	c.helloDelay.Store(cfg.helloDelay)
+}
+
+// load re-resolves the configuration from c.path and the environment with
+// loadConfig and applies it. On any error both settings are left unchanged.
+func (c *reloadableConfig) load() error {
+	cfg, err := loadConfig(c.path, os.Getenv)
+	if err != nil {
+		return err
+	}
+	c.apply(cfg)
+	return nil
+}
+
+// watchReload reloads c each time a signal arrives on sig, until sig is
+// closed, logging the outcome.
+func watchReload(sig <-chan os.Signal, c *reloadableConfig, logger *slog.Logger) {
+	for range sig {
+		if err := c.load(); err != nil {
+			logger.Error("config reload failed, keeping previous values", "error", err)
+			continue
+		}
+		logger.Info("config reloaded", "logLevel", c.logLevel.Level().String(), "helloDelay", c.helloDelay.Load().String())
+	}
+}
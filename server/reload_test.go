@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	// Unset, so the file's values apply
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("HELLO_DELAY", "")
+	path := writeConfigFile(t, "logLevel: info\nhelloDelay: 50ms\n")
+	c := &reloadableConfig{path: path}
+	if err := c.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go watchReload(hup, c, slog.New(newFileHandler(io.Discard, nil)))
+
+	if err := os.WriteFile(path, []byte("logLevel: debug\nhelloDelay: 5ms\n"), 0o600); err != nil {
+		t.Fatalf("failed to rewrite config file: %v", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("failed to send SIGHUP: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for c.logLevel.Level() != slog.LevelDebug || c.helloDelay.Load() != 5*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected debug and 5ms after SIGHUP, got %v and %v", c.logLevel.Level(), c.helloDelay.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReloadEnvironmentWinsOverFile(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("HELLO_DELAY", "")
+	path := writeConfigFile(t, "logLevel: debug\nhelloDelay: 5ms\n")
+	c := &reloadableConfig{path: path}
+	if err := c.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.logLevel.Level() != slog.LevelError || c.helloDelay.Load() != 5*time.Millisecond {
+		t.Errorf("expected error from the environment and 5ms from the file, got %v and %v", c.logLevel.Level(), c.helloDelay.Load())
+	}
+}
+
+func TestReloadKeepsValuesOnError(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("HELLO_DELAY", "")
+	path := writeConfigFile(t, "logLevel: warn\nhelloDelay: 10ms\n")
+	c := &reloadableConfig{path: path}
+	if err := c.load(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("logLevel: loud\nhelloDelay: 20ms\n"), 0o600); err != nil {
+		t.Fatalf("failed to rewrite config file: %v", err)
+	}
+	if err := c.load(); err == nil {
+		t.Fatal("expected error for an invalid logLevel")
+	}
+	if c.logLevel.Level() != slog.LevelWarn || c.helloDelay.Load() != 10*time.Millisecond {
+		t.Errorf("expected warn and 10ms to be kept, got %v and %v", c.logLevel.Level(), c.helloDelay.Load())
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+const metricsResetPath = "/metrics/reset"
+
+// resetMetrics drops every path's request metrics, returning how many paths
+// were cleared. In-flight requests are recorded afresh when they finish.
+func resetMetrics() int {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	n := len(endpointMetrics)
+	endpointMetrics = map[string]*endpointStats{}
+	promMetrics.Load().reset()
+	return n
+}
+
+// handleMetricsReset zeroes the metrics on POST so test scenarios can start
+// from a clean slate without restarting the server.
+func handleMetricsReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	traceID, _ := r.Context().Value(traceKey).(string)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":  "reset",
+		"paths":   resetMetrics(),
+		"traceId": traceID,
+	})
+}
+
+// registerMetricsReset adds POST /metrics/reset when ENABLE_METRICS_RESET=true.
+// It is off by default so a stray request can't wipe production metrics.
+func registerMetricsReset(mux *http.ServeMux) bool {
+	if os.Getenv("ENABLE_METRICS_RESET") != "true" {
+		return false
+	}
+	mux.HandleFunc(metricsResetPath, handleMetricsReset)
+	return true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsReset(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+	t.Setenv("ENABLE_METRICS_RESET", "true")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, handleMetrics)
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if !registerMetricsReset(mux) {
+		t.Fatal("expected registerMetricsReset to report true")
+	}
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{}, mux)
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", metricsResetPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	var resp struct {
+		Status  string `json:"status"`
+		Paths   int    `json:"paths"`
+		TraceID string `json:"traceId"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected JSON body: %v", err)
+	}
+	if resp.Status != "reset" || resp.Paths != 1 || resp.TraceID == "" {
+		t.Errorf("expected reset of 1 path with a trace ID, got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", metricsPath, nil))
+	for _, series := range []string{`http_requests_total{path="/fail"}`, `http_errors_total{path="/fail"}`, "http_request_duration_ms_bucket", metricsResetPath} {
+		if strings.Contains(rec.Body.String(), series) {
+			t.Errorf("expected no %s after reset, got:\n%s", series, rec.Body.String())
+		}
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if stats := endpointMetrics["/fail"]; stats == nil || stats.requestCount != 1 || stats.errorCount != 1 {
+		t.Errorf("expected counting to start again from zero, got %+v", stats)
+	}
+}
+
+func TestMetricsResetClearsPromInstruments(t *testing.T) {
+	instruments := newPromInstruments()
+	previous := promMetrics.Swap(instruments)
+	defer promMetrics.Store(previous)
+
+	instruments.record("/hello", http.StatusInternalServerError, 12)
+	instruments.recordBytes("/hello", 10, 20)
+	resetMetrics()
+
+	rec := httptest.NewRecorder()
+	instruments.handler().ServeHTTP(rec, httptest.NewRequest("GET", metricsPath, nil))
+	if strings.Contains(rec.Body.String(), `path="/hello"`) {
+		t.Errorf("expected no /hello series after reset, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetricsResetRejectsGet(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{"/hello": {requestCount: 1, bucketCounts: make([]int64, len(latencyBuckets))}}
+	metricsMutex.Unlock()
+
+	rec := httptest.NewRecorder()
+	handleMetricsReset(rec, httptest.NewRequest("GET", metricsResetPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != http.MethodPost {
+		t.Errorf("expected Allow: POST, got %q", allow)
+	}
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	if endpointMetrics["/hello"] == nil {
+		t.Error("expected GET to leave metrics alone")
+	}
+}
+
+func TestRegisterMetricsReset(t *testing.T) {
+	for _, enabled := range []string{"", "false", "true"} {
+		t.Run("ENABLE_METRICS_RESET="+enabled, func(t *testing.T) {
+			t.Setenv("ENABLE_METRICS_RESET", enabled)
+			mux := http.NewServeMux()
+			registered := registerMetricsReset(mux)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("POST", metricsResetPath, nil))
+			want := http.StatusNotFound
+			if enabled == "true" {
+				want = http.StatusOK
+			}
+			if rec.Code != want {
+				t.Errorf("expected %d, got %d", want, rec.Code)
+			}
+			if registered != (enabled == "true") {
+				t.Errorf("expected registerMetricsReset to report %v, got %v", enabled == "true", registered)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingWriter appends to a log file and rotates it once it would grow past
+// maxBytes: app.log becomes app.log.1, app.log.1 becomes app.log.2 and so on,
+// keeping at most maxBackups old files. A maxBytes of zero disables rotation.
+// It is safe for concurrent use.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingWriter(path string, maxBytes int64, maxBackups int) (*rotatingWriter, error) {
+	f, err := ensureLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingWriter{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+		file:       f,
+		size:       info.Size(),
+	}, nil
+}
+
+// Write writes p to the active file, rotating first if p would push it past
+// maxBytes. A single record is never split across files.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one and starts a fresh active file. If the
+// backups can't be shifted the current file is reopened so logging carries on.
+// Callers must hold w.mu.
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	shiftErr := w.shiftBackups()
+
+	f, err := ensureLogFile(w.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	return shiftErr
+}
+
+func (w *rotatingWriter) shiftBackups() error {
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	// The oldest backup falls off the end
+	os.Remove(w.backupPath(w.maxBackups))
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(w.backupPath(i), w.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(w.path, w.backupPath(1))
+}
+
+func (w *rotatingWriter) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+func (w *rotatingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Sync()
+}
+
+// probe checks that logging can still reach the disk: the active file must
+// sync, and a small probe file written next to it must write and sync. The
+// probe file is removed afterwards. Only the sync of the active file holds
+// w.mu, so a deep health check doesn't stall logging for the rest.
+func (w *rotatingWriter) probe() error {
+	if err := w.Sync(); err != nil {
+		return fmt.Errorf("sync log file: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(w.path), ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write([]byte("ok\n")); err != nil {
+		return fmt.Errorf("write probe file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync probe file: %w", err)
+	}
+	return nil
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotatingWriterRotatesPastThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := newRotatingWriter(path, 100, 2)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	defer w.Close()
+
+	line := []byte(strings.Repeat("x", 39) + "\n") // 40 bytes
+	for i := 0; i < 8; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+	}
+
+	// 8 lines at 2 per file: app.log.3 would exist without the backup limit
+	for _, name := range []string{"app.log.1", "app.log.2"} {
+		info, err := os.Stat(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Errorf("expected backup %s: %v", name, err)
+			continue
+		}
+		if info.Size() != 80 {
+			t.Errorf("expected %s to hold 80 bytes, got %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, found app.log.3 (err=%v)", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read active file: %v", err)
+	}
+	if len(data) != 80 {
+		t.Errorf("expected active file to restart after rotation (80 bytes), got %d", len(data))
+	}
+}
+
+func TestRotatingWriterWithoutBackupsTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := newRotatingWriter(path, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("first-line\n"))
+	w.Write([]byte("second\n"))
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "second\n" {
+		t.Errorf("expected only the latest record, got %q", data)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected no backups, got err=%v", err)
+	}
+}
+
+func TestRotatingWriterConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := newRotatingWriter(path, 1000, 100)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				fmt.Fprintf(w, "goroutine=%d line=%03d\n", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	w.Close()
+
+	// Every record must land intact in exactly one file
+	matches, _ := filepath.Glob(path + "*")
+	var all []byte
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", m, err)
+		}
+		if len(data) > 1000 {
+			t.Errorf("%s exceeds max size: %d bytes", m, len(data))
+		}
+		all = append(all, data...)
+	}
+	lines := bytes.Split(bytes.TrimSpace(all), []byte("\n"))
+	if len(lines) != 400 {
+		t.Fatalf("expected 400 lines across files, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if !bytes.HasPrefix(line, []byte("goroutine=")) || len(line) != len("goroutine=0 line=000") {
+			t.Errorf("corrupt line %q", line)
+		}
+	}
+}
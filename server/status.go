@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// statusDistribution picks response statuses at random with configured
+// weights, from a seeded generator so a run's sequence of statuses can be
+// reproduced. A nil distribution always picks 200.
+type statusDistribution struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	statuses []int
+	// cumulative[i] is the sum of the weights of statuses[:i+1]
+	cumulative []int
+}
+
+// parseStatusDistribution parses STATUS_DISTRIBUTION, a comma-separated list
+// of status:weight pairs such as "200:90,500:8,429:2". Weights are relative
+// and need not add up to 100.
+func parseStatusDistribution(spec string, seed uint64) (*statusDistribution, error) {
+	d := &statusDistribution{rng: rand.New(rand.NewPCG(seed, seed))}
+	total := 0
+	for _, pair := range strings.Split(spec, ",") {
+		code, weight, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q: want status:weight", pair)
+		}
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 200 || status > 599 {
+			return nil, fmt.Errorf("invalid status %q", code)
+		}
+		if status == http.StatusNoContent || status == http.StatusNotModified {
+			return nil, fmt.Errorf("status %d cannot carry a JSON body", status)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q for status %d", weight, status)
+		}
+		total += w
+		d.statuses = append(d.statuses, status)
+		d.cumulative = append(d.cumulative, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("weights add up to zero")
+	}
+	return d, nil
+}
+
+// Pick returns the status for the next response.
+func (d *statusDistribution) Pick() int {
+	if d == nil {
+		return http.StatusOK
+	}
+	d.mu.Lock()
+	n := d.rng.IntN(d.cumulative[len(d.cumulative)-1])
+	d.mu.Unlock()
+
+	for i, c := range d.cumulative {
+		if n < c {
+			return d.statuses[i]
+		}
+	}
+	return d.statuses[len(d.statuses)-1]
+}
+
+// handleStatus answers /status/{code} with that status and a JSON body
+// carrying the trace ID, so clients can drive their retry and error paths
+// deterministically. 1xx codes are rejected along with anything outside
+// 100-599: they are informational and can't end a response.
+func handleStatus(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		traceID, _ := r.Context().Value(traceKey).(string)
+		w.Header().Set("Content-Type", "application/json")
+
+		v := r.PathValue("code")
+		status, err := strconv.Atoi(v)
+		if err != nil || status < 200 || status > 599 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   fmt.Sprintf("invalid status %q: must be between 200 and 599", v),
+				"traceId": traceID,
+			})
+			return
+		}
+
+		w.WriteHeader(status)
+		// These statuses must not carry a body
+		if status != http.StatusNoContent && status != http.StatusNotModified {
+			json.NewEncoder(w).Encode(map[string]any{
+				"status":     status,
+				"statusText": http.StatusText(status),
+				"traceId":    traceID,
+			})
+		}
+		logEvent(logger, logEntry{
+			TraceID: traceID,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Status:  status,
+			Message: "handler finished",
+			Level:   slog.LevelDebug,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestStatusDistributionMix(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	statuses, err := parseStatusDistribution("200:90,500:8,429:2", 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	handler := traceMiddleware(logger, traceOptions{}, handleHello(logger, newDurationVar(0), statuses, 0))
+
+	const n = 5000
+	counts := map[int]int{}
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+		counts[w.Code]++
+
+		if w.Code != http.StatusOK {
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] != http.StatusText(w.Code) {
+				t.Fatalf("expected a JSON error body for status %d, got %q (err=%v)", w.Code, w.Body.String(), err)
+			}
+		}
+	}
+
+	for status, want := range map[int]float64{200: 0.90, 500: 0.08, 429: 0.02} {
+		if got := float64(counts[status]) / n; math.Abs(got-want) > 0.015 {
+			t.Errorf("status %d: expected share ~%.2f, got %.3f", status, want, got)
+		}
+	}
+	if len(counts) != 3 {
+		t.Errorf("expected only configured statuses, got %v", counts)
+	}
+
+	metricsMutex.RLock()
+	stats := endpointMetrics["/hello"]
+	metricsMutex.RUnlock()
+	if stats.requestCount != n {
+		t.Errorf("expected %d requests counted, got %d", n, stats.requestCount)
+	}
+	if want := int64(counts[500] + counts[429]); stats.errorCount != want {
+		t.Errorf("expected %d errors counted, got %d", want, stats.errorCount)
+	}
+}
+
+func TestStatusDistributionIsReproducible(t *testing.T) {
+	a, _ := parseStatusDistribution("200:50,503:50", 7)
+	b, _ := parseStatusDistribution("200:50,503:50", 7)
+	for i := 0; i < 100; i++ {
+		if x, y := a.Pick(), b.Pick(); x != y {
+			t.Fatalf("pick %d: expected identical sequences for the same seed, got %d and %d", i, x, y)
+		}
+	}
+
+	var nilDist *statusDistribution
+	if got := nilDist.Pick(); got != http.StatusOK {
+		t.Errorf("expected nil distribution to pick 200, got %d", got)
+	}
+}
+
+func TestParseStatusDistributionRejectsBadInput(t *testing.T) {
+	for _, spec := range []string{"200", "abc:1", "200:x", "200:-1", "99:1", "600:1", "204:1", "200:0,500:0"} {
+		if _, err := parseStatusDistribution(spec, 1); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle("/status/{code}", handleStatus(logger))
+	handler := traceMiddleware(logger, traceOptions{}, mux)
+
+	for _, status := range []int{200, 201, 204, 400, 429, 500, 503, 599} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/status/"+strconv.Itoa(status), nil))
+		if w.Code != status {
+			t.Errorf("expected status %d, got %d", status, w.Code)
+		}
+		if status == http.StatusNoContent {
+			if w.Body.Len() != 0 {
+				t.Errorf("expected no body for 204, got %q", w.Body.String())
+			}
+			continue
+		}
+		var body struct {
+			Status     int    `json:"status"`
+			StatusText string `json:"statusText"`
+			TraceID    string `json:"traceId"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("status %d: expected JSON body: %v", status, err)
+		}
+		if body.Status != status || body.StatusText != http.StatusText(status) {
+			t.Errorf("expected body for status %d, got %+v", status, body)
+		}
+		if body.TraceID == "" || body.TraceID != w.Header().Get("X-Trace-Id") {
+			t.Errorf("expected body traceId to match X-Trace-Id %q, got %q", w.Header().Get("X-Trace-Id"), body.TraceID)
+		}
+	}
+}
+
+func TestHandleStatusRejectsInvalidCode(t *testing.T) {
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle("/status/{code}", handleStatus(logger))
+	handler := traceMiddleware(logger, traceOptions{}, mux)
+
+	for _, code := range []string{"abc", "99", "100", "199", "600", "-500", "5e2"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/status/"+code, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", code, http.StatusBadRequest, w.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] == "" || body["traceId"] == "" {
+			t.Errorf("%s: expected a JSON error with a trace ID, got %q (err=%v)", code, w.Body.String(), err)
+		}
+	}
+}
+
+func TestHandleStatusCountsErrors(t *testing.T) {
+	metricsMutex.Lock()
+	endpointMetrics = map[string]*endpointStats{}
+	metricsMutex.Unlock()
+
+	logger := slog.New(newFileHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle("/status/{code}", handleStatus(logger))
+	handler := traceMiddleware(logger, traceOptions{}, mux)
+	for _, path := range []string{"/status/500", "/status/500", "/status/429", "/status/200"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	for path, want := range map[string][2]int64{"/status/500": {2, 2}, "/status/429": {1, 1}, "/status/200": {1, 0}} {
+		stats := endpointMetrics[path]
+		if stats == nil || stats.requestCount != want[0] || stats.errorCount != want[1] {
+			t.Errorf("%s: expected %d requests and %d errors, got %+v", path, want[0], want[1], stats)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDGenerator gives each request's root span the request's own trace
+// ID, so spans line up with the X-Trace-Id in responses and logs. IDs that
+// aren't 128-bit hex (with or without UUID dashes) get a random trace ID.
+type requestIDGenerator struct{}
+
+func (requestIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	id, _ := ctx.Value(traceKey).(string)
+	traceID, ok := otelTraceID(id)
+	for !ok {
+		var b [16]byte
+		for i := range b {
+			b[i] = byte(rand.Uint32())
+		}
+		traceID, ok = b, trace.TraceID(b).IsValid()
+	}
+	return traceID, newSpanID()
+}
+
+func (requestIDGenerator) NewSpanID(context.Context, trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	for {
+		var id trace.SpanID
+		n := rand.Uint64()
+		for i := range id {
+			id[i] = byte(n >> (8 * i))
+		}
+		if id.IsValid() {
+			return id
+		}
+	}
+}
+
+// otelTraceID parses a request trace ID, either a traceparent trace-id or a
+// UUID, as an OTel trace ID.
+func otelTraceID(id string) (trace.TraceID, bool) {
+	var traceID trace.TraceID
+	b, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+	if err != nil || len(b) != len(traceID) {
+		return traceID, false
+	}
+	copy(traceID[:], b)
+	return traceID, traceID.IsValid()
+}
+
+// newTracerProvider returns a tracer provider whose root spans take their
+// trace ID from the request, as set by traceMiddleware.
+func newTracerProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(append(opts, sdktrace.WithIDGenerator(requestIDGenerator{}))...)
+}
+
+// startSpan starts a server span for r as a child of any incoming W3C
+// traceparent, named after route (the key its metrics are recorded under)
+// so span names stay bounded; the raw path is only an attribute. It returns
+// a nil span when tracer is nil, i.e. tracing is off.
+func startSpan(ctx context.Context, tracer trace.Tracer, r *http.Request, route string) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx = propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", r.URL.Path),
+		))
+}
+
+// endSpan records the outcome of a request on span and ends it; server
+// errors mark the span as failed.
+func endSpan(span trace.Span, status int, latency time.Duration) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", status),
+		attribute.Float64("http.server.latency_ms", float64(latency)/float64(time.Millisecond)),
+	)
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceMiddlewareSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	opts := traceOptions{tracer: provider.Tracer(meterName), routes: mux}
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), opts, mux)
+
+	const traceID = "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"
+	req := httptest.NewRequest("GET", "/ok", nil)
+	req.Header.Set("X-Trace-Id", traceID)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/fail", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/42", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/wp-login.php", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("expected 1 span per request, got %d", len(spans))
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		code   codes.Code
+	}{
+		{"GET /ok", "GET", "/ok", http.StatusOK, codes.Unset},
+		{"POST /fail", "POST", "/fail", http.StatusInternalServerError, codes.Error},
+		// Named after the route pattern, not the path
+		{"GET /items/{id}", "GET", "/items/42", http.StatusOK, codes.Unset},
+		{"GET " + unmatchedRoute, "GET", "/wp-login.php", http.StatusNotFound, codes.Unset},
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name != tt.name || span.SpanKind != trace.SpanKindServer {
+			t.Errorf("span %d: expected server span %q, got %s span %q", i, tt.name, span.SpanKind, span.Name)
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		if got := attrs["http.request.method"].AsString(); got != tt.method {
+			t.Errorf("span %d: expected method %s, got %q", i, tt.method, got)
+		}
+		if got := attrs["url.path"].AsString(); got != tt.path {
+			t.Errorf("span %d: expected path %s, got %q", i, tt.path, got)
+		}
+		if got := attrs["http.response.status_code"].AsInt64(); got != int64(tt.status) {
+			t.Errorf("span %d: expected status %d, got %d", i, tt.status, got)
+		}
+		if _, ok := attrs["http.server.latency_ms"]; !ok {
+			t.Errorf("span %d: expected a latency attribute", i)
+		}
+		if span.Status.Code != tt.code {
+			t.Errorf("span %d: expected status code %v, got %v", i, tt.code, span.Status.Code)
+		}
+	}
+	if got, want := spans[0].SpanContext.TraceID().String(), strings.ReplaceAll(traceID, "-", ""); got != want {
+		t.Errorf("expected the span to reuse the request trace ID %s, got %s", want, got)
+	}
+}
+
+func TestTraceMiddlewareSpanJoinsTraceparent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	var handlerTraceID string
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{tracer: provider.Tracer(meterName)},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerTraceID = trace.SpanContextFromContext(r.Context()).TraceID().String()
+		}))
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.SpanContext.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" || span.Parent.SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("expected a child of the incoming traceparent, got trace %s parent %s", span.SpanContext.TraceID(), span.Parent.SpanID())
+	}
+	if handlerTraceID != span.SpanContext.TraceID().String() {
+		t.Errorf("expected the span in the handler's context, got trace %s", handlerTraceID)
+	}
+}
+
+func TestTraceMiddlewareWithoutTracer(t *testing.T) {
+	handler := traceMiddleware(slog.New(newFileHandler(io.Discard, nil)), traceOptions{},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if trace.SpanContextFromContext(r.Context()).IsValid() {
+				t.Error("expected no span when tracing is off")
+			}
+		}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+}
+
+func TestOTelTraceID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"4bf92f35-77b3-4da6-a3ce-929d0e0e4736": true,
+		"0af7651916cd43dd8448eb211c80319c":     true,
+		"00000000-0000-0000-0000-000000000000": false,
+		"not-a-trace-id":                       false,
+		"":                                     false,
+	} {
+		if _, ok := otelTraceID(id); ok != want {
+			t.Errorf("otelTraceID(%q): expected %v, got %v", id, want, ok)
+		}
+	}
+}
+
+func TestRequestIDGeneratorFallsBackToRandom(t *testing.T) {
+	ctx := context.WithValue(context.Background(), traceKey, "custom-id")
+	traceID, spanID := requestIDGenerator{}.NewIDs(ctx)
+	if !traceID.IsValid() || !spanID.IsValid() {
+		t.Errorf("expected valid random IDs, got %s and %s", traceID, spanID)
+	}
+}